@@ -1,17 +1,19 @@
 package aof
 
 import (
+	"errors"
 	"go_redis_write/config"
 	databaseface "go_redis_write/interface/database"
 	"go_redis_write/lib/logger"
 	"go_redis_write/lib/utils"
 	"go_redis_write/resp/connection"
-	"go_redis_write/resp/parser"
 	"go_redis_write/resp/reply"
-	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
@@ -21,17 +23,33 @@ const (
 	aofQueueSize = 1 << 16
 )
 
+// appendfsync policies
+const (
+	// FsyncAlways syncs after every write, AddAof returns after the command is durable
+	FsyncAlways = "always"
+	// FsyncEverySec syncs once per second in background
+	FsyncEverySec = "everysec"
+	// FsyncNo leaves syncing to the operating system
+	FsyncNo = "no"
+)
+
 type payload struct { //一个操作的数据结构
 	cmdLine CmdLine
 	dbIndex int
+	wg      *sync.WaitGroup // not nil in always mode, done after the command is synced
 }
 
 // AofHandler receive msgs from channel and write to AOF file
 type AofHandler struct {
-	db          databaseface.Database
-	aofChan     chan *payload //AOF缓冲区
-	aofFile     *os.File      //文件
-	aofFilename string
+	db      databaseface.DBEngine
+	aofChan chan *payload //AOF缓冲区
+	aofFile *os.File      //正在写入的incr文件
+	// directory of aof files and manifest
+	dir string
+	// prefix of aof file names, e.g. appendonly.aof
+	prefix string
+	// files to load, guarded by pausingAof after handler started
+	manifest *manifest
 	//当 aof 任务完成并准备关闭时，aof goroutine 将通过此channel将 msg 发送到 main goroutine
 	// aof goroutine will send msg to main goroutine through this channel when aof tasks finished and ready to shutdown
 	aofFinished chan struct{}
@@ -39,68 +57,208 @@ type AofHandler struct {
 	// pause aof for start/finish aof rewrite progress
 	pausingAof sync.RWMutex
 	currentDB  int
+	// tmpDBMaker creates an empty database to load aof while rewriting
+	tmpDBMaker func() databaseface.DBEngine
+	// 1 while rewriting, prevents concurrent rewrites
+	rewriting   int32
+	rewriteWait sync.WaitGroup
+	// aof size after last load or rewrite, used by auto rewrite
+	baseSize int64
+	// appendfsync policy
+	fsync string
+	// closed to stop auto rewrite and everysec fsync goroutines
+	stopBackground chan struct{}
+	// senders of aofChan hold the read lock, Close sets closed under the write lock before closing aofChan
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+// MakeExpireCmd generates command line to set expiration for the given key
+// it uses absolute unix time in milliseconds so replaying aof later won't extend the ttl
+func MakeExpireCmd(key string, expireAt time.Time) CmdLine {
+	return utils.ToCmdLine("PEXPIREAT", key, strconv.FormatInt(expireAt.UnixNano()/int64(time.Millisecond), 10))
 }
 
 //创建AOF
 
 // NewAOFHandler creates a new aof.AofHandler
-func NewAOFHandler(db databaseface.Database) (*AofHandler, error) {
+func NewAOFHandler(db databaseface.DBEngine, tmpDBMaker func() databaseface.DBEngine) (*AofHandler, error) {
 	handler := &AofHandler{}
-	handler.aofFilename = config.Properties.AppendFilename
+	handler.dir = config.Properties.AppendDirname
+	handler.prefix = filepath.Base(config.Properties.AppendFilename)
 	handler.db = db
+	handler.tmpDBMaker = tmpDBMaker
+	handler.fsync = strings.ToLower(config.Properties.AppendFsync)
+	if handler.fsync != FsyncAlways && handler.fsync != FsyncNo {
+		if handler.fsync != FsyncEverySec {
+			logger.Warn("unknown appendfsync policy '" + config.Properties.AppendFsync + "', use everysec")
+		}
+		handler.fsync = FsyncEverySec
+	}
+	err := os.MkdirAll(handler.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	handler.manifest, err = handler.openManifest()
+	if err != nil {
+		return nil, err
+	}
 	//LoadAOF
-	handler.LoadAof(0)
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	err = handler.LoadAof()
+	if err != nil {
+		return nil, err
+	}
+	aofFile, err := os.OpenFile(handler.path(handler.manifest.lastIncr().name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		return nil, err
 	}
 	handler.aofFile = aofFile
+	handler.currentDB = -1 // db of the tail of incr file is unknown, select before the first command
 	handler.aofChan = make(chan *payload, aofQueueSize)
 	handler.aofFinished = make(chan struct{})
 	go func() {
 		handler.handleAof() //开一个协程不断监听AOF
 	}()
+	handler.updateBaseSize()
+	handler.stopBackground = make(chan struct{})
+	handler.startAutoRewrite(handler.stopBackground)
+	if handler.fsync == FsyncEverySec {
+		handler.startFsyncEverySec(handler.stopBackground)
+	}
 	return handler, nil
 }
 
+func (handler *AofHandler) path(name string) string {
+	return filepath.Join(handler.dir, name)
+}
+
+// openManifest reads manifest in aof directory, or creates one if not exists.
+// aof file of old versions is moved into aof directory as the base file.
+func (handler *AofHandler) openManifest() (*manifest, error) {
+	manifestFile := handler.path(manifestName(handler.prefix))
+	m, err := readManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && m.lastIncr() != nil {
+		return m, nil
+	}
+	if m == nil {
+		m = &manifest{}
+		legacy := config.Properties.AppendFilename
+		if info, err := os.Stat(legacy); err == nil && !info.IsDir() {
+			m.base = &aofInfo{name: baseName(handler.prefix, 1, false), seq: 1, fileType: aofFileTypeBase}
+			err = os.Rename(legacy, handler.path(m.base.name))
+			if err != nil {
+				return nil, err
+			}
+			logger.Info("moved " + legacy + " into " + handler.dir + " as base aof file")
+		}
+	}
+	incr := &aofInfo{name: incrName(handler.prefix, m.nextIncrSeq()), seq: m.nextIncrSeq(), fileType: aofFileTypeIncr}
+	file, err := os.OpenFile(handler.path(incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_ = file.Close()
+	m.incrs = append(m.incrs, incr)
+	err = writeManifest(manifestFile, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // AddAof send command to aof goroutine through channel
+// in always mode, it blocks until the command has been written and synced
 func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
 	if config.Properties.AppendOnly && handler.aofChan != nil { //判断开不开启AOF
-		handler.aofChan <- &payload{
+		p := &payload{
 			cmdLine: cmdLine,
 			dbIndex: dbIndex,
 		}
+		if handler.fsync == FsyncAlways {
+			p.wg = &sync.WaitGroup{}
+			p.wg.Add(1)
+		}
+		handler.closeMu.RLock()
+		if handler.closed { //关闭之后的命令直接丢弃，避免向已关闭的channel发送
+			handler.closeMu.RUnlock()
+			return
+		}
+		handler.aofChan <- p
+		handler.closeMu.RUnlock()
+		if p.wg != nil {
+			p.wg.Wait()
+		}
 	}
 }
 
 // handleAof listen aof channel and write into file
 func (handler *AofHandler) handleAof() {
 	// serialized execution
-	handler.currentDB = 0
 	for p := range handler.aofChan { //不断监听这个Channel
-		handler.pausingAof.RLock()          // prevent other goroutines from pausing aof
-		if p.dbIndex != handler.currentDB { //如果db不一样则需要去调整一下
-			// select db
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				logger.Warn(err)
-				continue // skip this command
-			}
-			handler.currentDB = p.dbIndex //改一下当前的DB
+		handler.writeAof(p)
+		if p.wg != nil {
+			p.wg.Done()
 		}
-		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
+	}
+	handler.aofFinished <- struct{}{}
+}
+
+// writeAof writes a command into aof file, and syncs it in always mode
+func (handler *AofHandler) writeAof(p *payload) {
+	handler.pausingAof.RLock() // prevent other goroutines from pausing aof
+	defer handler.pausingAof.RUnlock()
+	if p.dbIndex != handler.currentDB { //如果db不一样则需要去调整一下
+		// select db
+		data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
 		_, err := handler.aofFile.Write(data)
 		if err != nil {
 			logger.Warn(err)
+			return // skip this command
 		}
-		handler.pausingAof.RUnlock()
+		handler.currentDB = p.dbIndex //改一下当前的DB
 	}
-	handler.aofFinished <- struct{}{}
+	data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
+	_, err := handler.aofFile.Write(data)
+	if err != nil {
+		logger.Warn(err)
+		return
+	}
+	if handler.fsync == FsyncAlways {
+		err = handler.aofFile.Sync()
+		if err != nil {
+			logger.Warn(err)
+		}
+	}
+}
+
+// startFsyncEverySec syncs aof file once per second
+func (handler *AofHandler) startFsyncEverySec(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				handler.pausingAof.RLock() // aof file may be replaced by rewrite
+				err := handler.aofFile.Sync()
+				handler.pausingAof.RUnlock()
+				if err != nil {
+					logger.Warn(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
 }
 
-// LoadAof read aof file
-func (handler *AofHandler) LoadAof(maxBytes int) {
+// LoadAof reads all aof files listed in manifest in order
+// only the last file may be truncated, it is fixed by truncating to the last valid command if aof-load-truncated is on.
+// invalid content in the middle of a file is refused unless aof-load-corrupted is on.
+func (handler *AofHandler) LoadAof() error {
 	// delete aofChan to prevent write again
 	aofChan := handler.aofChan
 	handler.aofChan = nil
@@ -108,54 +266,71 @@ func (handler *AofHandler) LoadAof(maxBytes int) {
 		handler.aofChan = aofChan
 	}(aofChan)
 
-	file, err := os.Open(handler.aofFilename)
-	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return
+	files := handler.manifest.files()
+	for i, info := range files {
+		err := handler.loadFile(handler.path(info.name), i == len(files)-1)
+		if err != nil {
+			return err
 		}
-		logger.Warn(err)
-		return
 	}
-	defer file.Close() //打开就要关闭
+	return nil
+}
 
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
-	}
-	ch := parser.ParseStream(reader)
+// loadFile reads an aof file, which may begin with a rdb preamble
+func (handler *AofHandler) loadFile(filename string, last bool) error {
 	fakeConn := &connection.FakeConn{} // only used for save dbIndex
-	for p := range ch {
-		if p.Err != nil {
-			if p.Err == io.EOF {
-				break
-			}
-			logger.Error("parse error: " + p.Err.Error())
-			continue
+	err := readFile(filename, handler.db.LoadEntity, func(cmdLine CmdLine) {
+		ret := handler.db.Exec(fakeConn, cmdLine)
+		if reply.IsErrorReply(ret) {
+			logger.Error("exec err: " + string(ret.ToBytes()))
 		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+	})
+	formatErr, ok := err.(*FormatError)
+	if !ok || formatErr.Preamble {
+		return err
+	}
+	if formatErr.Truncated {
+		if !config.Properties.AofLoadTruncated {
+			return errors.New(formatErr.Error() + ", use aof-check --fix or set aof-load-truncated to yes")
 		}
-		r, ok := p.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk reply")
-			continue
+		if !last {
+			return errors.New(formatErr.Error() + ", only the last aof file can be truncated")
 		}
-		ret := handler.db.Exec(fakeConn, r.Args)
-		if reply.IsErrorReply(ret) {
-			logger.Error("exec err", err)
+		logger.Warn(formatErr.Error() + ", the incomplete command is discarded")
+	} else {
+		// 文件中间损坏时截断会丢掉后面的有效命令，默认和redis一样拒绝启动
+		if !config.Properties.AofLoadCorrupted {
+			return errors.New(formatErr.Error() + ", use aof-check --fix or set aof-load-corrupted to yes to discard content after the offset")
 		}
+		logger.Warn(formatErr.Error() + ", aof-load-corrupted is on, content after it is discarded")
+	}
+	// truncate the file so that following commands won't be appended after invalid content
+	err = os.Truncate(filename, formatErr.Offset)
+	if err != nil {
+		return err
 	}
+	logger.Info("truncated " + filename + " to " + strconv.FormatInt(formatErr.Offset, 10) + " bytes")
+	return nil
 }
 
 // Close gracefully stops aof persistence procedure
 func (handler *AofHandler) Close() {
+	if handler.stopBackground != nil {
+		close(handler.stopBackground)
+		handler.stopBackground = nil
+	}
+	handler.rewriteWait.Wait()
 	if handler.aofFile != nil {
+		handler.closeMu.Lock() // wait for senders in AddAof
+		handler.closed = true
 		close(handler.aofChan)
+		handler.closeMu.Unlock()
 		<-handler.aofFinished // wait for aof finished
-		err := handler.aofFile.Close()
+		err := handler.aofFile.Sync()
+		if err != nil {
+			logger.Warn(err)
+		}
+		err = handler.aofFile.Close()
 		if err != nil {
 			logger.Warn(err)
 		}
@@ -0,0 +1,200 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	databaseface "go_redis_write/interface/database"
+	"go_redis_write/rdb"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//AOF文件的读取：开头可能是rdb格式的快照，后面是一条条RESP格式的命令，
+//读取时记录最后一条完整命令的结束位置，这样文件被截断或者损坏时可以报告位置，也可以截断到这个位置来修复
+
+// FormatError describes the invalid content of an aof file
+type FormatError struct {
+	Filename string
+	// Offset is the end of the last valid command, content after it is invalid
+	Offset int64
+	// Truncated is true if the file ends in the middle of a command, which is usually caused by crash while writing
+	Truncated bool
+	// Preamble is true if the rdb preamble is broken, it cannot be fixed by truncating
+	Preamble bool
+	Err      error
+}
+
+func (e *FormatError) Error() string {
+	if e.Preamble {
+		return "bad rdb preamble in " + e.Filename + ": " + e.Err.Error()
+	}
+	if e.Truncated {
+		return "unexpected end of " + e.Filename + " at offset " + strconv.FormatInt(e.Offset, 10)
+	}
+	return "bad format of " + e.Filename + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Err.Error()
+}
+
+// countingReader records how many bytes have been read from underlying reader
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// readFile reads an aof file, entities in rdb preamble are passed to entityCb and commands are passed to cmdCb
+// it returns *FormatError if the file is invalid
+func readFile(filename string,
+	entityCb func(dbIndex int, key string, entity *databaseface.DataEntity, expiration *time.Time) error,
+	cmdCb func(cmdLine CmdLine)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //打开就要关闭
+
+	counter := &countingReader{reader: file}
+	reader := bufio.NewReader(counter)
+	offset := func() int64 {
+		return counter.n - int64(reader.Buffered())
+	}
+
+	if rdb.IsRDB(reader) { // 混合格式：开头是rdb快照，后面是增量的命令
+		var loadErr error
+		decoder := rdb.NewDecoder(reader)
+		err = decoder.Parse(func(dbIndex int, key string, entity *databaseface.DataEntity, expiration *time.Time) bool {
+			loadErr = entityCb(dbIndex, key, entity, expiration)
+			return loadErr == nil
+		})
+		if err != nil {
+			return &FormatError{Filename: filename, Preamble: true, Err: err}
+		}
+		if loadErr != nil {
+			return loadErr
+		}
+	}
+
+	for {
+		validOffset := offset()
+		cmdLine, err := readCmd(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return &FormatError{Filename: filename, Offset: validOffset, Truncated: true, Err: err}
+		}
+		if err != nil {
+			if _, ok := err.(*protocolError); ok {
+				return &FormatError{Filename: filename, Offset: validOffset, Err: err}
+			}
+			return err
+		}
+		cmdCb(cmdLine)
+	}
+}
+
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return e.msg
+}
+
+// readCmd reads a command in multi bulk format, like *3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n
+// it returns io.EOF if there is nothing left, io.ErrUnexpectedEOF if the file ends in the middle of a command
+func readCmd(reader *bufio.Reader) (CmdLine, error) {
+	line, err := readCRLFLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, &protocolError{msg: "expect '*', got " + strconv.Quote(string(line))}
+	}
+	argCount, err := strconv.Atoi(string(line[1:]))
+	if err != nil || argCount <= 0 {
+		return nil, &protocolError{msg: "invalid multi bulk length " + strconv.Quote(string(line))}
+	}
+	cmdLine := make(CmdLine, 0, argCount)
+	for i := 0; i < argCount; i++ {
+		line, err = readCRLFLine(reader)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, &protocolError{msg: "expect '$', got " + strconv.Quote(string(line))}
+		}
+		bulkLen, err := strconv.Atoi(string(line[1:]))
+		if err != nil || bulkLen < 0 {
+			return nil, &protocolError{msg: "invalid bulk length " + strconv.Quote(string(line))}
+		}
+		body := make([]byte, bulkLen+2)
+		_, err = io.ReadFull(reader, body)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if body[bulkLen] != '\r' || body[bulkLen+1] != '\n' {
+			return nil, &protocolError{msg: "bulk string is not terminated by CRLF"}
+		}
+		cmdLine = append(cmdLine, body[:bulkLen])
+	}
+	return cmdLine, nil
+}
+
+// readCRLFLine reads a line and removes the CRLF at the end
+func readCRLFLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF {
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, &protocolError{msg: "line is not terminated by CRLF"}
+	}
+	return line[:len(line)-2], nil
+}
+
+// CheckFile validates an aof file, it returns *FormatError if the file is invalid
+func CheckFile(filename string) error {
+	return readFile(filename,
+		func(dbIndex int, key string, entity *databaseface.DataEntity, expiration *time.Time) error {
+			return nil
+		},
+		func(cmdLine CmdLine) {})
+}
+
+// ManifestFiles returns paths of aof files listed in the manifest, in loading order
+func ManifestFiles(manifestFile string) ([]string, error) {
+	m, err := readManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("manifest " + manifestFile + " does not exist")
+	}
+	dir := filepath.Dir(manifestFile)
+	var files []string
+	for _, info := range m.files() {
+		files = append(files, filepath.Join(dir, info.name))
+	}
+	return files, nil
+}
@@ -0,0 +1,198 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//多文件AOF：appenddirname目录下有一个manifest文件，记录了一个base文件和若干个按序号递增的incr文件，
+//加载时按manifest的顺序依次加载，重写时只需要新开一个incr文件，重写完成后原子地替换manifest
+//manifest的格式和redis 7一致，每行一个文件：
+//file appendonly.aof.1.base.rdb seq 1 type b
+//file appendonly.aof.1.incr.aof seq 1 type i
+
+const (
+	aofFileTypeBase = "b" // base file, a snapshot written by rewrite
+	aofFileTypeIncr = "i" // incremental file, commands appended after the base
+
+	manifestSuffix = ".manifest"
+	baseRDBSuffix  = ".base.rdb"
+	baseAOFSuffix  = ".base.aof"
+	incrSuffix     = ".incr.aof"
+)
+
+// aofInfo describes a file listed in manifest
+type aofInfo struct {
+	name     string
+	seq      int
+	fileType string
+}
+
+// manifest lists aof files in loading order
+type manifest struct {
+	base  *aofInfo   // nil if aof has never been rewritten
+	incrs []*aofInfo // in ascending order of seq
+}
+
+// files returns all files in loading order, base file first
+func (m *manifest) files() []*aofInfo {
+	files := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// lastIncr returns the incr file which is being written
+func (m *manifest) lastIncr() *aofInfo {
+	if len(m.incrs) == 0 {
+		return nil
+	}
+	return m.incrs[len(m.incrs)-1]
+}
+
+func (m *manifest) clone() *manifest {
+	incrs := make([]*aofInfo, len(m.incrs))
+	copy(incrs, m.incrs)
+	return &manifest{
+		base:  m.base,
+		incrs: incrs,
+	}
+}
+
+func (m *manifest) nextBaseSeq() int {
+	if m.base == nil {
+		return 1
+	}
+	return m.base.seq + 1
+}
+
+func (m *manifest) nextIncrSeq() int {
+	last := m.lastIncr()
+	if last == nil {
+		return 1
+	}
+	return last.seq + 1
+}
+
+func (m *manifest) encode() []byte {
+	buf := &bytes.Buffer{}
+	for _, info := range m.files() {
+		buf.WriteString("file " + info.name + " seq " + strconv.Itoa(info.seq) + " type " + info.fileType + "\n")
+	}
+	return buf.Bytes()
+}
+
+func manifestName(prefix string) string {
+	return prefix + manifestSuffix
+}
+
+func baseName(prefix string, seq int, rdbFormat bool) string {
+	if rdbFormat {
+		return prefix + "." + strconv.Itoa(seq) + baseRDBSuffix
+	}
+	return prefix + "." + strconv.Itoa(seq) + baseAOFSuffix
+}
+
+func incrName(prefix string, seq int) string {
+	return prefix + "." + strconv.Itoa(seq) + incrSuffix
+}
+
+// parseManifest reads manifest, every line is made of key value pairs
+func parseManifest(src io.Reader) (*manifest, error) {
+	m := &manifest{}
+	scanner := bufio.NewScanner(src)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, errors.New("invalid aof manifest line " + strconv.Itoa(lineNum) + ": " + line)
+		}
+		info := &aofInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.Atoi(fields[i+1])
+				if err != nil {
+					return nil, errors.New("invalid seq in aof manifest line " + strconv.Itoa(lineNum))
+				}
+				info.seq = seq
+			case "type":
+				info.fileType = fields[i+1]
+			} // ignore unknown keys for compatibility
+		}
+		if info.name == "" || strings.ContainsAny(info.name, "/\\") {
+			return nil, errors.New("invalid file name in aof manifest line " + strconv.Itoa(lineNum))
+		}
+		switch info.fileType {
+		case aofFileTypeBase:
+			if m.base != nil {
+				return nil, errors.New("found duplicate base file in aof manifest")
+			}
+			m.base = info
+		case aofFileTypeIncr:
+			if last := m.lastIncr(); last != nil && last.seq >= info.seq {
+				return nil, errors.New("incr files in aof manifest are out of order")
+			}
+			m.incrs = append(m.incrs, info)
+		default:
+			return nil, errors.New("unknown file type in aof manifest line " + strconv.Itoa(lineNum))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readManifest returns nil if manifest does not exist
+func readManifest(filename string) (*manifest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return parseManifest(file)
+}
+
+// writeManifest replaces manifest atomically by writing a temp file and renaming it
+func writeManifest(filename string, m *manifest) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "temp-manifest-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(m.encode())
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
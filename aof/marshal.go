@@ -0,0 +1,105 @@
+package aof
+
+import (
+	Dict "go_redis_write/datastruct/dict"
+	List "go_redis_write/datastruct/list"
+	HashSet "go_redis_write/datastruct/set"
+	SortedSet "go_redis_write/datastruct/sortedset"
+	"go_redis_write/interface/database"
+	"math"
+	"strconv"
+)
+
+//重写AOF时把每个key的当前值转换成一条能够重建它的命令
+
+var (
+	setCmd   = []byte("SET")
+	rPushCmd = []byte("RPUSH")
+	hSetCmd  = []byte("HSET")
+	sAddCmd  = []byte("SADD")
+	zAddCmd  = []byte("ZADD")
+)
+
+// EntityToCmd serialize data entity to redis command, returns nil if the type is unknown
+func EntityToCmd(key string, entity *database.DataEntity) CmdLine {
+	if entity == nil {
+		return nil
+	}
+	var cmd CmdLine
+	switch val := entity.Data.(type) {
+	case []byte:
+		cmd = stringToCmd(key, val)
+	case List.List:
+		cmd = listToCmd(key, val)
+	case Dict.Dict:
+		cmd = hashToCmd(key, val)
+	case *HashSet.Set:
+		cmd = setToCmd(key, val)
+	case *SortedSet.SortedSet:
+		cmd = zSetToCmd(key, val)
+	}
+	return cmd
+}
+
+func stringToCmd(key string, bytes []byte) CmdLine {
+	return CmdLine{setCmd, []byte(key), bytes}
+}
+
+func listToCmd(key string, list List.List) CmdLine {
+	args := make([][]byte, 2, 2+list.Len())
+	args[0] = rPushCmd
+	args[1] = []byte(key)
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, bytes)
+		return true
+	})
+	return args
+}
+
+func hashToCmd(key string, hash Dict.Dict) CmdLine {
+	args := make([][]byte, 2, 2+hash.Len()*2)
+	args[0] = hSetCmd
+	args[1] = []byte(key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, []byte(field), bytes)
+		return true
+	})
+	return args
+}
+
+func setToCmd(key string, set *HashSet.Set) CmdLine {
+	args := make([][]byte, 2, 2+set.Len())
+	args[0] = sAddCmd
+	args[1] = []byte(key)
+	set.ForEach(func(member string) bool {
+		args = append(args, []byte(member))
+		return true
+	})
+	return args
+}
+
+func zSetToCmd(key string, zset *SortedSet.SortedSet) CmdLine {
+	args := make([][]byte, 2, 2+zset.Len()*2)
+	args[0] = zAddCmd
+	args[1] = []byte(key)
+	if zset.Len() == 0 {
+		return args
+	}
+	zset.ForEach(0, zset.Len(), false, func(element *SortedSet.Element) bool {
+		args = append(args, formatScore(element.Score), []byte(element.Member))
+		return true
+	})
+	return args
+}
+
+// formatScore formats score which can be parsed by ZADD, including inf and -inf
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
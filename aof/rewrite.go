@@ -0,0 +1,281 @@
+package aof
+
+import (
+	"errors"
+	"go_redis_write/config"
+	databaseface "go_redis_write/interface/database"
+	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/rdb"
+	"go_redis_write/resp/reply"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//AOF重写：开始时新建一个incr文件接收之后的写入，把旧的base和incr文件加载到一个临时的数据库里，
+//再写成新的base文件，最后原子地替换manifest，删除旧的文件
+
+const autoRewriteCheckInterval = time.Second
+
+// ErrRewriting is returned when another rewrite is in progress
+var ErrRewriting = errors.New("ERR Background append only file rewriting already in progress")
+
+// RewriteCtx holds context of an AOF rewriting procedure
+type RewriteCtx struct {
+	tmpFile *os.File  // tmpFile is the file handler of new base file
+	old     *manifest // old lists files written before rewrite started, they are replaced by the new base
+	rdbBase bool      // rdbBase is true if the new base file is written in rdb format
+}
+
+// Rewrite carries out AOF rewrite synchronously
+func (handler *AofHandler) Rewrite() error {
+	if !atomic.CompareAndSwapInt32(&handler.rewriting, 0, 1) {
+		return ErrRewriting
+	}
+	defer atomic.StoreInt32(&handler.rewriting, 0)
+	return handler.rewrite()
+}
+
+// BgRewrite starts AOF rewrite in background
+func (handler *AofHandler) BgRewrite() error {
+	if !atomic.CompareAndSwapInt32(&handler.rewriting, 0, 1) {
+		return ErrRewriting
+	}
+	handler.rewriteWait.Add(1)
+	go func() {
+		defer handler.rewriteWait.Done()
+		defer atomic.StoreInt32(&handler.rewriting, 0)
+		err := handler.rewrite()
+		if err != nil {
+			logger.Error("aof rewrite failed: " + err.Error())
+			return
+		}
+		logger.Info("aof rewrite finished")
+	}()
+	return nil
+}
+
+func (handler *AofHandler) rewrite() error {
+	ctx, err := handler.StartRewrite()
+	if err != nil {
+		return err
+	}
+	err = handler.DoRewrite(ctx)
+	if err != nil {
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
+		return err
+	}
+	return handler.FinishRewrite(ctx)
+}
+
+// StartRewrite prepares rewrite procedure, aof is paused while preparing
+// following commands are written into a new incr file, which won't be rewritten
+func (handler *AofHandler) StartRewrite() (*RewriteCtx, error) {
+	handler.pausingAof.Lock() // pausing aof
+	defer handler.pausingAof.Unlock()
+
+	err := handler.aofFile.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	// open a new incr file, and record it in manifest so that it will be loaded even if rewrite fails
+	old := handler.manifest.clone()
+	seq := old.nextIncrSeq()
+	incr := &aofInfo{name: incrName(handler.prefix, seq), seq: seq, fileType: aofFileTypeIncr}
+	aofFile, err := os.OpenFile(handler.path(incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	m := old.clone()
+	m.incrs = append(m.incrs, incr)
+	err = writeManifest(handler.path(manifestName(handler.prefix)), m)
+	if err != nil {
+		_ = aofFile.Close()
+		_ = os.Remove(aofFile.Name())
+		return nil, err
+	}
+	_ = handler.aofFile.Close()
+	handler.aofFile = aofFile
+	handler.manifest = m
+	handler.currentDB = -1 // new incr file has to select db first
+
+	// create tmp file in the same directory, so that rename is atomic
+	file, err := os.CreateTemp(handler.dir, "temp-rewriteaof-*.aof")
+	if err != nil {
+		return nil, err
+	}
+	return &RewriteCtx{
+		tmpFile: file,
+		old:     old,
+		rdbBase: config.Properties.AofUseRdbPreamble,
+	}, nil
+}
+
+// DoRewrite loads aof written before rewrite started into a temporary database, and dumps it to tmp file
+// it doesn't block writing of online aof
+func (handler *AofHandler) DoRewrite(ctx *RewriteCtx) error {
+	tmpFile := ctx.tmpFile
+
+	// load old aof files
+	tmpDB := handler.tmpDBMaker()
+	tmpAof := &AofHandler{
+		db:       tmpDB,
+		dir:      handler.dir,
+		manifest: ctx.old,
+	}
+	defer tmpDB.Close()
+	err := tmpAof.LoadAof()
+	if err != nil {
+		return err
+	}
+
+	if ctx.rdbBase { // 用rdb格式写base文件，加载更快
+		return rdb.DumpAofPreamble(tmpFile, tmpDB, config.Properties.Databases)
+	}
+
+	// rewrite aof tmpFile
+	for i := 0; i < config.Properties.Databases; i++ {
+		var err error
+		selected := false
+		tmpDB.ForEach(i, func(key string, entity *databaseface.DataEntity, expiration *time.Time) bool {
+			if !selected { // skip empty db
+				err = writeCmd(tmpFile, utils.ToCmdLine("SELECT", strconv.Itoa(i)))
+				if err != nil {
+					return false
+				}
+				selected = true
+			}
+			cmd := EntityToCmd(key, entity)
+			if cmd == nil {
+				return true
+			}
+			err = writeCmd(tmpFile, cmd)
+			if err != nil {
+				return false
+			}
+			if expiration != nil {
+				err = writeCmd(tmpFile, MakeExpireCmd(key, *expiration))
+				if err != nil {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FinishRewrite installs tmp file as the new base file, then replaces manifest and removes old files
+func (handler *AofHandler) FinishRewrite(ctx *RewriteCtx) error {
+	tmpFile := ctx.tmpFile
+	err := tmpFile.Sync()
+	if err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	_ = tmpFile.Close()
+
+	handler.pausingAof.Lock() // pausing aof
+	defer handler.pausingAof.Unlock()
+
+	seq := handler.manifest.nextBaseSeq()
+	base := &aofInfo{name: baseName(handler.prefix, seq, ctx.rdbBase), seq: seq, fileType: aofFileTypeBase}
+	err = os.Rename(tmpFile.Name(), handler.path(base.name))
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+
+	// keep incr files created after rewrite started
+	lastOld := ctx.old.lastIncr()
+	m := &manifest{base: base}
+	for _, incr := range handler.manifest.incrs {
+		if lastOld == nil || incr.seq > lastOld.seq {
+			m.incrs = append(m.incrs, incr)
+		}
+	}
+	err = writeManifest(handler.path(manifestName(handler.prefix)), m)
+	if err != nil {
+		_ = os.Remove(handler.path(base.name))
+		return err
+	}
+	handler.manifest = m
+
+	for _, info := range ctx.old.files() {
+		err = os.Remove(handler.path(info.name))
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn("remove old aof file failed: " + err.Error())
+		}
+	}
+	handler.updateBaseSize()
+	return nil
+}
+
+func writeCmd(file *os.File, cmdLine CmdLine) error {
+	_, err := file.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	return err
+}
+
+// aofSize returns total size of files in manifest, invoker should hold pausingAof
+func (handler *AofHandler) aofSize() int64 {
+	var size int64
+	for _, info := range handler.manifest.files() {
+		fileInfo, err := os.Stat(handler.path(info.name))
+		if err != nil {
+			continue
+		}
+		size += fileInfo.Size()
+	}
+	return size
+}
+
+// updateBaseSize records aof size after loading or rewriting, auto rewrite compares growth with it
+func (handler *AofHandler) updateBaseSize() {
+	atomic.StoreInt64(&handler.baseSize, handler.aofSize())
+}
+
+// startAutoRewrite checks the growth of aof periodically, and starts rewrite when reached the threshold
+func (handler *AofHandler) startAutoRewrite(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(autoRewriteCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				handler.checkAutoRewrite()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (handler *AofHandler) checkAutoRewrite() {
+	percentage := config.Properties.AutoAofRewritePercentage
+	if percentage <= 0 || atomic.LoadInt32(&handler.rewriting) == 1 {
+		return
+	}
+	handler.pausingAof.RLock()
+	size := handler.aofSize()
+	handler.pausingAof.RUnlock()
+	if size < int64(config.Properties.AutoAofRewriteMinSize) {
+		return
+	}
+	base := atomic.LoadInt64(&handler.baseSize)
+	if base == 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	if growth >= int64(percentage) {
+		logger.Info("starting automatic rewriting of AOF on " + strconv.FormatInt(growth, 10) + "% growth")
+		_ = handler.BgRewrite()
+	}
+}
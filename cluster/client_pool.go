@@ -4,11 +4,26 @@ import (
 	"context"
 	"errors"
 	"github.com/jolestar/go-commons-pool/v2"
+	"go_redis_write/config"
+	"go_redis_write/lib/utils"
 	"go_redis_write/resp/client"
+	"go_redis_write/resp/reply"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+//每个兄弟节点一个连接池，连接池的大小、借连接的超时时间、空闲连接的回收都可以在配置文件中设置
+//cluster-pool-test-on-borrow 打开后借出连接前先发送PING，断开的连接会被销毁而不是交给调用者
+//空闲连接由后台定期检查: 空闲超过 cluster-pool-idle-timeout 的连接被关闭，其他空闲连接用PING检查是否可用
+//INFO pool 可以查看每个节点连接池的使用情况
+
 type connectionFactory struct { //实现了PooledObjectFactory 的接口
-	Peer string
+	Peer    string
+	created int64 // number of connections created, atomic
 }
 
 func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
@@ -17,6 +32,7 @@ func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject,
 		return nil, err
 	}
 	c.Start()
+	atomic.AddInt64(&f.created, 1)
 	return pool.NewPooledObject(c), nil
 }
 
@@ -29,9 +45,14 @@ func (f *connectionFactory) DestroyObject(ctx context.Context, object *pool.Pool
 	return nil
 }
 
+// ValidateObject sends PING to peer, it is called before borrowing if cluster-pool-test-on-borrow is set, and by evictor
 func (f *connectionFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
-	// do validate
-	return true
+	c, ok := object.Object.(*client.Client)
+	if !ok || c.Broken() {
+		return false
+	}
+	status, ok := c.Send(utils.ToCmdLine("PING")).(*reply.StatusReply)
+	return ok && status.Status == "PONG"
 }
 
 func (f *connectionFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
@@ -43,3 +64,101 @@ func (f *connectionFactory) PassivateObject(ctx context.Context, object *pool.Po
 	// do passivate
 	return nil
 }
+
+// peerPool is the connection pool of a peer with its statistics
+type peerPool struct {
+	*pool.ObjectPool
+	factory      *connectionFactory
+	borrowed     int64 // atomic
+	borrowErrors int64 // atomic, including timeouts
+}
+
+// makePeerPool creates connection pool of peer by cluster-pool-* config
+func makePeerPool(peer string) *peerPool {
+	factory := &connectionFactory{
+		Peer: peer,
+	}
+	return &peerPool{
+		ObjectPool: pool.NewObjectPool(context.Background(), factory, makePoolConfig()),
+		factory:    factory,
+	}
+}
+
+// makePoolConfig returns config of pools, non-positive size means no limit and non-positive time means never
+func makePoolConfig() *pool.ObjectPoolConfig {
+	poolConfig := pool.NewDefaultPoolConfig()
+	poolConfig.MaxTotal = config.Properties.ClusterPoolMaxTotal
+	if poolConfig.MaxTotal <= 0 {
+		poolConfig.MaxTotal = -1
+	}
+	poolConfig.MaxIdle = config.Properties.ClusterPoolMaxIdle
+	if poolConfig.MaxIdle <= 0 {
+		poolConfig.MaxIdle = -1
+	}
+	poolConfig.TestOnBorrow = config.Properties.ClusterPoolTestOnBorrow
+	poolConfig.MinEvictableIdleTime = time.Duration(config.Properties.ClusterPoolIdleTimeout) * time.Millisecond
+	if poolConfig.MinEvictableIdleTime <= 0 {
+		poolConfig.MinEvictableIdleTime = time.Duration(math.MaxInt64)
+	}
+	poolConfig.TimeBetweenEvictionRuns = time.Duration(config.Properties.ClusterPoolEvictInterval) * time.Millisecond
+	if poolConfig.TimeBetweenEvictionRuns > 0 {
+		poolConfig.TestWhileIdle = true
+		poolConfig.NumTestsPerEvictionRun = -1 // check all idle connections in every run
+	}
+	return poolConfig
+}
+
+// borrow borrows a connection in cluster-pool-borrow-timeout, it waits for a connection returned if the pool is exhausted
+func (p *peerPool) borrow() (*client.Client, error) {
+	ctx := context.Background()
+	if timeout := config.Properties.ClusterPoolBorrowTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+	raw, err := p.BorrowObject(ctx)
+	if err != nil {
+		atomic.AddInt64(&p.borrowErrors, 1)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, errors.New("ERR borrow connection to " + p.factory.Peer + " timeout")
+		}
+		return nil, err
+	}
+	conn, ok := raw.(*client.Client) //类型断言
+	if !ok {
+		atomic.AddInt64(&p.borrowErrors, 1)
+		return nil, errors.New("connection factory make wrong type")
+	}
+	atomic.AddInt64(&p.borrowed, 1)
+	return conn, nil
+}
+
+// poolInfo returns the pool section of INFO, a line for every peer
+func (cluster *ClusterDatabase) poolInfo() string {
+	cluster.connMu.Lock()
+	peers := make([]string, 0, len(cluster.peerConnection))
+	pools := make(map[string]*peerPool, len(cluster.peerConnection))
+	for peer, p := range cluster.peerConnection {
+		peers = append(peers, peer)
+		pools[peer] = p
+	}
+	cluster.connMu.Unlock()
+	sort.Strings(peers)
+	lines := []string{
+		"pool_max_total:" + strconv.Itoa(config.Properties.ClusterPoolMaxTotal),
+		"pool_max_idle:" + strconv.Itoa(config.Properties.ClusterPoolMaxIdle),
+		"pool_peers:" + strconv.Itoa(len(peers)),
+	}
+	for i, peer := range peers {
+		p := pools[peer]
+		lines = append(lines, "peer"+strconv.Itoa(i)+":addr="+peer+
+			",active="+strconv.Itoa(p.GetNumActive())+
+			",idle="+strconv.Itoa(p.GetNumIdle())+
+			",created="+strconv.FormatInt(atomic.LoadInt64(&p.factory.created), 10)+
+			",destroyed="+strconv.Itoa(p.GetDestroyedCount())+
+			",destroyed_by_validation="+strconv.Itoa(p.GetDestroyedByBorrowValidationCount())+
+			",borrowed="+strconv.FormatInt(atomic.LoadInt64(&p.borrowed), 10)+
+			",borrow_errors="+strconv.FormatInt(atomic.LoadInt64(&p.borrowErrors), 10))
+	}
+	return "# Pool\r\n" + strings.Join(lines, "\r\n") + "\r\n"
+}
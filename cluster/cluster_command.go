@@ -0,0 +1,272 @@
+package cluster
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//CLUSTER KEYSLOT key
+//CLUSTER SLOTS
+//CLUSTER SHARDS
+//CLUSTER NODES
+//CLUSTER MYID
+//cluster-aware客户端通过这些命令获取槽和节点的对应关系，然后直接连接负责key的节点
+//CLUSTER MEET ip port [cluster-bus-port]
+//CLUSTER FORGET node-id
+//CLUSTER SETSLOT slot IMPORTING source-id | MIGRATING target-id | NODE node-id | STABLE
+//CLUSTER GETKEYSINSLOT slot count
+//CLUSTER COUNTKEYSINSLOT slot
+//CLUSTER REBALANCE
+//这些命令用于增加节点和迁移槽，迁移过程见migrate.go
+//CLUSTER REPLICATE node-id
+//CLUSTER FAILOVER [FORCE|TAKEOVER]
+//从节点和故障转移见failover.go
+
+// execCluster handles CLUSTER subcommands
+func execCluster(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "keyslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(getSlot(string(args[2]))))
+	case "slots":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|slots")
+		}
+		return cluster.clusterSlots()
+	case "shards":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|shards")
+		}
+		return cluster.clusterShards()
+	case "nodes":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|nodes")
+		}
+		return reply.MakeBulkReply([]byte(cluster.clusterNodes()))
+	case "myid":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|myid")
+		}
+		return reply.MakeBulkReply([]byte(cluster.topology.self.ID))
+	case "meet":
+		if len(args) != 4 && len(args) != 5 {
+			return reply.MakeArgNumErrReply("cluster|meet")
+		}
+		port, err1 := strconv.ParseUint(string(args[3]), 10, 16)
+		busPort := port + 10000
+		var err2 error
+		if len(args) == 5 {
+			busPort, err2 = strconv.ParseUint(string(args[4]), 10, 16)
+		}
+		if err1 != nil || err2 != nil || busPort > 65535 {
+			return reply.MakeErrReply("ERR Invalid node address specified: " + string(args[2]) + ":" + string(args[3]))
+		}
+		node := cluster.topology.addNode(net.JoinHostPort(string(args[2]), string(args[3])), int(busPort))
+		if node != cluster.topology.self {
+			go cluster.meet(node)
+		}
+		return reply.MakeOkReply()
+	case "forget":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|forget")
+		}
+		node := cluster.topology.getNode(string(args[2]))
+		if node == nil {
+			return reply.MakeErrReply("ERR Unknown node " + string(args[2]))
+		}
+		if node == cluster.topology.self {
+			return reply.MakeErrReply("ERR I tried hard but I can't forget myself...")
+		}
+		cluster.forget(node)
+		return reply.MakeOkReply()
+	case "setslot":
+		return cluster.setSlot(args[2:])
+	case "getkeysinslot":
+		if len(args) != 4 {
+			return reply.MakeArgNumErrReply("cluster|getkeysinslot")
+		}
+		slot, ok := parseSlot(args[2])
+		if !ok {
+			return reply.MakeErrReply("ERR Invalid slot")
+		}
+		count, err := strconv.Atoi(string(args[3]))
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR Invalid number of keys")
+		}
+		return reply.MakeMultiBulkReply(cluster.getKeysInSlot(c.GetDBIndex(), slot, count))
+	case "countkeysinslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|countkeysinslot")
+		}
+		slot, ok := parseSlot(args[2])
+		if !ok {
+			return reply.MakeErrReply("ERR Invalid slot")
+		}
+		return reply.MakeIntReply(int64(cluster.countKeysInSlot(c.GetDBIndex(), slot)))
+	case "rebalance":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|rebalance")
+		}
+		return cluster.rebalance()
+	case "replicate":
+		return cluster.execReplicate(args)
+	case "failover":
+		return cluster.execFailover(args)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[1]) + "'. Try CLUSTER HELP.")
+}
+
+// setSlot handles CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE node-id and CLUSTER SETSLOT slot STABLE
+func (cluster *ClusterDatabase) setSlot(args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster|setslot")
+	}
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		return reply.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		cluster.topology.setStable(slot)
+		return reply.MakeOkReply()
+	}
+	if len(args) != 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	node := cluster.topology.getNode(string(args[2]))
+	if node == nil {
+		return reply.MakeErrReply("ERR I don't know about node " + string(args[2]))
+	}
+	self := cluster.topology.self
+	owner := cluster.topology.pickNode(slot)
+	switch action {
+	case "importing":
+		if owner == self {
+			return reply.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(int(slot)))
+		}
+		cluster.topology.setImporting(slot, node)
+	case "migrating":
+		if owner != self {
+			return reply.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(int(slot)))
+		}
+		if node == self {
+			return reply.MakeErrReply("ERR Target node can't be myself")
+		}
+		cluster.topology.setMigrating(slot, node)
+	case "node":
+		if owner == self && node != self && cluster.holdsKeysInSlot(slot) {
+			return reply.MakeErrReply("ERR Can't assign hashslot " + strconv.Itoa(int(slot)) +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+		cluster.topology.setSlot(slot, node)
+		if node == self {
+			// other nodes accept the slot by gossip only if the config epoch of this node is the greatest
+			cluster.topology.bumpEpoch()
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	return reply.MakeOkReply()
+}
+
+// splitAddr splits address into ip and port, port is 0 if the address is invalid
+func splitAddr(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// clusterSlots returns [start, end, [ip, port, id], [replica ip, port, id]...] of every slot range
+func (cluster *ClusterDatabase) clusterSlots() resp.Reply {
+	ranges := cluster.topology.slotRanges()
+	result := make([]resp.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		slotInfo := []resp.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+		}
+		nodes := append([]*Node{r.node}, cluster.topology.replicasOf(r.node)...)
+		for _, node := range nodes {
+			ip, port := splitAddr(node.Addr)
+			slotInfo = append(slotInfo, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(ip)),
+				reply.MakeIntReply(int64(port)),
+				reply.MakeBulkReply([]byte(node.ID)),
+			}))
+		}
+		result = append(result, reply.MakeMultiRawReply(slotInfo))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterShards returns slots and nodes of every shard, a shard is a master with its slots and replicas
+func (cluster *ClusterDatabase) clusterShards() resp.Reply {
+	nodeSlots := make(map[*Node][]resp.Reply)
+	for _, r := range cluster.topology.slotRanges() {
+		nodeSlots[r.node] = append(nodeSlots[r.node], reply.MakeIntReply(int64(r.start)), reply.MakeIntReply(int64(r.end)))
+	}
+	masters := cluster.topology.masters()
+	result := make([]resp.Reply, 0, len(masters))
+	for _, master := range masters {
+		slots := nodeSlots[master]
+		if slots == nil {
+			slots = []resp.Reply{}
+		}
+		nodes := []resp.Reply{cluster.shardNodeInfo(master, "master")}
+		for _, replica := range cluster.topology.replicasOf(master) {
+			nodes = append(nodes, cluster.shardNodeInfo(replica, "replica"))
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("slots")),
+			reply.MakeMultiRawReply(slots),
+			reply.MakeBulkReply([]byte("nodes")),
+			reply.MakeMultiRawReply(nodes),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// shardNodeInfo returns a node of CLUSTER SHARDS
+func (cluster *ClusterDatabase) shardNodeInfo(node *Node, role string) resp.Reply {
+	ip, port := splitAddr(node.Addr)
+	health := "online"
+	if cluster.topology.isFailed(node.Addr) {
+		health = "failed"
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("id")),
+		reply.MakeBulkReply([]byte(node.ID)),
+		reply.MakeBulkReply([]byte("port")),
+		reply.MakeIntReply(int64(port)),
+		reply.MakeBulkReply([]byte("ip")),
+		reply.MakeBulkReply([]byte(ip)),
+		reply.MakeBulkReply([]byte("endpoint")),
+		reply.MakeBulkReply([]byte(ip)),
+		reply.MakeBulkReply([]byte("role")),
+		reply.MakeBulkReply([]byte(role)),
+		reply.MakeBulkReply([]byte("replication-offset")),
+		reply.MakeIntReply(0),
+		reply.MakeBulkReply([]byte("health")),
+		reply.MakeBulkReply([]byte(health)),
+	})
+}
+
+// clusterNodes returns nodes in the format of redis nodes.conf, see nodes.go
+func (cluster *ClusterDatabase) clusterNodes() string {
+	return cluster.topology.formatNodes()
+}
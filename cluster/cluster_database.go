@@ -2,18 +2,21 @@
 package cluster
 
 import (
-	"context"
 	"fmt"
-	pool "github.com/jolestar/go-commons-pool/v2"
 	"go_redis_write/config"
 	"go_redis_write/database"
+	"go_redis_write/datastruct/dict"
 	databaseface "go_redis_write/interface/database"
 	"go_redis_write/interface/resp"
-	"go_redis_write/lib/consistenthash"
 	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/connection"
 	"go_redis_write/resp/reply"
+	"net"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // ClusterDatabase represents a node of godis cluster
@@ -21,10 +24,26 @@ import (
 type ClusterDatabase struct {
 	self string //记录自己的名称和地址，这个相当于的主redis
 
-	nodes          []string
-	peerPicker     *consistenthash.NodeMap
-	peerConnection map[string]*pool.ObjectPool
-	db             databaseface.Database //本地的单机数据库
+	topology       *topology // slot -> node
+	connMu         sync.Mutex
+	peerConnection map[string]*peerPool
+	db             databaseface.DBEngine //本地的单机数据库
+	slotKeys       *slotIndex            // keys of every slot in local db, see slot_index.go
+	// txID -> *Transaction, distributed transactions in which this node participates
+	transactions *dict.SyncDict
+	// sequence of transaction id generated by this node
+	txSeq uint64
+	// reply MOVED/ASK instead of relaying requests to other nodes
+	redirectMode bool
+
+	busListener net.Listener  // cluster bus, see gossip.go
+	busClosing  chan struct{} // closed to stop pinging
+	busStopOnce sync.Once
+	configFile  string     // nodes.conf
+	configMu    sync.Mutex // serializes writing of nodes.conf
+
+	failover   failoverState // election of this replica, see failover.go
+	pauseUntil int64         // unix nanoseconds, master pauses writes until then in manual failover
 }
 
 // MakeClusterDatabase creates and starts a node of cluster
@@ -33,25 +52,78 @@ func MakeClusterDatabase() *ClusterDatabase {
 		self: config.Properties.Self,
 
 		db:             database.NewStandaloneDatabase(),
-		peerPicker:     consistenthash.NewNodeMap(nil),
-		peerConnection: make(map[string]*pool.ObjectPool),
+		peerConnection: make(map[string]*peerPool), //对兄弟节点的连接池，第一次使用时创建
+		transactions:   dict.MakeSyncDict(),
+		busClosing:     make(chan struct{}),
+		configFile:     config.Properties.ClusterConfigFile,
+	}
+	cluster.slotKeys = makeSlotIndex(config.Properties.Databases)
+	cluster.db.SetKeyEventCallbacks(cluster.slotKeys.add, cluster.slotKeys.remove)
+	// a restarted node keeps its id, slots and known nodes in nodes.conf
+	t := cluster.loadConfig()
+	loaded := t != nil
+	if loaded {
+		t.self.Addr = cluster.self
+		logger.Info("loaded cluster nodes from " + cluster.configFile + ", my id is " + t.self.ID)
+	} else {
+		nodes := make([]string, 0, len(config.Properties.Peers)+1)
+		for _, peer := range config.Properties.Peers { //遍历配置里面的peer节点，都append到nodes中
+			nodes = append(nodes, peer)
+		}
+		nodes = append(nodes, config.Properties.Self) //在放入self节点
+		t = makeTopology(config.Properties.Self, nodes)
 	}
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	for _, peer := range config.Properties.Peers { //遍历配置里面的peer节点，都append到nodes中
-		nodes = append(nodes, peer)
+	if config.Properties.ClusterPort > 0 {
+		t.self.BusPort = config.Properties.ClusterPort
 	}
-	nodes = append(nodes, config.Properties.Self) //在放入self节点
-	cluster.peerPicker.AddNode(nodes...)
-	ctx := context.Background()
-	for _, peer := range config.Properties.Peers { //对兄弟节点创建连接池
-		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
-			Peer: peer,
-		})
+	cluster.topology = t
+	if !loaded {
+		cluster.joinCluster()
+	}
+	cluster.saveConfig()
+	if err := cluster.startBus(); err != nil {
+		logger.Error("start cluster bus failed: " + err.Error())
+	}
+	if master := t.self.master; master != nil {
+		cluster.replicate(master)
+	}
+	switch strings.ToLower(config.Properties.ClusterMode) {
+	case "redirect":
+		cluster.redirectMode = true
+	case "", "proxy":
+	default:
+		logger.Warn("unknown cluster-mode " + config.Properties.ClusterMode + ", use proxy mode")
 	}
-	cluster.nodes = nodes
 	return cluster
 }
 
+// joinCluster loads slots from a running peer, so adding a node to peers doesn't change slots of other nodes,
+// then tells other nodes about this node. If no peer is running, the cluster is starting and slots are evenly assigned
+func (cluster *ClusterDatabase) joinCluster() {
+	conn := &connection.FakeConn{}
+	for _, peer := range config.Properties.Peers {
+		result := cluster.relay(peer, conn, utils.ToCmdLine("cluster", "nodes"))
+		nodes, ok := result.(*reply.BulkReply)
+		if !ok {
+			continue
+		}
+		err := cluster.topology.loadNodes(string(nodes.Arg))
+		if err != nil {
+			logger.Warn("load cluster nodes from " + peer + " failed: " + err.Error())
+			continue
+		}
+		logger.Info("loaded cluster nodes from " + peer)
+		host, port := splitAddr(cluster.self)
+		for _, addr := range cluster.nodeAddrs() {
+			if addr != cluster.self {
+				cluster.relay(addr, conn, utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port),
+					strconv.Itoa(cluster.topology.self.BusPort)))
+			}
+		}
+		return
+	}
+}
+
 //表示redis的
 
 // CmdFunc represents the handler of a redis command
@@ -59,6 +131,8 @@ type CmdFunc func(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]by
 
 // Close stops current node of cluster
 func (cluster *ClusterDatabase) Close() {
+	cluster.stopBus()
+	cluster.saveConfig()
 	cluster.db.Close()
 }
 
@@ -72,11 +146,20 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 			result = &reply.UnKnownErrReply{}
 		}
 	}()
+	if c.SubsCount() > 0 { // subscribe mode is handled by local db
+		return cluster.db.Exec(c, cmdLine)
+	}
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmdFunc, ok := router[cmdName] //跳转到需要执行的函数
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
 	}
+	asking := c.IsAsking()
+	c.SetAsking(false) // ASKING only affects the next command
+	cluster.waitPause(cmdLine)
+	if routed, ok := cluster.routeBySlot(c, cmdLine, asking); ok {
+		return routed
+	}
 	result = cmdFunc(cluster, c, cmdLine)
 	return
 }
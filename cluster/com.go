@@ -3,37 +3,58 @@ package cluster
 import (
 	"context"
 	"errors"
+	"go_redis_write/config"
 	"go_redis_write/interface/resp"
 	"go_redis_write/lib/utils"
 	"go_redis_write/resp/client"
 	"go_redis_write/resp/reply"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
-//在连接池里获取一个连接
-func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
-	factory, ok := cluster.peerConnection[peer] //取到连接池
+// getPool returns the connection pool of peer, pool of a node joined later is created on first use
+func (cluster *ClusterDatabase) getPool(peer string) *peerPool {
+	cluster.connMu.Lock()
+	defer cluster.connMu.Unlock()
+	factory, ok := cluster.peerConnection[peer]
 	if !ok {
-		return nil, errors.New("connection factory not found")
+		factory = makePeerPool(peer)
+		cluster.peerConnection[peer] = factory
 	}
-	raw, err := factory.BorrowObject(context.Background()) //获取一个连接
-	if err != nil {
-		return nil, err
+	return factory
+}
+
+// resetPool closes connections to peer, connections are created again on next use.
+// It is called when peer is forgotten or comes back after failing, connections to the old process are broken
+func (cluster *ClusterDatabase) resetPool(peer string) {
+	cluster.connMu.Lock()
+	factory, ok := cluster.peerConnection[peer]
+	delete(cluster.peerConnection, peer)
+	cluster.connMu.Unlock()
+	if ok {
+		go factory.Close(context.Background())
 	}
-	conn, ok := raw.(*client.Client) //类型断言
-	if !ok {
-		return nil, errors.New("connection factory make wrong type")
+}
+
+//在连接池里获取一个连接
+func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
+	if peer == "" {
+		return nil, errors.New("CLUSTERDOWN Hash slot not served")
 	}
-	return conn, nil
+	if cluster.topology.isFailed(peer) { // don't wait for timeout of a dead peer
+		return nil, errors.New("CLUSTERDOWN node " + peer + " is down")
+	}
+	return cluster.getPool(peer).borrow() //取到连接池，获取一个连接
 }
 
-//返还连接给连接池
+//返还连接给连接池，超时或者出错的连接直接销毁
 func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client.Client) error {
-	connectionFactory, ok := cluster.peerConnection[peer]
-	if !ok {
-		return errors.New("connection factory not found")
+	if peerClient.Broken() { // a late reply would be taken as the reply of next request
+		return cluster.getPool(peer).InvalidateObject(context.Background(), peerClient)
 	}
-	return connectionFactory.ReturnObject(context.Background(), peerClient)
+	return cluster.getPool(peer).ReturnObject(context.Background(), peerClient)
 }
 
 //客户端发送命令给self节点。self系欸但转发给其对应的一致性哈希的peer节点
@@ -56,12 +77,151 @@ func (cluster *ClusterDatabase) relay(peer string, c resp.Connection, args [][]b
 	return peerClient.Send(args)
 }
 
-// broadcast broadcasts command to all node in cluster
-func (cluster *ClusterDatabase) broadcast(c resp.Connection, args [][]byte) map[string]resp.Reply {
-	result := make(map[string]resp.Reply)
-	for _, node := range cluster.nodes {
-		reply := cluster.relay(node, c, args)
-		result[node] = reply
+// relayAsking relays command to the node importing the slot of keys, ASKING makes the node execute it before owning the slot
+func (cluster *ClusterDatabase) relayAsking(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	peerClient, err := cluster.getPeerClient(peer)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	defer func() {
+		_ = cluster.returnPeerClient(peer, peerClient)
+	}()
+	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
+	peerClient.Send(utils.ToCmdLine("ASKING"))
+	return peerClient.Send(args)
+}
+
+// nodeAddrs returns addresses of all nodes in cluster, including self
+func (cluster *ClusterDatabase) nodeAddrs() []string {
+	nodes := cluster.topology.sortedNodes()
+	addrs := make([]string, len(nodes))
+	for i, node := range nodes {
+		addrs[i] = node.Addr
+	}
+	return addrs
+}
+
+// relayLocal executes command on the data of peer only, other nodes receive it with relayLocalPrefix so they won't broadcast it again
+func (cluster *ClusterDatabase) relayLocal(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer == cluster.self {
+		return cluster.db.Exec(c, args)
+	}
+	relayArgs := make([][]byte, len(args))
+	copy(relayArgs, args)
+	relayArgs[0] = []byte(relayLocalPrefix + string(args[0]))
+	return cluster.relay(peer, c, relayArgs)
+}
+
+// broadcastMode decides how broadcast handles failed nodes
+type broadcastMode int
+
+const (
+	// failFast returns as soon as a node fails, for commands whose partial result is wrong like DBSIZE
+	failFast broadcastMode = iota
+	// bestEffort waits for all nodes, for commands which should take effect on every reachable node like FLUSHDB
+	bestEffort
+)
+
+// BroadcastError holds errors of nodes failed in broadcast, including error replies, connection errors and timeouts
+type BroadcastError struct {
+	Total  int              // number of nodes received the command
+	Errors map[string]error // node -> error
+}
+
+// Error returns errors of all failed nodes sorted by address
+func (e *BroadcastError) Error() string {
+	nodes := make([]string, 0, len(e.Errors))
+	for node := range e.Errors {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	msgs := make([]string, len(nodes))
+	for i, node := range nodes {
+		msgs[i] = node + ": " + e.Errors[node].Error()
+	}
+	return "failed on " + strconv.Itoa(len(nodes)) + " of " + strconv.Itoa(e.Total) + " nodes: " + strings.Join(msgs, "; ")
+}
+
+// toReply returns the error reply of a failed command
+func (e *BroadcastError) toReply(cmdName string) resp.Reply {
+	return reply.MakeErrReply("ERR " + strings.ToLower(cmdName) + " " + e.Error())
+}
+
+// broadcastTimeout returns the deadline of every node in broadcast
+func broadcastTimeout() time.Duration {
+	return time.Duration(config.Properties.ClusterBroadcastTimeout) * time.Millisecond
+}
+
+// broadcast broadcasts command to all masters in cluster concurrently, replicas get the command by replication.
+// It returns replies of succeeded nodes, and errors of failed nodes or nil
+func (cluster *ClusterDatabase) broadcast(c resp.Connection, args [][]byte, mode broadcastMode) (map[string]resp.Reply, *BroadcastError) {
+	masters := cluster.topology.masters()
+	nodes := make([]string, len(masters))
+	for i, node := range masters {
+		nodes[i] = node.Addr
+	}
+	return cluster.fanOut(nodes, mode, func(node string) resp.Reply {
+		return cluster.relayLocal(node, c, args)
+	})
+}
+
+// relayGroups sends a command to each of the given nodes concurrently, and returns replies of every node,
+// a node failed to reply in time gets an error reply
+func (cluster *ClusterDatabase) relayGroups(c resp.Connection, cmdLines map[string]CmdLine) map[string]resp.Reply {
+	nodes := make([]string, 0, len(cmdLines))
+	for node := range cmdLines {
+		nodes = append(nodes, node)
+	}
+	result, errs := cluster.fanOut(nodes, bestEffort, func(node string) resp.Reply {
+		return cluster.relay(node, c, cmdLines[node])
+	})
+	if errs != nil {
+		for node, err := range errs.Errors {
+			result[node] = reply.MakeErrReply(err.Error())
+		}
 	}
 	return result
 }
+
+// fanOut calls fn for every node concurrently, every node must reply in cluster-broadcast-timeout.
+// The command is not cancelled on timeout, a slow node may still execute it
+func (cluster *ClusterDatabase) fanOut(nodes []string, mode broadcastMode, fn func(node string) resp.Reply) (map[string]resp.Reply, *BroadcastError) {
+	type nodeReply struct {
+		node  string
+		reply resp.Reply
+	}
+	ch := make(chan nodeReply, len(nodes)) // buffered, so goroutines of slow nodes can exit after return
+	for _, node := range nodes {
+		go func(node string) {
+			ch <- nodeReply{node: node, reply: fn(node)}
+		}(node)
+	}
+	result := make(map[string]resp.Reply, len(nodes))
+	errs := &BroadcastError{Total: len(nodes), Errors: make(map[string]error)}
+	timer := time.NewTimer(broadcastTimeout())
+	defer timer.Stop()
+	for pending := len(nodes); pending > 0; pending-- {
+		select {
+		case r := <-ch:
+			if errReply, ok := r.reply.(reply.ErrorReply); ok {
+				errs.Errors[r.node] = errReply
+				if mode == failFast {
+					return result, errs
+				}
+				continue
+			}
+			result[r.node] = r.reply
+		case <-timer.C:
+			for _, node := range nodes {
+				if _, ok := result[node]; !ok && errs.Errors[node] == nil {
+					errs.Errors[node] = errors.New("timeout")
+				}
+			}
+			return result, errs
+		}
+	}
+	if len(errs.Errors) == 0 {
+		return result, nil
+	}
+	return result, errs
+}
@@ -2,29 +2,37 @@ package cluster
 
 import (
 	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
 	"go_redis_write/resp/reply"
 )
 
 // Del atomically removes given writeKeys from cluster, writeKeys can be distributed on any node
 // if the given writeKeys are distributed on different node, Del will use try-commit-catch to remove them
 func Del(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	replies := cluster.broadcast(c, args)
-	var errReply reply.ErrorReply
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("del")
+	}
+	groups := cluster.groupByPeer(args[1:])
+	if len(groups) == 1 { // all keys are on one node, no transaction needed
+		for peer := range groups {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for peer, keys := range groups {
+		cmdLines[peer] = utils.ToCmdLine2("del", keys...)
+	}
+	replies, errReply := cluster.execTx(c, cmdLines)
+	if errReply != nil {
+		return errReply
+	}
 	var deleted int64 = 0
 	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
-		}
 		intReply, ok := v.(*reply.IntReply)
 		if !ok {
-			errReply = reply.MakeErrReply("error")
+			return reply.MakeErrReply("ERR unexpected reply of del: " + string(v.ToBytes()))
 		}
 		deleted += intReply.Code
 	}
-
-	if errReply == nil {
-		return reply.MakeIntReply(deleted)
-	}
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return reply.MakeIntReply(deleted)
 }
@@ -0,0 +1,406 @@
+package cluster
+
+import (
+	"go_redis_write/config"
+	"go_redis_write/database"
+	databaseface "go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/connection"
+	"go_redis_write/resp/reply"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//故障转移: 和redis cluster一样，每个主节点可以有多个从节点(CLUSTER REPLICATE)，从节点通过主从复制同步主节点的数据
+//1. 从节点发现主节点被标记为FAIL后，等待 500ms+随机0~500ms+rank*1s (rank小的从节点先发起选举)
+//2. 从节点把currentEpoch加一，向所有主节点发送 AUTH_REQUEST <header> <epoch> <manual>
+//3. 负责槽的主节点在每个epoch只投一票，并且在2倍cluster-node-timeout内不会为同一个主节点的从节点重复投票
+//4. 得到超过半数主节点的投票后，从节点成为主节点，以选举的epoch作为config epoch接管原主节点的槽，
+//   其他节点通过gossip发现同一个槽的config epoch更大，就会更新槽的归属；原主节点恢复后成为新主节点的从节点
+//CLUSTER FAILOVER 手动故障转移: 主节点收到 MFSTART 后暂停写命令并返回复制偏移量，从节点同步到这个偏移量后发起选举，
+//FORCE 不等待主节点直接发起选举，TAKEOVER 不经过选举直接增加epoch接管槽
+
+const (
+	// manualFailoverTimeout is the timeout of CLUSTER FAILOVER, master stops pausing writes after it
+	manualFailoverTimeout = 5 * time.Second
+)
+
+// failoverState is the election started by this replica, it is guarded by topology.mu
+type failoverState struct {
+	authTime  time.Time // time to start the election, zero if there is no election
+	authEpoch uint64    // epoch of the election
+	authSent  bool      // AUTH_REQUEST has been sent
+	authCount int       // votes received
+	// manual failover, mfEnd is zero if there is no manual failover
+	mfEnd          time.Time
+	mfMasterOffset int64 // replication offset of master, -1 if master hasn't replied MFSTART
+	mfCanStart     bool  // replica has caught up with master, or FORCE
+}
+
+// authTimeout returns how long an election lasts, a new election starts after twice of it
+func authTimeout() time.Duration {
+	timeout := nodeTimeout() * 2
+	if timeout < 2*time.Second {
+		timeout = 2 * time.Second
+	}
+	return timeout
+}
+
+// replicate makes this node a replica of master by REPLICAOF, this node must not serve slots
+func (cluster *ClusterDatabase) replicate(master *Node) {
+	atomic.StoreInt64(&cluster.pauseUntil, 0)
+	host, port := splitAddr(master.Addr)
+	result := cluster.db.Exec(&connection.FakeConn{}, utils.ToCmdLine("replicaof", host, strconv.Itoa(port)))
+	if reply.IsErrorReply(result) {
+		logger.Warn("replicate " + master.Addr + " failed: " + string(result.ToBytes()))
+	}
+}
+
+// execReplicate handles CLUSTER REPLICATE node-id
+func (cluster *ClusterDatabase) execReplicate(args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("cluster|replicate")
+	}
+	t := cluster.topology
+	master := t.getNode(string(args[2]))
+	if master == nil {
+		return reply.MakeErrReply("ERR Unknown node " + string(args[2]))
+	}
+	if master == t.self {
+		return reply.MakeErrReply("ERR Can't replicate myself")
+	}
+	t.mu.Lock()
+	if master.master != nil {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR I can only replicate a master, not a replica.")
+	}
+	if t.self.master == nil && (t.hasSlots(t.self) || cluster.holdsKeys()) {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR To set a master the node must be empty and without assigned slots.")
+	}
+	t.self.master = master
+	t.dirty = true
+	t.mu.Unlock()
+	cluster.replicate(master)
+	return reply.MakeOkReply()
+}
+
+// holdsKeys returns true if any db of this node has keys, it stops at the first key found
+func (cluster *ClusterDatabase) holdsKeys() bool {
+	found := false
+	for i := 0; i < config.Properties.Databases && !found; i++ {
+		cluster.db.ForEach(i, func(string, *databaseface.DataEntity, *time.Time) bool {
+			found = true
+			return false
+		})
+	}
+	return found
+}
+
+// execFailover handles CLUSTER FAILOVER [FORCE|TAKEOVER]
+func (cluster *ClusterDatabase) execFailover(args [][]byte) resp.Reply {
+	if len(args) > 3 {
+		return reply.MakeArgNumErrReply("cluster|failover")
+	}
+	force, takeover := false, false
+	if len(args) == 3 {
+		switch strings.ToLower(string(args[2])) {
+		case "force":
+			force = true
+		case "takeover":
+			takeover = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	master := t.self.master
+	if master == nil {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR You should send CLUSTER FAILOVER to a replica")
+	}
+	if !force && !takeover && master.flags&flagFail > 0 {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE")
+	}
+	if takeover {
+		// no election, take slots with a new epoch
+		t.currentEpoch++
+		t.self.configEpoch = t.currentEpoch
+		cluster.failover.authEpoch = t.currentEpoch
+		cluster.promote()
+		t.mu.Unlock()
+		cluster.afterPromotion()
+		return reply.MakeOkReply()
+	}
+	cluster.failover = failoverState{
+		mfEnd:          time.Now().Add(manualFailoverTimeout),
+		mfMasterOffset: -1,
+		mfCanStart:     force,
+	}
+	masterAddr := master.busAddr()
+	t.mu.Unlock()
+	logger.Info("manual failover user request accepted")
+	if !force {
+		go cluster.requestManualFailover(masterAddr)
+	}
+	return reply.MakeOkReply()
+}
+
+// requestManualFailover asks master to pause writes and gets its replication offset
+func (cluster *ClusterDatabase) requestManualFailover(masterAddr string) {
+	result, err := sendBusMessage(masterAddr, pingInterval(), utils.ToCmdLine("MFSTART"))
+	if err != nil {
+		logger.Warn("manual failover: send MFSTART failed: " + err.Error())
+		return
+	}
+	offsetReply, ok := result.(*reply.IntReply)
+	if !ok {
+		logger.Warn("manual failover: unexpected reply of MFSTART: " + string(result.ToBytes()))
+		return
+	}
+	cluster.topology.mu.Lock()
+	if !cluster.failover.mfEnd.IsZero() {
+		cluster.failover.mfMasterOffset = offsetReply.Code
+	}
+	cluster.topology.mu.Unlock()
+}
+
+// handleMFStart pauses writes of clients on master until manual failover ends, it returns replication offset
+func (cluster *ClusterDatabase) handleMFStart() resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	isMaster := t.self.master == nil
+	t.mu.RUnlock()
+	if !isMaster {
+		return reply.MakeErrReply("ERR not a master")
+	}
+	atomic.StoreInt64(&cluster.pauseUntil, time.Now().Add(2*manualFailoverTimeout).UnixNano())
+	logger.Info("manual failover requested by replica, pause writes")
+	return reply.MakeIntReply(cluster.replOffset())
+}
+
+// replOffset returns master_repl_offset of master, or processed offset of replica
+func (cluster *ClusterDatabase) replOffset() int64 {
+	role, ok := cluster.db.Exec(&connection.FakeConn{}, utils.ToCmdLine("role")).(*reply.MultiRawReply)
+	if !ok || len(role.Replies) < 2 {
+		return 0
+	}
+	offset := role.Replies[len(role.Replies)-1] // offset of replica is the last one
+	if len(role.Replies) == 3 {
+		offset = role.Replies[1]
+	}
+	if intReply, ok := offset.(*reply.IntReply); ok {
+		return intReply.Code
+	}
+	return 0
+}
+
+// waitPause blocks writes of clients while master is pausing for manual failover
+func (cluster *ClusterDatabase) waitPause(cmdLine [][]byte) {
+	if atomic.LoadInt64(&cluster.pauseUntil) == 0 {
+		return
+	}
+	writeKeys, _, err := database.GetRelatedKeys(cmdLine)
+	if err != nil || len(writeKeys) == 0 {
+		return
+	}
+	for time.Now().UnixNano() < atomic.LoadInt64(&cluster.pauseUntil) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// handleFailover runs election if master of this replica failed or manual failover is ready, it is called by gossipCron
+func (cluster *ClusterDatabase) handleFailover() {
+	t := cluster.topology
+	f := &cluster.failover
+	now := time.Now()
+	t.mu.Lock()
+	if !f.mfEnd.IsZero() && now.After(f.mfEnd) {
+		logger.Warn("manual failover timeout")
+		*f = failoverState{}
+	}
+	manual := !f.mfEnd.IsZero()
+	if manual && !f.mfCanStart && f.mfMasterOffset >= 0 {
+		t.mu.Unlock()
+		offset := cluster.replOffset() // don't hold lock while reading offset
+		t.mu.Lock()
+		if offset >= f.mfMasterOffset && !f.mfEnd.IsZero() {
+			logger.Info("manual failover: replica caught up with master, start election")
+			f.mfCanStart = true
+		}
+	}
+	master := t.self.master
+	if master == nil || !t.hasSlots(master) || master.flags&flagFail == 0 && !(manual && f.mfCanStart) {
+		t.mu.Unlock()
+		return
+	}
+	timeout := authTimeout()
+	if f.authTime.IsZero() || now.Sub(f.authTime) > 2*timeout {
+		// schedule a new election, the replica of smaller rank starts earlier
+		f.authTime = now
+		if !manual {
+			f.authTime = now.Add(500*time.Millisecond + time.Duration(rand.Intn(500))*time.Millisecond +
+				time.Duration(t.replicaRank())*time.Second)
+		}
+		f.authSent = false
+		f.authCount = 0
+		logger.Info("start of election delayed for " + f.authTime.Sub(now).String())
+		t.mu.Unlock()
+		return
+	}
+	if now.Before(f.authTime) || now.Sub(f.authTime) > timeout {
+		t.mu.Unlock()
+		return
+	}
+	if !f.authSent {
+		t.currentEpoch++
+		f.authEpoch = t.currentEpoch
+		f.authSent = true
+		t.dirty = true
+		addrs := make([]string, 0)
+		for _, node := range t.nodes {
+			if node != t.self && node.master == nil && node.flags&flagFail == 0 && t.hasSlots(node) {
+				addrs = append(addrs, node.busAddr())
+			}
+		}
+		msg := [][]byte{
+			[]byte("AUTH_REQUEST"),
+			[]byte(t.formatNodeLine(t.self, nil)),
+			[]byte(strconv.FormatUint(f.authEpoch, 10)),
+			[]byte(strconv.FormatBool(manual)),
+		}
+		logger.Info("starting a failover election for epoch " + strconv.FormatUint(f.authEpoch, 10))
+		t.mu.Unlock()
+		for _, addr := range addrs {
+			go cluster.requestVote(addr, msg, f.authEpoch)
+		}
+		return
+	}
+	if f.authCount < t.mastersWithSlots()/2+1 {
+		t.mu.Unlock()
+		return
+	}
+	logger.Info("failover election won")
+	cluster.promote()
+	t.mu.Unlock()
+	cluster.afterPromotion()
+}
+
+// replicaRank returns the number of replicas of the same master whose id is smaller, topology.mu must be held
+func (t *topology) replicaRank() int {
+	rank := 0
+	for _, node := range t.nodes {
+		if node != t.self && node.master == t.self.master && node.flags == 0 && node.ID < t.self.ID {
+			rank++
+		}
+	}
+	return rank
+}
+
+// requestVote sends AUTH_REQUEST to a master and counts its vote
+func (cluster *ClusterDatabase) requestVote(addr string, msg [][]byte, epoch uint64) {
+	result, err := sendBusMessage(addr, pingInterval(), msg)
+	if err != nil {
+		return
+	}
+	status, ok := result.(*reply.StatusReply)
+	if !ok || status.Status != "ACK" {
+		return
+	}
+	cluster.topology.mu.Lock()
+	if cluster.failover.authEpoch == epoch && cluster.failover.authSent {
+		cluster.failover.authCount++
+	}
+	cluster.topology.mu.Unlock()
+}
+
+// promote makes this replica the master of slots of its master with the epoch of election, topology.mu must be held
+func (cluster *ClusterDatabase) promote() {
+	t := cluster.topology
+	old := t.self.master
+	t.self.master = nil
+	if t.self.configEpoch < cluster.failover.authEpoch {
+		t.self.configEpoch = cluster.failover.authEpoch
+	}
+	for slot, owner := range t.slots {
+		if owner == old {
+			t.slots[slot] = t.self
+		}
+	}
+	cluster.failover = failoverState{}
+	t.dirty = true
+	logger.Info("failover: I'm the new master with config epoch " + strconv.FormatUint(t.self.configEpoch, 10))
+}
+
+// afterPromotion stops replication and tells all nodes the new owner of slots at once
+func (cluster *ClusterDatabase) afterPromotion() {
+	cluster.db.Exec(&connection.FakeConn{}, utils.ToCmdLine("replicaof", "no", "one"))
+	cluster.saveConfig()
+	t := cluster.topology
+	t.mu.RLock()
+	nodes := make([]*Node, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		if node != t.self {
+			nodes = append(nodes, node)
+		}
+	}
+	t.mu.RUnlock()
+	for _, node := range nodes {
+		go cluster.sendPing(node, "PING")
+	}
+}
+
+// handleAuthRequest votes for a replica whose master failed, or in manual failover.
+// A master votes once in an epoch, and votes for replicas of the same master once in twice of cluster-node-timeout
+func (cluster *ClusterDatabase) handleAuthRequest(args [][]byte) resp.Reply {
+	if len(args) != 4 {
+		return reply.MakeArgNumErrReply("auth_request")
+	}
+	info, err := parseNodeLine(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	epoch, err := strconv.ParseUint(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid epoch")
+	}
+	manual := string(args[3]) == "true"
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	nack := reply.MakeStatusReply("NACK")
+	if t.self.master != nil || !t.hasSlots(t.self) {
+		return nack
+	}
+	if epoch > t.currentEpoch {
+		t.currentEpoch = epoch
+		t.dirty = true
+	}
+	node := t.nodes[info.id]
+	if epoch < t.currentEpoch || t.lastVoteEpoch == t.currentEpoch || node == nil {
+		return nack
+	}
+	master := t.nodes[info.master]
+	if master == nil || master.master != nil {
+		return nack
+	}
+	if master.flags&flagFail == 0 && !manual {
+		return nack
+	}
+	now := time.Now()
+	if now.Sub(master.votedTime) < 2*nodeTimeout() {
+		return nack
+	}
+	t.lastVoteEpoch = t.currentEpoch
+	master.votedTime = now
+	t.dirty = true
+	logger.Info("failover auth granted to " + node.ID + " " + node.Addr + " for epoch " + strconv.FormatUint(t.currentEpoch, 10))
+	return reply.MakeStatusReply("ACK")
+}
@@ -0,0 +1,501 @@
+package cluster
+
+import (
+	"errors"
+	"go_redis_write/config"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/resp/parser"
+	"go_redis_write/resp/reply"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//集群总线: 每个节点在 port+10000 (或者cluster-port) 上监听，节点之间用RESP格式的消息交换状态
+//PING/MEET <header> <gossip> ...  对方回复 PONG <header> <gossip> ...
+//  header是发送者自己在CLUSTER NODES中的那一行(包括它负责的槽)，gossip是发送者随机挑选的其他几个节点的行
+//  只有MEET能让对方认识一个新节点，之后新节点通过gossip传播给所有节点
+//FAIL <node-id>  通知其他节点某个节点已经下线
+//节点在 cluster-node-timeout 内没有回复PONG会被标记为PFAIL(疑似下线)，当超过半数的主节点认为它PFAIL时标记为FAIL并广播
+//节点恢复后回复PONG，PFAIL和FAIL状态随之清除
+
+const (
+	// gossipCronInterval is the interval of checking nodes and sending pings
+	gossipCronInterval = 100 * time.Millisecond
+	// maxPingInterval is the max interval of pinging a node
+	maxPingInterval = time.Second
+	// gossipCount is the max number of other nodes carried by a message
+	gossipCount = 3
+	// forgetBlacklistTTL is how long a forgotten node can't be added back by gossip
+	forgetBlacklistTTL = time.Minute
+)
+
+// nodeTimeout returns cluster-node-timeout
+func nodeTimeout() time.Duration {
+	if config.Properties.ClusterNodeTimeout <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(config.Properties.ClusterNodeTimeout) * time.Millisecond
+}
+
+// pingInterval returns interval of pinging a node, it is also the timeout of a bus message
+func pingInterval() time.Duration {
+	interval := nodeTimeout() / 2
+	if interval > maxPingInterval {
+		interval = maxPingInterval
+	}
+	return interval
+}
+
+// startBus listens on cluster bus port and starts pinging other nodes
+func (cluster *ClusterDatabase) startBus() error {
+	addr := net.JoinHostPort(config.Properties.Bind, strconv.Itoa(cluster.topology.self.BusPort))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("cluster bus listens on " + addr)
+	cluster.busListener = listener
+	go cluster.serveBus(listener)
+	go cluster.gossipCron()
+	return nil
+}
+
+// stopBus closes cluster bus and stops pinging, it may be called more than once
+func (cluster *ClusterDatabase) stopBus() {
+	if cluster.busListener == nil {
+		return
+	}
+	cluster.busStopOnce.Do(func() {
+		close(cluster.busClosing)
+		_ = cluster.busListener.Close()
+	})
+}
+
+func (cluster *ClusterDatabase) serveBus(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		go cluster.handleBusConn(conn)
+	}
+}
+
+func (cluster *ClusterDatabase) handleBusConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	for payload := range parser.ParseStream(conn) {
+		var result resp.Reply
+		if payload.Err != nil {
+			result = reply.MakeErrReply(payload.Err.Error())
+		} else if msg, ok := payload.Data.(*reply.MultiBulkReply); ok && len(msg.Args) > 0 {
+			result = cluster.handleBusMessage(msg.Args)
+		} else {
+			result = reply.MakeErrReply("ERR invalid bus message")
+		}
+		if _, err := conn.Write(result.ToBytes()); err != nil {
+			return
+		}
+	}
+}
+
+// handleBusMessage handles messages from other nodes
+func (cluster *ClusterDatabase) handleBusMessage(args [][]byte) resp.Reply {
+	switch strings.ToLower(string(args[0])) {
+	case "ping", "meet":
+		if len(args) < 2 {
+			return reply.MakePongReply()
+		}
+		cluster.processGossip(args[1], args[2:], strings.ToLower(string(args[0])) == "meet", nil)
+		return reply.MakeMultiBulkReply(cluster.makeGossipMessage("PONG"))
+	case "fail":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("fail")
+		}
+		cluster.processFail(string(args[1]))
+		return reply.MakeOkReply()
+	case "auth_request":
+		return cluster.handleAuthRequest(args)
+	case "mfstart":
+		return cluster.handleMFStart()
+	}
+	return reply.MakeErrReply("ERR unknown bus message '" + string(args[0]) + "'")
+}
+
+// makeGossipMessage returns a message of self and a few random other nodes
+func (cluster *ClusterDatabase) makeGossipMessage(msgType string) [][]byte {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	nodeSlots := t.nodeSlots()
+	msg := [][]byte{[]byte(msgType), []byte(t.formatNodeLine(t.self, nodeSlots[t.self]))}
+	others := make([]*Node, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		if node != t.self {
+			others = append(others, node)
+		}
+	}
+	rand.Shuffle(len(others), func(i, j int) {
+		others[i], others[j] = others[j], others[i]
+	})
+	if len(others) > gossipCount {
+		others = others[:gossipCount]
+	}
+	for _, node := range others {
+		msg = append(msg, []byte(t.formatNodeLine(node, nil)))
+	}
+	return msg
+}
+
+// processGossip updates nodes with a message from sender, pongFrom is the node pinged by this node if the message is PONG.
+// Unknown sender is added only by MEET, unknown nodes in gossip section are added if sender is known,
+// PFAIL and FAIL in gossip section are counted as failure reports of sender
+func (cluster *ClusterDatabase) processGossip(header []byte, gossip [][]byte, meet bool, pongFrom *Node) {
+	info, err := parseNodeLine(string(header))
+	if err != nil {
+		logger.Warn("invalid gossip header: " + err.Error())
+		return
+	}
+	t := cluster.topology
+	now := time.Now()
+	recovered := false
+	failed := make([]*Node, 0)
+	t.mu.Lock()
+	sender := t.nodes[info.id]
+	if sender == t.self {
+		t.mu.Unlock()
+		return
+	}
+	if sender == nil && pongFrom != nil && t.nodes[pongFrom.ID] == pongFrom {
+		// id of node added by CLUSTER MEET is derived from address, replace it with the real id from its pong,
+		// the node serves no slot yet
+		delete(t.nodes, pongFrom.ID)
+		sender = newNode(info.id, info.addr, info.busPort)
+		t.nodes[sender.ID] = sender
+		t.dirty = true
+	}
+	if sender == nil {
+		if !meet || t.isBlacklisted(info.id) {
+			t.mu.Unlock()
+			return
+		}
+		sender = newNode(info.id, info.addr, info.busPort)
+		t.nodes[sender.ID] = sender
+		t.dirty = true
+		logger.Info("meet node " + sender.ID + " " + sender.Addr)
+	}
+	if sender.Addr != info.addr || sender.BusPort != info.busPort {
+		sender.Addr = info.addr
+		sender.BusPort = info.busPort
+		t.dirty = true
+	}
+	if pongFrom != nil {
+		sender.pingSent = time.Time{}
+		sender.pongRecv = now
+		sender.linkUp = true
+		if sender.flags != 0 {
+			recovered = sender.flags&flagFail > 0
+			sender.flags = 0
+			t.dirty = true
+			logger.Info("node " + sender.ID + " " + sender.Addr + " is reachable again")
+		}
+	}
+	newMaster := t.updateRole(sender, info)
+	for _, line := range gossip {
+		g, err := parseNodeLine(string(line))
+		if err != nil || g.id == t.self.ID {
+			continue
+		}
+		node := t.nodes[g.id]
+		if node == nil {
+			if !t.isBlacklisted(g.id) {
+				node = newNode(g.id, g.addr, g.busPort)
+				t.nodes[node.ID] = node
+				t.dirty = true
+				logger.Info("found node " + node.ID + " " + node.Addr + " by gossip of " + sender.Addr)
+			}
+			continue
+		}
+		if sender.master != nil { // only masters report failures
+			continue
+		}
+		if g.hasFlag("fail?") || g.hasFlag("fail") {
+			node.failReports[sender.ID] = now
+			if t.markFailIfNeeded(node) {
+				failed = append(failed, node)
+			}
+		} else {
+			delete(node.failReports, sender.ID)
+		}
+	}
+	t.mu.Unlock()
+	if recovered {
+		cluster.resetPool(sender.Addr)
+	}
+	for _, node := range failed {
+		cluster.broadcastFail(node)
+	}
+	if newMaster != nil {
+		cluster.replicate(newMaster)
+	}
+}
+
+// updateRole updates role, config epoch and slots of sender by its header without lock.
+// Sender takes slots not served or served by node with smaller config epoch, e.g. a replica won the election.
+// It returns the new master if this node should replicate it: this node or its master lost all slots to sender
+func (t *topology) updateRole(sender *Node, info *nodeInfo) *Node {
+	if info.master == "-" {
+		if sender.master != nil {
+			sender.master = nil
+			t.dirty = true
+		}
+	} else if master := t.nodes[info.master]; master != nil && master != sender && sender.master != master {
+		sender.master = master
+		t.dirty = true
+	}
+	if info.configEpoch > sender.configEpoch {
+		sender.configEpoch = info.configEpoch
+		t.dirty = true
+	}
+	if info.configEpoch > t.currentEpoch {
+		t.currentEpoch = info.configEpoch
+		t.dirty = true
+	}
+	if sender.master != nil {
+		return nil
+	}
+	self := t.self
+	lost := false
+	for _, r := range info.slots {
+		for slot := r[0]; slot <= r[1]; slot++ {
+			owner := t.slots[slot]
+			if owner == sender || (owner != nil && owner.configEpoch >= sender.configEpoch) {
+				continue
+			}
+			if owner != nil && (owner == self || owner == self.master) {
+				lost = true
+			}
+			t.slots[slot] = sender
+			t.dirty = true
+		}
+	}
+	if !lost {
+		return nil
+	}
+	// a master which lost all slots becomes replica of the new owner, so do its replicas
+	if self.master == nil && !t.hasSlots(self) || self.master != nil && !t.hasSlots(self.master) {
+		logger.Info("slots are taken by " + sender.ID + " " + sender.Addr + ", replicate it")
+		self.master = sender
+		t.dirty = true
+		return sender
+	}
+	return nil
+}
+
+// markFailIfNeeded marks PFAIL node as FAIL if majority of masters serving slots can't reach it, topology.mu must be held.
+// It returns true if the node is marked as FAIL
+func (t *topology) markFailIfNeeded(node *Node) bool {
+	if node.flags&flagPFail == 0 || node.flags&flagFail > 0 {
+		return false
+	}
+	masters := make(map[*Node]struct{})
+	for _, owner := range t.slots {
+		if owner != nil {
+			masters[owner] = struct{}{}
+		}
+	}
+	//只有负责slot的master才有投票权，本节点是replica或没有slot时自己不算
+	reports := 0
+	if _, ok := masters[t.self]; ok && t.self.master == nil {
+		reports++ // this node can't reach it too
+	}
+	validSince := time.Now().Add(-2 * nodeTimeout())
+	for id, at := range node.failReports {
+		if at.Before(validSince) {
+			delete(node.failReports, id)
+			continue
+		}
+		reporter := t.nodes[id]
+		if reporter == nil || reporter == t.self || reporter.master != nil {
+			continue
+		}
+		if _, ok := masters[reporter]; ok {
+			reports++
+		}
+	}
+	if reports < len(masters)/2+1 {
+		return false
+	}
+	node.flags = flagFail
+	t.dirty = true
+	logger.Info("marking node " + node.ID + " " + node.Addr + " as failing (quorum reached)")
+	return true
+}
+
+// processFail marks the node as FAIL as told by other node
+func (cluster *ClusterDatabase) processFail(id string) {
+	t := cluster.topology
+	t.mu.Lock()
+	node := t.nodes[id]
+	if node == nil || node == t.self || node.flags&flagFail > 0 {
+		t.mu.Unlock()
+		return
+	}
+	node.flags = flagFail
+	t.dirty = true
+	t.mu.Unlock()
+	logger.Info("node " + id + " " + node.Addr + " is marked as failing by other node")
+}
+
+// broadcastFail tells all reachable nodes that the node is FAIL
+func (cluster *ClusterDatabase) broadcastFail(failed *Node) {
+	t := cluster.topology
+	t.mu.RLock()
+	addrs := make([]string, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		if node != t.self && node != failed && node.flags&flagFail == 0 {
+			addrs = append(addrs, node.busAddr())
+		}
+	}
+	t.mu.RUnlock()
+	msg := [][]byte{[]byte("FAIL"), []byte(failed.ID)}
+	for _, addr := range addrs {
+		go func(addr string) {
+			_, _ = sendBusMessage(addr, pingInterval(), msg)
+		}(addr)
+	}
+}
+
+// gossipCron pings nodes periodically and marks nodes not replying in cluster-node-timeout as PFAIL
+func (cluster *ClusterDatabase) gossipCron() {
+	ticker := time.NewTicker(gossipCronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cluster.busClosing:
+			return
+		case <-ticker.C:
+			cluster.gossipTick()
+		}
+	}
+}
+
+func (cluster *ClusterDatabase) gossipTick() {
+	t := cluster.topology
+	now := time.Now()
+	timeout := nodeTimeout()
+	interval := pingInterval()
+	toPing := make([]*Node, 0)
+	failed := make([]*Node, 0)
+	t.mu.Lock()
+	for _, node := range t.nodes {
+		if node == t.self {
+			continue
+		}
+		if !node.pingSent.IsZero() && now.Sub(node.pingSent) > timeout && node.flags == 0 {
+			node.flags = flagPFail
+			t.dirty = true
+			logger.Info("node " + node.ID + " " + node.Addr + " is possibly failing")
+			if t.markFailIfNeeded(node) {
+				failed = append(failed, node)
+			}
+		}
+		if !node.pinging && now.Sub(node.lastPing) >= interval {
+			node.pinging = true
+			node.lastPing = now
+			if node.pingSent.IsZero() {
+				node.pingSent = now
+			}
+			toPing = append(toPing, node)
+		}
+	}
+	dirty := t.dirty
+	t.dirty = false
+	t.mu.Unlock()
+	for _, node := range toPing {
+		go cluster.sendPing(node, "PING")
+	}
+	for _, node := range failed {
+		cluster.broadcastFail(node)
+	}
+	cluster.handleFailover()
+	if dirty {
+		cluster.saveConfig()
+	}
+}
+
+// sendPing sends PING or MEET to the node and handles its PONG
+func (cluster *ClusterDatabase) sendPing(node *Node, msgType string) {
+	t := cluster.topology
+	t.mu.RLock()
+	addr := node.busAddr()
+	t.mu.RUnlock()
+	result, err := sendBusMessage(addr, pingInterval(), cluster.makeGossipMessage(msgType))
+	if err == nil {
+		if pong, ok := result.(*reply.MultiBulkReply); ok && len(pong.Args) >= 2 {
+			cluster.processGossip(pong.Args[1], pong.Args[2:], false, node)
+		} else {
+			err = errors.New("unexpected reply " + string(result.ToBytes()))
+		}
+	}
+	t.mu.Lock()
+	node.pinging = false
+	if err != nil {
+		node.linkUp = false
+	}
+	t.mu.Unlock()
+}
+
+// meet sends MEET to the node, so that it adds this node and tells other nodes about this node
+func (cluster *ClusterDatabase) meet(node *Node) {
+	t := cluster.topology
+	t.mu.Lock()
+	node.pinging = true
+	node.lastPing = time.Now()
+	if node.pingSent.IsZero() {
+		node.pingSent = node.lastPing
+	}
+	t.mu.Unlock()
+	cluster.sendPing(node, "MEET")
+}
+
+// forget removes the node from this node, other nodes should forget it in a minute, otherwise it comes back by gossip
+func (cluster *ClusterDatabase) forget(node *Node) {
+	cluster.topology.removeNode(node)
+	cluster.resetPool(node.Addr)
+	logger.Info("forget node " + node.ID + " " + node.Addr)
+}
+
+// sendBusMessage sends a message to cluster bus of other node and returns its reply
+func sendBusMessage(addr string, timeout time.Duration, msg [][]byte) (resp.Reply, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	ch := parser.ParseStream(conn)
+	defer func() {
+		_ = conn.Close()
+		go func() {
+			for range ch { // parser stops after reading error of closed connection
+			}
+		}()
+	}()
+	_, err = conn.Write(reply.MakeMultiBulkReply(msg).ToBytes())
+	if err != nil {
+		return nil, err
+	}
+	payload, ok := <-ch
+	if !ok {
+		return nil, errors.New("connection closed")
+	}
+	if payload.Err != nil {
+		return nil, payload.Err
+	}
+	return payload.Data, nil
+}
@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/resp/reply"
+	"strings"
+)
+
+// execInfo returns INFO of local db, with the pool section of connections to other nodes
+func execInfo(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	result := cluster.db.Exec(c, args)
+	info, ok := result.(*reply.BulkReply)
+	if !ok {
+		return result
+	}
+	section := "default"
+	if len(args) == 2 {
+		section = strings.ToLower(string(args[1]))
+	}
+	switch section {
+	case "default", "all", "everything", "pool":
+		text := string(info.Arg)
+		if text != "" {
+			text += "\r\n"
+		}
+		return reply.MakeBulkReply([]byte(text + cluster.poolInfo()))
+	}
+	return info
+}
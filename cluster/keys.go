@@ -2,21 +2,218 @@ package cluster
 
 import (
 	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
 	"go_redis_write/resp/reply"
+	"math/rand"
+	"strconv"
+	"strings"
 )
 
-// FlushDB removes all data in current database
-func FlushDB(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	replies := cluster.broadcast(c, args)
-	var errReply reply.ErrorReply
-	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
+//KEYS、SCAN、DBSIZE、RANDOMKEY、FLUSHDB、FLUSHALL 访问所有节点的数据，收到命令的节点并发地发给所有主节点再合并结果
+//转发给其他节点时命令加上前缀 _，其他节点只在本地执行，避免再次广播
+//SCAN的游标低14位是主节点的序号(按地址排序)，高位是这个节点本地的游标，扫描期间增删节点可能会漏掉或重复一些key
+
+const (
+	relayLocalPrefix = "_"
+	// scanNodeBits is the number of bits of node index in cursor of SCAN, enough for 16384 masters
+	scanNodeBits = 14
+)
+
+// Exists counts existing keys in cluster, each node receives one EXISTS with its keys
+func Exists(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("exists")
+	}
+	groups := cluster.groupByPeer(args[1:])
+	if len(groups) == 1 {
+		for peer := range groups {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for peer, keys := range groups {
+		cmdLines[peer] = utils.ToCmdLine2("exists", keys...)
+	}
+	var count int64 = 0
+	for peer, r := range cluster.relayGroups(c, cmdLines) {
+		if reply.IsErrorReply(r) {
+			return r
+		}
+		intReply, ok := r.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply of exists from " + peer)
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// Keys returns keys matching the pattern on all nodes
+func Keys(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("keys")
+	}
+	replies, errs := cluster.broadcast(c, args, failFast)
+	if errs != nil {
+		return errs.toReply("keys")
+	}
+	result := make([][]byte, 0)
+	for node, v := range replies {
+		switch v := v.(type) {
+		case *reply.MultiBulkReply:
+			result = append(result, v.Args...)
+		case *reply.EmptyMultiBulkReply:
+		default:
+			return makeBroadcastErrReply("keys", node, v)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// DBSize returns the number of keys on all nodes
+func DBSize(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("dbsize")
+	}
+	replies, errs := cluster.broadcast(c, args, failFast)
+	if errs != nil {
+		return errs.toReply("dbsize")
+	}
+	var count int64 = 0
+	for node, v := range replies {
+		intReply, ok := v.(*reply.IntReply)
+		if !ok {
+			return makeBroadcastErrReply("dbsize", node, v)
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// RandomKey returns a random key of a random node which is not empty, failed nodes are skipped
+func RandomKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("randomkey")
+	}
+	replies, errs := cluster.broadcast(c, args, bestEffort)
+	keys := make([][]byte, 0)
+	for node, v := range replies {
+		switch v := v.(type) {
+		case *reply.BulkReply:
+			keys = append(keys, v.Arg)
+		case *reply.NullBulkReply:
+		default:
+			return makeBroadcastErrReply("randomkey", node, v)
+		}
+	}
+	if len(keys) == 0 {
+		if errs != nil { // keys may be on failed nodes
+			return errs.toReply("randomkey")
 		}
+		return reply.MakeNullBulkReply()
 	}
-	if errReply == nil {
-		return &reply.OkReply{}
+	return reply.MakeBulkReply(keys[rand.Intn(len(keys))])
+}
+
+// Scan iterates keys of masters one by one, the cursor encodes the index of node and the cursor on that node
+func Scan(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("scan")
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	masters := cluster.topology.masters()
+	index := int(cursor & (1<<scanNodeBits - 1))
+	if index >= len(masters) { // nodes are removed during scanning
+		return makeScanReply(0, nil)
+	}
+	nodeArgs := make([][]byte, len(args))
+	copy(nodeArgs, args)
+	nodeArgs[1] = []byte(strconv.FormatUint(cursor>>scanNodeBits, 10))
+	node := masters[index].Addr
+	result := cluster.relayLocal(node, c, nodeArgs)
+	nodeCursor, keys, ok := parseScanReply(result)
+	if !ok {
+		return makeBroadcastErrReply("scan", node, result)
+	}
+	if nodeCursor == 0 { // this node is finished, continue with the next one
+		index++
+		if index == len(masters) {
+			return makeScanReply(0, keys)
+		}
+	}
+	return makeScanReply(nodeCursor<<scanNodeBits|uint64(index), keys)
+}
+
+// parseScanReply parses [cursor, [keys...]] from local db, or [cursor, keys...] relayed by other node,
+// since nested array can't be parsed by client
+func parseScanReply(result resp.Reply) (uint64, [][]byte, bool) {
+	var cursorArg []byte
+	var keys [][]byte
+	switch result := result.(type) {
+	case *reply.MultiRawReply:
+		if len(result.Replies) != 2 {
+			return 0, nil, false
+		}
+		cursorReply, ok1 := result.Replies[0].(*reply.BulkReply)
+		keysReply, ok2 := result.Replies[1].(*reply.MultiBulkReply)
+		if !ok1 || !ok2 {
+			return 0, nil, false
+		}
+		cursorArg, keys = cursorReply.Arg, keysReply.Args
+	case *reply.MultiBulkReply:
+		if len(result.Args) == 0 {
+			return 0, nil, false
+		}
+		cursorArg, keys = result.Args[0], result.Args[1:]
+	default:
+		return 0, nil, false
+	}
+	cursor, err := strconv.ParseUint(string(cursorArg), 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	return cursor, keys, true
+}
+
+// makeScanReply returns [cursor, [keys...]]
+func makeScanReply(cursor uint64, keys [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		reply.MakeMultiBulkReply(keys),
+	})
+}
+
+// makeBroadcastErrReply returns the error of node, or an error about unexpected reply
+func makeBroadcastErrReply(cmdName string, node string, result resp.Reply) resp.Reply {
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return reply.MakeErrReply("error occurs on " + node + ": " + errReply.Error())
+	}
+	return reply.MakeErrReply("ERR unexpected reply of " + cmdName + " from " + node)
+}
+
+// execRelayedLocal executes command relayed by relayLocal on data of this node
+func execRelayedLocal(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	localArgs := make([][]byte, len(args))
+	copy(localArgs, args)
+	localArgs[0] = []byte(strings.TrimPrefix(string(args[0]), relayLocalPrefix))
+	result := cluster.db.Exec(c, localArgs)
+	if strings.EqualFold(string(localArgs[0]), "scan") {
+		if cursor, keys, ok := parseScanReply(result); ok { // flatten for client of relaying node
+			return reply.MakeMultiBulkReply(append([][]byte{[]byte(strconv.FormatUint(cursor, 10))}, keys...))
+		}
+	}
+	return result
+}
+
+// FlushDB removes all data in current database, or all databases by FLUSHALL.
+// Reachable nodes are flushed even if some nodes fail, the error tells which nodes still have data
+func FlushDB(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	_, errs := cluster.broadcast(c, args, bestEffort)
+	if errs != nil {
+		return errs.toReply(string(args[0]))
 	}
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return &reply.OkReply{}
 }
@@ -0,0 +1,272 @@
+package cluster
+
+import (
+	"go_redis_write/config"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/client"
+	"go_redis_write/resp/connection"
+	"go_redis_write/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//槽迁移，和redis cluster的过程一样:
+//1. 目标节点 CLUSTER SETSLOT slot IMPORTING source-id
+//2. 源节点   CLUSTER SETSLOT slot MIGRATING target-id，之后源节点上不存在的key由目标节点处理(ASK)
+//3. 源节点   CLUSTER GETKEYSINSLOT + MIGRATE ... KEYS 把key分批搬到目标节点，直到槽里没有key
+//4. 所有节点 CLUSTER SETSLOT slot NODE target-id
+//CLUSTER REBALANCE 按这个过程一个槽一个槽地迁移，使每个节点负责的槽数量相同
+
+const (
+	// migrateBatchSize is the number of keys moved by one MIGRATE during rebalancing
+	migrateBatchSize = 100
+	// migrateTimeout is the timeout of MIGRATE during rebalancing
+	migrateTimeout = 5 * time.Second
+)
+
+// Migrate moves keys of current db to another node, keys are locked until the target replies
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+func Migrate(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 6 {
+		return reply.MakeArgNumErrReply("migrate")
+	}
+	addr := net.JoinHostPort(string(args[1]), string(args[2]))
+	destDB, err := strconv.Atoi(string(args[4]))
+	if err != nil || destDB < 0 {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(args[5]), 10, 64)
+	if err != nil || timeoutMs < 0 {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	copyKeys := false
+	replace := false
+	var keys []string
+	if len(args[3]) > 0 {
+		keys = []string{string(args[3])}
+	}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "keys":
+			if len(args[3]) > 0 {
+				return reply.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if len(keys) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+	return cluster.migrateKeys(c.GetDBIndex(), addr, destDB, timeout, keys, copyKeys, replace)
+}
+
+// migrateKeys sends keys to target by RESTORE and removes them unless copyKeys is set
+func (cluster *ClusterDatabase) migrateKeys(dbIndex int, addr string, destDB int, timeout time.Duration,
+	keys []string, copyKeys bool, replace bool) resp.Reply {
+	cluster.db.RWLocks(dbIndex, keys, nil)
+	defer cluster.db.RWUnLocks(dbIndex, keys, nil)
+
+	// RESTORE commands of existing keys
+	restores := make([][]string, 0, len(keys))
+	restoredKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		payload, ok := cluster.db.ExecWithLock(dbIndex, utils.ToCmdLine("dump", key)).(*reply.BulkReply)
+		if !ok || payload.Arg == nil {
+			continue
+		}
+		expireAt := "0"
+		pttl, ok := cluster.db.ExecWithLock(dbIndex, utils.ToCmdLine("pttl", key)).(*reply.IntReply)
+		if ok && pttl.Code >= 0 {
+			expireAt = strconv.FormatInt(time.Now().Add(time.Duration(pttl.Code)*time.Millisecond).UnixNano()/int64(time.Millisecond), 10)
+		}
+		restore := []string{"RESTORE", key, expireAt, string(payload.Arg), "ABSTTL"}
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+		restores = append(restores, restore)
+	}
+	if len(restores) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+
+	cli, err := client.MakeReplClient(addr)
+	if err != nil {
+		return reply.MakeErrReply("IOERR error or timeout connecting to " + addr + ": " + err.Error())
+	}
+	defer func() {
+		_ = cli.Close()
+	}()
+	var migrateErr error
+	_, migrateErr = cli.Request(timeout, "SELECT", strconv.Itoa(destDB))
+	for _, restore := range restores {
+		if migrateErr != nil {
+			break
+		}
+		// ASKING makes a cluster node accept keys of a slot being imported, servers without cluster don't know it
+		_, migrateErr = cli.Request(timeout, "ASKING")
+		if migrateErr != nil && strings.HasPrefix(migrateErr.Error(), "ERR unknown command") {
+			migrateErr = nil
+		}
+		if migrateErr != nil {
+			break
+		}
+		_, migrateErr = cli.Request(timeout, restore...)
+		if migrateErr == nil {
+			restoredKeys = append(restoredKeys, []byte(restore[1]))
+		}
+	}
+	if !copyKeys && len(restoredKeys) > 0 {
+		cluster.db.ExecWithLock(dbIndex, utils.ToCmdLine2("del", restoredKeys...))
+	}
+	if migrateErr != nil {
+		return reply.MakeErrReply("ERR Target instance replied with error: " + migrateErr.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// getKeysInSlot returns at most count keys of current db in the slot, keys expired but not removed yet may be included
+func (cluster *ClusterDatabase) getKeysInSlot(dbIndex int, slot uint32, count int) [][]byte {
+	return cluster.slotKeys.getKeys(dbIndex, slot, count)
+}
+
+// countKeysInSlot returns the number of keys of current db in the slot
+func (cluster *ClusterDatabase) countKeysInSlot(dbIndex int, slot uint32) int {
+	return cluster.slotKeys.count(dbIndex, slot)
+}
+
+// holdsKeysInSlot returns true if any db of this node has keys in the slot
+func (cluster *ClusterDatabase) holdsKeysInSlot(slot uint32) bool {
+	for i := 0; i < config.Properties.Databases; i++ {
+		if cluster.countKeysInSlot(i, slot) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// execOn executes cluster management commands on the given node
+func (cluster *ClusterDatabase) execOn(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer != cluster.self {
+		return cluster.relay(peer, c, args)
+	}
+	if strings.ToLower(string(args[0])) == "migrate" {
+		return Migrate(cluster, c, args)
+	}
+	return execCluster(cluster, c, args)
+}
+
+// moveSlot moves a slot and its keys of all dbs from source to target
+func (cluster *ClusterDatabase) moveSlot(slot uint32, source *Node, target *Node) error {
+	conn := &connection.FakeConn{}
+	slotStr := strconv.Itoa(int(slot))
+	result := cluster.execOn(target.Addr, conn, utils.ToCmdLine("cluster", "setslot", slotStr, "importing", source.ID))
+	if err, ok := result.(reply.ErrorReply); ok {
+		return err
+	}
+	result = cluster.execOn(source.Addr, conn, utils.ToCmdLine("cluster", "setslot", slotStr, "migrating", target.ID))
+	if err, ok := result.(reply.ErrorReply); ok {
+		return err
+	}
+	host, port := splitAddr(target.Addr)
+	for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+		conn.SelectDB(dbIndex)
+		for {
+			result = cluster.execOn(source.Addr, conn, utils.ToCmdLine("cluster", "getkeysinslot", slotStr, strconv.Itoa(migrateBatchSize)))
+			if err, ok := result.(reply.ErrorReply); ok {
+				return err
+			}
+			keys, ok := result.(*reply.MultiBulkReply)
+			if !ok || len(keys.Args) == 0 { // the parser returns empty multi bulk as another type
+				break
+			}
+			args := utils.ToCmdLine("migrate", host, strconv.Itoa(port), "", strconv.Itoa(dbIndex),
+				strconv.FormatInt(int64(migrateTimeout/time.Millisecond), 10), "replace", "keys")
+			args = append(args, keys.Args...)
+			result = cluster.execOn(source.Addr, conn, args)
+			if err, ok := result.(reply.ErrorReply); ok {
+				return err
+			}
+		}
+	}
+	// target first, so it serves the slot before source redirects requests to it
+	conn.SelectDB(0)
+	setNode := utils.ToCmdLine("cluster", "setslot", slotStr, "node", target.ID)
+	result = cluster.execOn(target.Addr, conn, setNode)
+	if err, ok := result.(reply.ErrorReply); ok {
+		return err
+	}
+	result = cluster.execOn(source.Addr, conn, setNode)
+	if err, ok := result.(reply.ErrorReply); ok {
+		return err
+	}
+	for _, addr := range cluster.nodeAddrs() {
+		if addr != target.Addr && addr != source.Addr {
+			result = cluster.execOn(addr, conn, setNode)
+			if reply.IsErrorReply(result) {
+				logger.Warn("set slot " + slotStr + " on " + addr + " failed: " + string(result.ToBytes()))
+			}
+		}
+	}
+	return nil
+}
+
+// rebalance moves slots slot by slot, so that every master serves the same number of slots, it returns the number of moved slots
+func (cluster *ClusterDatabase) rebalance() resp.Reply {
+	nodes := cluster.topology.masters()
+	counts := cluster.topology.slotCounts()
+	// nodes serving more slots than expected give their last slots to nodes serving less
+	expected := make(map[*Node]int, len(nodes))
+	for i, node := range nodes {
+		expected[node] = slotCount / len(nodes)
+		if i < slotCount%len(nodes) {
+			expected[node]++
+		}
+	}
+	type move struct {
+		slot   uint32
+		source *Node
+	}
+	moves := make([]move, 0)
+	for _, node := range nodes {
+		excess := counts[node] - expected[node]
+		if excess <= 0 {
+			continue
+		}
+		slots := cluster.topology.slotsOf(node)
+		for _, slot := range slots[len(slots)-excess:] {
+			moves = append(moves, move{slot: slot, source: node})
+		}
+	}
+	moved := 0
+	for _, node := range nodes {
+		for counts[node] < expected[node] && moved < len(moves) {
+			m := moves[moved]
+			err := cluster.moveSlot(m.slot, m.source, node)
+			if err != nil {
+				return reply.MakeErrReply("ERR move slot " + strconv.Itoa(int(m.slot)) + " to " + node.Addr +
+					" failed after moving " + strconv.Itoa(moved) + " slots: " + err.Error())
+			}
+			counts[node]++
+			moved++
+		}
+	}
+	logger.Info("rebalance finished, moved " + strconv.Itoa(moved) + " slots")
+	return reply.MakeIntReply(int64(moved))
+}
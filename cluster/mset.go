@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+)
+
+// MGet gets values of multi keys in cluster, each node receives one MGET with its keys,
+// replies are reassembled in the order of given keys
+func MGet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("mget")
+	}
+	groups := cluster.groupByPeer(args[1:])
+	if len(groups) == 1 {
+		for peer := range groups {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for peer, keys := range groups {
+		cmdLines[peer] = utils.ToCmdLine2("mget", keys...)
+	}
+	replies := cluster.relayGroups(c, cmdLines)
+	values := make(map[string][]byte, len(args)-1)
+	for peer, r := range replies {
+		if reply.IsErrorReply(r) {
+			return r
+		}
+		multiBulk, ok := r.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(groups[peer]) {
+			return reply.MakeErrReply("ERR unexpected reply of mget from " + peer)
+		}
+		for i, key := range groups[peer] {
+			values[string(key)] = multiBulk.Args[i]
+		}
+	}
+	result := make([][]byte, len(args)-1)
+	for i, key := range args[1:] {
+		result[i] = values[string(key)]
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// groupMSetArgs groups key-value pairs of MSET/MSETNX by node, and makes the command for each node
+func (cluster *ClusterDatabase) groupMSetArgs(cmdName string, args [][]byte) map[string]CmdLine {
+	cmdLines := make(map[string]CmdLine)
+	for i := 1; i+1 < len(args); i += 2 {
+		peer := cluster.pickNode(string(args[i]))
+		if _, ok := cmdLines[peer]; !ok {
+			cmdLines[peer] = CmdLine{[]byte(cmdName)}
+		}
+		cmdLines[peer] = append(cmdLines[peer], args[i], args[i+1])
+	}
+	return cmdLines
+}
+
+// MSet sets multi key-value pairs in cluster, pairs on different nodes are set by a distributed transaction
+func MSet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	cmdLines := cluster.groupMSetArgs("mset", args)
+	if len(cmdLines) == 1 {
+		for peer := range cmdLines {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	_, errReply := cluster.execTx(c, cmdLines)
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeOkReply()
+}
+
+// MSetNX sets multi key-value pairs in cluster only if none of the keys exist on any node
+func MSetNX(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	cmdLines := cluster.groupMSetArgs("msetnx", args)
+	if len(cmdLines) == 1 {
+		for peer := range cmdLines {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	// participants refuse to prepare if any of their keys exists, so no key is set
+	_, errReply := cluster.execTx(c, cmdLines)
+	if errReply != nil {
+		if errReply.Error() == errKeyExists {
+			return reply.MakeIntReply(0)
+		}
+		return errReply
+	}
+	return reply.MakeIntReply(1)
+}
@@ -0,0 +1,357 @@
+package cluster
+
+import (
+	"errors"
+	"go_redis_write/lib/logger"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//CLUSTER NODES 的输出、gossip消息和nodes.conf使用相同的格式，每个节点一行:
+//<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ...
+//nodes.conf中还会保存迁移中的槽 [slot->-target-id] 和 [slot-<-source-id]，节点重启后用它恢复自己的ID、槽和其他节点
+//nodes.conf最后一行是 vars currentEpoch <epoch> lastVoteEpoch <epoch>
+
+// nodeInfo is a parsed line of CLUSTER NODES
+type nodeInfo struct {
+	id          string
+	addr        string
+	busPort     int
+	flags       []string
+	master      string // id of master, "-" for master
+	configEpoch uint64
+	slots       [][2]uint32       // [start, end]
+	migrating   map[uint32]string // slot -> target id
+	importing   map[uint32]string // slot -> source id
+}
+
+// hasFlag tells whether the node has the given flag
+func (info *nodeInfo) hasFlag(flag string) bool {
+	for _, f := range info.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNodeLine parses a line of CLUSTER NODES
+func parseNodeLine(line string) (*nodeInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, errors.New("invalid line of cluster nodes: " + line)
+	}
+	configEpoch, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid config epoch " + fields[6])
+	}
+	info := &nodeInfo{
+		id:          fields[0],
+		addr:        fields[1],
+		flags:       strings.Split(fields[2], ","),
+		master:      fields[3],
+		configEpoch: configEpoch,
+		migrating:   make(map[uint32]string),
+		importing:   make(map[uint32]string),
+	}
+	if i := strings.IndexByte(info.addr, '@'); i >= 0 {
+		busPort, err := strconv.Atoi(info.addr[i+1:])
+		if err != nil {
+			return nil, errors.New("invalid address " + info.addr)
+		}
+		info.addr, info.busPort = info.addr[:i], busPort
+	}
+	for _, field := range fields[8:] {
+		if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			field = field[1 : len(field)-1]
+			if i := strings.Index(field, "->-"); i >= 0 {
+				if slot, ok := parseSlot([]byte(field[:i])); ok {
+					info.migrating[slot] = field[i+3:]
+				}
+			} else if i := strings.Index(field, "-<-"); i >= 0 {
+				if slot, ok := parseSlot([]byte(field[:i])); ok {
+					info.importing[slot] = field[i+3:]
+				}
+			}
+			continue
+		}
+		start, end, err := parseSlotRange(field)
+		if err != nil {
+			return nil, err
+		}
+		info.slots = append(info.slots, [2]uint32{start, end})
+	}
+	return info, nil
+}
+
+// parseSlotRange parses "start-end" or a single slot
+func parseSlotRange(s string) (uint32, uint32, error) {
+	startStr, endStr := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		startStr, endStr = s[:i], s[i+1:]
+	}
+	start, err1 := strconv.ParseUint(startStr, 10, 32)
+	end, err2 := strconv.ParseUint(endStr, 10, 32)
+	if err1 != nil || err2 != nil || start > end || end >= slotCount {
+		return 0, 0, errors.New("invalid slot range " + s)
+	}
+	return uint32(start), uint32(end), nil
+}
+
+// formatTime returns unix milliseconds, or 0 for zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// formatNodeLine formats a line of CLUSTER NODES without lock, slots are appended to the line
+func (t *topology) formatNodeLine(node *Node, slots []string) string {
+	flags := "master"
+	master := "-"
+	if node.master != nil {
+		flags = "slave"
+		master = node.master.ID
+	}
+	if node == t.self {
+		flags = "myself," + flags
+	}
+	if node.flags&flagFail > 0 {
+		flags += ",fail"
+	} else if node.flags&flagPFail > 0 {
+		flags += ",fail?"
+	}
+	linkState := "disconnected"
+	if node == t.self || node.linkUp {
+		linkState = "connected"
+	}
+	fields := []string{
+		node.ID,
+		node.Addr + "@" + strconv.Itoa(node.BusPort),
+		flags,
+		master,
+		formatTime(node.pingSent),
+		formatTime(node.pongRecv),
+		strconv.FormatUint(node.configEpoch, 10),
+		linkState,
+	}
+	fields = append(fields, slots...)
+	return strings.Join(fields, " ")
+}
+
+// nodeSlots returns slot ranges of every node like "0-100" without lock, migrating and importing slots are shown for self
+func (t *topology) nodeSlots() map[*Node][]string {
+	nodeSlots := make(map[*Node][]string)
+	for _, r := range t.slotRanges0() {
+		slot := strconv.Itoa(int(r.start))
+		if r.end != r.start {
+			slot += "-" + strconv.Itoa(int(r.end))
+		}
+		nodeSlots[r.node] = append(nodeSlots[r.node], slot)
+	}
+	for slot, node := range t.migrating {
+		nodeSlots[t.self] = append(nodeSlots[t.self], "["+strconv.Itoa(int(slot))+"->-"+node.ID+"]")
+	}
+	for slot, node := range t.importing {
+		nodeSlots[t.self] = append(nodeSlots[t.self], "["+strconv.Itoa(int(slot))+"-<-"+node.ID+"]")
+	}
+	return nodeSlots
+}
+
+// formatNodes returns all nodes in the format of CLUSTER NODES
+func (t *topology) formatNodes() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	nodeSlots := t.nodeSlots()
+	var builder strings.Builder
+	for _, node := range t.sortedNodes0() {
+		builder.WriteString(t.formatNodeLine(node, nodeSlots[node]))
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// formatVars returns the last line of nodes.conf without lock
+func (t *topology) formatVars() string {
+	return "vars currentEpoch " + strconv.FormatUint(t.currentEpoch, 10) +
+		" lastVoteEpoch " + strconv.FormatUint(t.lastVoteEpoch, 10) + "\n"
+}
+
+// buildNodes creates nodes and slots from parsed lines, it returns the greatest config epoch too
+func buildNodes(infos []*nodeInfo) (map[string]*Node, *[slotCount]*Node, uint64) {
+	nodes := make(map[string]*Node)
+	slots := new([slotCount]*Node)
+	var maxEpoch uint64
+	for _, info := range infos {
+		node := newNode(info.id, info.addr, info.busPort)
+		node.configEpoch = info.configEpoch
+		if info.hasFlag("fail") {
+			node.flags |= flagFail
+		}
+		if info.configEpoch > maxEpoch {
+			maxEpoch = info.configEpoch
+		}
+		nodes[node.ID] = node
+		for _, r := range info.slots {
+			for slot := r[0]; slot <= r[1]; slot++ {
+				slots[slot] = node
+			}
+		}
+	}
+	for _, info := range infos {
+		if master, ok := nodes[info.master]; ok && info.master != info.id {
+			nodes[info.id].master = master
+		}
+	}
+	return nodes, slots, maxEpoch
+}
+
+// parseNodes parses output of CLUSTER NODES or nodes.conf, lines which are not nodes like "vars" are skipped
+func parseNodes(text string) ([]*nodeInfo, error) {
+	infos := make([]*nodeInfo, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "vars ") {
+			continue
+		}
+		info, err := parseNodeLine(line)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// loadNodes replaces nodes and slots with the output of CLUSTER NODES of another node,
+// this node is added without slot if it is not in the output
+func (t *topology) loadNodes(text string) error {
+	infos, err := parseNodes(text)
+	if err != nil {
+		return err
+	}
+	nodes, slots, maxEpoch := buildNodes(infos)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if self, ok := nodes[t.self.ID]; ok {
+		self.flags = 0
+		self.BusPort = t.self.BusPort
+		t.self = self
+	} else {
+		t.self.configEpoch = 0
+		nodes[t.self.ID] = t.self
+	}
+	t.nodes = nodes
+	t.slots = *slots
+	t.currentEpoch = maxEpoch
+	t.migrating = make(map[uint32]*Node)
+	t.importing = make(map[uint32]*Node)
+	t.dirty = true
+	return nil
+}
+
+// loadTopology creates topology from nodes.conf, self is the node flagged as myself
+func loadTopology(text string) (*topology, error) {
+	infos, err := parseNodes(text)
+	if err != nil {
+		return nil, err
+	}
+	nodes, slots, maxEpoch := buildNodes(infos)
+	t := &topology{
+		nodes:        nodes,
+		slots:        *slots,
+		migrating:    make(map[uint32]*Node),
+		importing:    make(map[uint32]*Node),
+		blacklist:    make(map[string]time.Time),
+		currentEpoch: maxEpoch,
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "vars" {
+			continue
+		}
+		for i := 1; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, errors.New("invalid vars: " + line)
+			}
+			switch fields[i] {
+			case "currentEpoch":
+				if value > t.currentEpoch {
+					t.currentEpoch = value
+				}
+			case "lastVoteEpoch":
+				t.lastVoteEpoch = value
+			}
+		}
+	}
+	for _, info := range infos {
+		if !info.hasFlag("myself") {
+			continue
+		}
+		t.self = nodes[info.id]
+		for slot, id := range info.migrating {
+			if node := nodes[id]; node != nil {
+				t.migrating[slot] = node
+			}
+		}
+		for slot, id := range info.importing {
+			if node := nodes[id]; node != nil {
+				t.importing[slot] = node
+			}
+		}
+	}
+	if t.self == nil {
+		return nil, errors.New("myself not found")
+	}
+	t.self.flags = 0
+	return t, nil
+}
+
+// loadConfig loads topology from cluster-config-file, it returns nil if the file doesn't exist
+func (cluster *ClusterDatabase) loadConfig() *topology {
+	filename := cluster.configFile
+	if filename == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("read " + filename + " failed: " + err.Error())
+		}
+		return nil
+	}
+	t, err := loadTopology(string(content))
+	if err != nil {
+		logger.Warn("load " + filename + " failed: " + err.Error())
+		return nil
+	}
+	return t
+}
+
+// saveConfig writes nodes to cluster-config-file, the file is replaced atomically
+func (cluster *ClusterDatabase) saveConfig() {
+	filename := cluster.configFile
+	if filename == "" {
+		return
+	}
+	cluster.configMu.Lock()
+	defer cluster.configMu.Unlock()
+	tmpFile := filename + ".tmp"
+	t := cluster.topology
+	content := t.formatNodes()
+	t.mu.RLock()
+	content += t.formatVars()
+	t.mu.RUnlock()
+	err := ioutil.WriteFile(tmpFile, []byte(content), 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, filename)
+	}
+	if err != nil {
+		logger.Warn("save " + filename + " failed: " + err.Error())
+	}
+}
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/resp/reply"
+)
+
+// 订阅关系只保存在客户端连接的节点上，发布时广播给所有节点，由各节点推送给本地的订阅者
+// 转发给其他节点时使用内部命令 _publish，避免其他节点再次广播
+const relayPublish = "_publish"
+
+// Publish broadcasts the message to all nodes, returns the number of clients received the message
+func Publish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	relayArgs := make([][]byte, len(args))
+	copy(relayArgs, args)
+	relayArgs[0] = []byte(relayPublish)
+	// subscribers on failed nodes miss the message, like subscribers disconnected in standalone mode
+	replies, errs := cluster.fanOut(cluster.nodeAddrs(), bestEffort, func(node string) resp.Reply {
+		if node == cluster.self {
+			return cluster.db.Exec(c, args)
+		}
+		return cluster.relay(node, c, relayArgs)
+	})
+	if errs != nil {
+		logger.Warn("publish " + errs.Error())
+	}
+	var count int64 = 0
+	for node, v := range replies {
+		intReply, ok := v.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply of publish from " + node)
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// onRelayedPublish delivers the message relayed by other node to local subscribers
+func onRelayedPublish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	localArgs := make([][]byte, len(args))
+	copy(localArgs, args)
+	localArgs[0] = []byte("publish")
+	return cluster.db.Exec(c, localArgs)
+}
+
+// execLocal executes the command on current node, used by commands bound to the client connection
+func execLocal(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.db.Exec(c, args)
+}
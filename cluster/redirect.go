@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"go_redis_write/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"strconv"
+)
+
+//重定向模式: 节点不再转发请求，而是告诉cluster-aware客户端key在哪个节点上
+//-MOVED slot host:port  槽属于其他节点，客户端应该更新槽表并重新请求
+//-ASK slot host:port    槽正在迁移且key已经不在本节点，客户端应该向目标节点先发送ASKING再发送这条命令
+//代理模式下迁移中的槽也按同样的规则处理，只是由节点代替客户端转发给目标节点
+
+// routeBySlot handles commands whose keys are in one slot before their handlers, when the slot is not served by this node
+// or is migrating. It returns false if the handler should execute the command as usual
+func (cluster *ClusterDatabase) routeBySlot(c resp.Connection, cmdLine [][]byte, asking bool) (resp.Reply, bool) {
+	writeKeys, readKeys, err := database.GetRelatedKeys(cmdLine)
+	if err != nil {
+		return nil, false // unknown or invalid command, let its handler report the error
+	}
+	keys := append(writeKeys, readKeys...)
+	if len(keys) == 0 {
+		return nil, false
+	}
+	slot := getSlot(keys[0])
+	for _, key := range keys[1:] {
+		if getSlot(key) != slot {
+			if cluster.redirectMode {
+				return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot"), true
+			}
+			return nil, false // proxy mode relays keys to their own nodes
+		}
+	}
+	node := cluster.topology.pickNode(slot)
+	if node != cluster.topology.self {
+		if asking { // the client was told by the owner that keys have been moved here
+			if cluster.topology.importingFrom(slot) != nil {
+				return cluster.db.Exec(c, cmdLine), true
+			}
+			if node != nil {
+				return makeMovedReply(slot, node.Addr), true // never relay an asked command back
+			}
+		}
+		if node == nil {
+			return reply.MakeErrReply("CLUSTERDOWN Hash slot not served"), true
+		}
+		if cluster.redirectMode {
+			return makeMovedReply(slot, node.Addr), true
+		}
+		return nil, false
+	}
+	target := cluster.topology.migratingTo(slot)
+	if target == nil {
+		return nil, false
+	}
+	// keys already moved to target are served by target
+	existing := cluster.countExisting(c, keys)
+	if existing == len(keys) {
+		return nil, false
+	}
+	if existing > 0 {
+		return reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot"), true
+	}
+	if cluster.redirectMode {
+		return makeAskReply(slot, target.Addr), true
+	}
+	return cluster.relayAsking(target.Addr, c, cmdLine), true
+}
+
+// countExisting returns the number of keys existing in local database, duplicated keys are counted repeatedly
+func (cluster *ClusterDatabase) countExisting(c resp.Connection, keys []string) int {
+	result := cluster.db.Exec(c, utils.ToCmdLine(append([]string{"exists"}, keys...)...))
+	intReply, ok := result.(*reply.IntReply)
+	if !ok {
+		return 0
+	}
+	return int(intReply.Code)
+}
+
+func makeMovedReply(slot uint32, addr string) resp.Reply {
+	return reply.MakeErrReply("MOVED " + strconv.Itoa(int(slot)) + " " + addr)
+}
+
+func makeAskReply(slot uint32, addr string) resp.Reply {
+	return reply.MakeErrReply("ASK " + strconv.Itoa(int(slot)) + " " + addr)
+}
+
+// execAsking handles ASKING, the next command of this connection can access a slot being imported
+func execAsking(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("asking")
+	}
+	c.SetAsking(true)
+	return reply.MakeOkReply()
+}
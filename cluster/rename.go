@@ -2,22 +2,59 @@ package cluster
 
 import (
 	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
 	"go_redis_write/resp/reply"
+	"strings"
 )
 
-// Rename renames a key, the origin and the destination must within the same node
+// Rename renames a key, if the origin and the destination are on different nodes,
+// the value is moved by a distributed transaction: source node removes it and destination node restores it
 func Rename(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
 	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rename' command")
+		return reply.MakeArgNumErrReply(cmdName)
 	}
+	isNx := cmdName == "renamenx"
 	src := string(args[1])
 	dest := string(args[2])
 
-	srcPeer := cluster.peerPicker.PickNode(src)
-	destPeer := cluster.peerPicker.PickNode(dest)
+	srcPeer := cluster.pickNode(src)
+	destPeer := cluster.pickNode(dest)
+	if srcPeer == destPeer {
+		return cluster.relay(srcPeer, c, args)
+	}
 
-	if srcPeer != destPeer {
-		return reply.MakeErrReply("ERR rename must within one slot in cluster mode")
+	txID := cluster.newTxID()
+	peers := []string{srcPeer, destPeer}
+	srcReply := cluster.requestPrepare(c, txID, srcPeer, utils.ToCmdLine("renamefrom", src))
+	if reply.IsErrorReply(srcReply) {
+		cluster.requestRollback(c, txID, peers)
+		return srcReply
+	}
+	dumped, ok := srcReply.(*reply.MultiBulkReply)
+	if !ok || len(dumped.Args) != 2 {
+		cluster.requestRollback(c, txID, peers)
+		return reply.MakeErrReply("ERR unexpected reply of renamefrom: " + string(srcReply.ToBytes()))
+	}
+	destCmd := "renameto"
+	if isNx {
+		destCmd = "renamenxto"
+	}
+	// dumped.Args are expire time and value of src
+	destReply := cluster.requestPrepare(c, txID, destPeer, utils.ToCmdLine2(destCmd, args[2], dumped.Args[0], dumped.Args[1]))
+	if errReply, ok := destReply.(reply.ErrorReply); ok {
+		cluster.requestRollback(c, txID, peers)
+		if isNx && errReply.Error() == errKeyExists {
+			return reply.MakeIntReply(0)
+		}
+		return destReply
+	}
+	_, errReply := cluster.requestCommit(c, txID, peers)
+	if errReply != nil {
+		return errReply
+	}
+	if isNx {
+		return reply.MakeIntReply(1)
 	}
-	return cluster.relay(srcPeer, c, args)
+	return reply.MakeOkReply()
 }
@@ -8,20 +8,139 @@ type CmdLine = [][]byte
 func makeRouter() map[string]CmdFunc {
 	routerMap := make(map[string]CmdFunc)
 	routerMap["ping"] = ping
+	routerMap["select"] = execSelect
+	routerMap["cluster"] = execCluster
+	routerMap["asking"] = execAsking
+	routerMap["migrate"] = Migrate
+
+	routerMap["prepare"] = execPrepare
+	routerMap["commit"] = execCommit
+	routerMap["rollback"] = execRollback
 
 	routerMap["del"] = Del
 
-	routerMap["exists"] = defaultFunc
+	routerMap["exists"] = Exists
+	routerMap["keys"] = Keys
+	routerMap["scan"] = Scan
+	routerMap["dbsize"] = DBSize
+	routerMap["randomkey"] = RandomKey
 	routerMap["type"] = defaultFunc
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = Rename
+	routerMap["dump"] = defaultFunc
+	routerMap["restore"] = defaultFunc
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["persist"] = defaultFunc
 
 	routerMap["set"] = defaultFunc
+	routerMap["mset"] = MSet
+	routerMap["msetnx"] = MSetNX
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
+	routerMap["mget"] = MGet
 	routerMap["getset"] = defaultFunc
 
+	routerMap["lpush"] = defaultFunc
+	routerMap["lpushx"] = defaultFunc
+	routerMap["rpush"] = defaultFunc
+	routerMap["rpushx"] = defaultFunc
+	routerMap["lpop"] = defaultFunc
+	routerMap["rpop"] = defaultFunc
+	routerMap["llen"] = defaultFunc
+	routerMap["lindex"] = defaultFunc
+	routerMap["lrange"] = defaultFunc
+	routerMap["lrem"] = defaultFunc
+	routerMap["lset"] = defaultFunc
+	routerMap["ltrim"] = defaultFunc
+	routerMap["linsert"] = defaultFunc
+
+	routerMap["hset"] = defaultFunc
+	routerMap["hmset"] = defaultFunc
+	routerMap["hsetnx"] = defaultFunc
+	routerMap["hget"] = defaultFunc
+	routerMap["hmget"] = defaultFunc
+	routerMap["hexists"] = defaultFunc
+	routerMap["hdel"] = defaultFunc
+	routerMap["hlen"] = defaultFunc
+	routerMap["hstrlen"] = defaultFunc
+	routerMap["hkeys"] = defaultFunc
+	routerMap["hvals"] = defaultFunc
+	routerMap["hgetall"] = defaultFunc
+	routerMap["hincrby"] = defaultFunc
+	routerMap["hincrbyfloat"] = defaultFunc
+	routerMap["hscan"] = defaultFunc
+	routerMap["hrandfield"] = defaultFunc
+
+	routerMap["sadd"] = defaultFunc
+	routerMap["sismember"] = defaultFunc
+	routerMap["smismember"] = defaultFunc
+	routerMap["srem"] = defaultFunc
+	routerMap["spop"] = defaultFunc
+	routerMap["scard"] = defaultFunc
+	routerMap["smembers"] = defaultFunc
+	routerMap["srandmember"] = defaultFunc
+	routerMap["smove"] = SMove
+	routerMap["sinter"] = setMultiKeyFunc
+	routerMap["sinterstore"] = setMultiKeyFunc
+	routerMap["sunion"] = setMultiKeyFunc
+	routerMap["sunionstore"] = setMultiKeyFunc
+	routerMap["sdiff"] = setMultiKeyFunc
+	routerMap["sdiffstore"] = setMultiKeyFunc
+	routerMap["sintercard"] = SInterCard
+
+	routerMap["zadd"] = defaultFunc
+	routerMap["zscore"] = defaultFunc
+	routerMap["zincrby"] = defaultFunc
+	routerMap["zcard"] = defaultFunc
+	routerMap["zrem"] = defaultFunc
+	routerMap["zrank"] = defaultFunc
+	routerMap["zrevrank"] = defaultFunc
+	routerMap["zcount"] = defaultFunc
+	routerMap["zlexcount"] = defaultFunc
+	routerMap["zrange"] = defaultFunc
+	routerMap["zrevrange"] = defaultFunc
+	routerMap["zrangebyscore"] = defaultFunc
+	routerMap["zrevrangebyscore"] = defaultFunc
+	routerMap["zrangebylex"] = defaultFunc
+	routerMap["zrevrangebylex"] = defaultFunc
+	routerMap["zpopmin"] = defaultFunc
+	routerMap["zpopmax"] = defaultFunc
+	routerMap["zremrangebyscore"] = defaultFunc
+	routerMap["zremrangebylex"] = defaultFunc
+	routerMap["zremrangebyrank"] = defaultFunc
+	routerMap["zunionstore"] = ZStore
+	routerMap["zinterstore"] = ZStore
+
 	routerMap["flushdb"] = FlushDB
+	routerMap["flushall"] = FlushDB
+	for _, cmd := range []string{"keys", "scan", "dbsize", "randomkey", "flushdb", "flushall"} {
+		routerMap[relayLocalPrefix+cmd] = execRelayedLocal // relayed by broadcast
+	}
+	routerMap["bgrewriteaof"] = execLocal
+	routerMap["save"] = execLocal
+	routerMap["bgsave"] = execLocal
+	routerMap["lastsave"] = execLocal
+
+	routerMap["publish"] = Publish
+	routerMap[relayPublish] = onRelayedPublish
+	routerMap["subscribe"] = execLocal
+	routerMap["unsubscribe"] = execLocal
+	routerMap["psubscribe"] = execLocal
+	routerMap["punsubscribe"] = execLocal
+	routerMap["pubsub"] = execLocal
+
+	routerMap["replicaof"] = execLocal
+	routerMap["slaveof"] = execLocal
+	routerMap["psync"] = execLocal
+	routerMap["sync"] = execLocal
+	routerMap["replconf"] = execLocal
+	routerMap["role"] = execLocal
+	routerMap["info"] = execInfo
 
 	return routerMap
 }
@@ -31,6 +150,6 @@ func makeRouter() map[string]CmdFunc {
 // relay command to responsible peer, and return its reply to client
 func defaultFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
 	key := string(args[1])
-	peer := cluster.peerPicker.PickNode(key)
+	peer := cluster.pickNode(key)
 	return cluster.relay(peer, c, args)
 }
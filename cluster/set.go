@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// relayMultiKey relays a command with multiple keys to the node which holds all these keys,
+// keys distributed on different nodes are not supported yet
+func relayMultiKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte, keys [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	peer := ""
+	for _, key := range keys {
+		keyPeer := cluster.pickNode(string(key))
+		if peer != "" && keyPeer != peer {
+			return reply.MakeErrReply("ERR keys of '" + cmdName + "' must within one slot in cluster mode")
+		}
+		peer = keyPeer
+	}
+	return cluster.relay(peer, c, args)
+}
+
+// setMultiKeyFunc handles SINTER, SUNION, SDIFF and their STORE variants, all arguments are keys
+func setMultiKeyFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(string(args[0]))
+	}
+	return relayMultiKey(cluster, c, args, args[1:])
+}
+
+// SMove moves a member from one set to another, both sets must within the same node
+func SMove(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 4 {
+		return reply.MakeArgNumErrReply("smove")
+	}
+	return relayMultiKey(cluster, c, args, args[1:3])
+}
+
+// SInterCard SINTERCARD numkeys key [key ...] [LIMIT limit]
+func SInterCard(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("sintercard")
+	}
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if len(args) < numKeys+2 {
+		return reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	return relayMultiKey(cluster, c, args, args[2:numKeys+2])
+}
@@ -0,0 +1,468 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//和redis cluster一样使用16384个哈希槽，key所在的槽是CRC16(key) mod 16384
+//如果key中有{hashtag}，只对hashtag计算，这样可以把相关的key放到同一个槽里
+//每个节点负责一部分槽，启动时按节点地址排序后平均分配，所有节点根据相同的配置得到相同的槽分配
+
+const (
+	// slotCount is the number of hash slots in cluster
+	slotCount = 16384
+)
+
+var crc16Table = makeCrc16Table()
+
+// makeCrc16Table makes table of CRC16-CCITT (XMODEM), which is used by redis cluster
+func makeCrc16Table() *[256]uint16 {
+	table := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// getHashTag returns the content of the first {...} in key if it is not empty, otherwise returns the whole key
+func getHashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 { // no '}' or empty tag
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// getSlot returns the hash slot of key
+func getSlot(key string) uint32 {
+	return uint32(crc16(getHashTag(key))) % slotCount
+}
+
+// Node is a node of cluster, a replica serves no slot and takes slots of its master by failover
+type Node struct {
+	ID      string // 40 hex characters like redis
+	Addr    string
+	BusPort int // port of cluster bus, see gossip.go
+
+	// fields below are guarded by topology.mu
+	master      *Node     // nil if the node is a master
+	configEpoch uint64    // the node with greater config epoch wins when nodes claim the same slot
+	votedTime   time.Time // time of the last vote for replicas of this node
+	flags       uint8
+	pingSent    time.Time // time of the first ping not answered yet, zero if all pings are answered
+	lastPing    time.Time // time of the latest ping
+	pinging     bool      // a ping is waiting for pong
+	pongRecv    time.Time
+	linkUp      bool
+	failReports map[string]time.Time // id of master reporting the node as PFAIL or FAIL -> time of the report
+}
+
+const (
+	// flagPFail means this node can't reach the node in cluster-node-timeout
+	flagPFail uint8 = 1 << iota
+	// flagFail means majority of masters can't reach the node
+	flagFail
+)
+
+// newNode creates a node, the bus port is port+10000 if busPort is 0
+func newNode(id string, addr string, busPort int) *Node {
+	if busPort == 0 {
+		_, port := splitAddr(addr)
+		busPort = port + 10000
+	}
+	return &Node{
+		ID:          id,
+		Addr:        addr,
+		BusPort:     busPort,
+		failReports: make(map[string]time.Time),
+	}
+}
+
+// busAddr returns address of cluster bus of the node
+func (node *Node) busAddr() string {
+	host, _ := splitAddr(node.Addr)
+	return net.JoinHostPort(host, strconv.Itoa(node.BusPort))
+}
+
+// makeNodeID derives node id from address, so every node knows ids of others without handshake
+func makeNodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// slotRange is a range of continuous slots served by the same node, both ends included
+type slotRange struct {
+	start uint32
+	end   uint32
+	node  *Node
+}
+
+// topology stores the nodes of cluster and which node serves each slot
+type topology struct {
+	mu    sync.RWMutex
+	self  *Node
+	nodes map[string]*Node // node id -> node
+	slots [slotCount]*Node // slot -> node serving it
+	// slots being moved from this node to another node, slot -> target
+	migrating map[uint32]*Node
+	// slots being moved from another node to this node, slot -> source
+	importing map[uint32]*Node
+	// nodes removed by CLUSTER FORGET are not added by gossip before expiration, node id -> expiration
+	blacklist map[string]time.Time
+	// nodes or slots changed since nodes.conf was saved
+	dirty bool
+	// the greatest epoch known by this node, a replica increases it to start an election
+	currentEpoch uint64
+	// the epoch this node voted in, a master votes at most once in an epoch
+	lastVoteEpoch uint64
+}
+
+// makeTopology creates topology of the given nodes, slots are evenly assigned to nodes sorted by address,
+// every node has a different config epoch
+func makeTopology(self string, addrs []string) *topology {
+	t := &topology{
+		nodes:     make(map[string]*Node),
+		migrating: make(map[uint32]*Node),
+		importing: make(map[uint32]*Node),
+		blacklist: make(map[string]time.Time),
+	}
+	sorted := make([]string, len(addrs))
+	copy(sorted, addrs)
+	sort.Strings(sorted)
+	for i, addr := range sorted {
+		node := newNode(makeNodeID(addr), addr, 0)
+		node.configEpoch = uint64(i + 1)
+		t.nodes[node.ID] = node
+		if addr == self {
+			t.self = node
+		}
+		start := i * slotCount / len(sorted)
+		end := (i + 1) * slotCount / len(sorted)
+		for slot := start; slot < end; slot++ {
+			t.slots[slot] = node
+		}
+	}
+	t.currentEpoch = uint64(len(sorted))
+	return t
+}
+
+// pickNode returns the node serving the slot
+func (t *topology) pickNode(slot uint32) *Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slots[slot]
+}
+
+// migratingTo returns the target node if the slot is migrating, or nil
+func (t *topology) migratingTo(slot uint32) *Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.migrating[slot]
+}
+
+// importingFrom returns the source node if the slot is importing, or nil
+func (t *topology) importingFrom(slot uint32) *Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.importing[slot]
+}
+
+// slotRanges returns continuous slot ranges with their nodes, in slot order
+func (t *topology) slotRanges() []*slotRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slotRanges0()
+}
+
+// slotRanges0 is slotRanges without lock
+func (t *topology) slotRanges0() []*slotRange {
+	ranges := make([]*slotRange, 0)
+	var cur *slotRange
+	for slot := uint32(0); slot < slotCount; slot++ {
+		node := t.slots[slot]
+		if cur != nil && cur.node == node && cur.end+1 == slot {
+			cur.end = slot
+			continue
+		}
+		if node == nil {
+			cur = nil
+			continue
+		}
+		cur = &slotRange{start: slot, end: slot, node: node}
+		ranges = append(ranges, cur)
+	}
+	return ranges
+}
+
+// sortedNodes returns all nodes sorted by address
+func (t *topology) sortedNodes() []*Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sortedNodes0()
+}
+
+// sortedNodes0 is sortedNodes without lock
+func (t *topology) sortedNodes0() []*Node {
+	nodes := make([]*Node, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Addr < nodes[j].Addr
+	})
+	return nodes
+}
+
+// masters returns master nodes sorted by address
+func (t *topology) masters() []*Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	masters := make([]*Node, 0, len(t.nodes))
+	for _, node := range t.sortedNodes0() {
+		if node.master == nil {
+			masters = append(masters, node)
+		}
+	}
+	return masters
+}
+
+// replicasOf returns replicas of the node sorted by address
+func (t *topology) replicasOf(master *Node) []*Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	replicas := make([]*Node, 0)
+	for _, node := range t.sortedNodes0() {
+		if node.master == master {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// mastersWithSlots returns the number of masters serving slots without lock, majority of them is needed to mark FAIL or elect
+func (t *topology) mastersWithSlots() int {
+	masters := make(map[*Node]struct{})
+	for _, owner := range t.slots {
+		if owner != nil {
+			masters[owner] = struct{}{}
+		}
+	}
+	return len(masters)
+}
+
+// hasSlots tells whether the node serves any slot without lock
+func (t *topology) hasSlots(node *Node) bool {
+	for _, owner := range t.slots {
+		if owner == node {
+			return true
+		}
+	}
+	return false
+}
+
+// bumpEpoch makes config epoch of this node the greatest without election if it is not, so that other nodes
+// accept slots taken by this node without failover, e.g. by CLUSTER SETSLOT NODE
+func (t *topology) bumpEpoch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, node := range t.nodes {
+		if node != t.self && node.configEpoch >= t.self.configEpoch {
+			t.currentEpoch++
+			t.self.configEpoch = t.currentEpoch
+			t.dirty = true
+			return
+		}
+	}
+}
+
+// addNode adds a node serving no slot, it returns the existing node if the address is known
+func (t *topology) addNode(addr string, busPort int) *Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, node := range t.nodes {
+		if node.Addr == addr {
+			return node
+		}
+	}
+	node := newNode(makeNodeID(addr), addr, busPort)
+	t.nodes[node.ID] = node
+	t.dirty = true
+	return node
+}
+
+// removeNode removes the node, its slots become not served and gossip can't add it back in a minute
+func (t *topology) removeNode(node *Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.nodes, node.ID)
+	for slot, owner := range t.slots {
+		if owner == node {
+			t.slots[slot] = nil
+		}
+	}
+	for slot, target := range t.migrating {
+		if target == node {
+			delete(t.migrating, slot)
+		}
+	}
+	for slot, source := range t.importing {
+		if source == node {
+			delete(t.importing, slot)
+		}
+	}
+	t.blacklist[node.ID] = time.Now().Add(forgetBlacklistTTL)
+	t.dirty = true
+}
+
+// isBlacklisted tells whether the node was forgotten recently, expired entries are removed
+func (t *topology) isBlacklisted(id string) bool {
+	expireAt, ok := t.blacklist[id]
+	if ok && time.Now().After(expireAt) {
+		delete(t.blacklist, id)
+		return false
+	}
+	return ok
+}
+
+// isFailed tells whether the node of address is marked as FAIL
+func (t *topology) isFailed(addr string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, node := range t.nodes {
+		if node.Addr == addr {
+			return node.flags&flagFail > 0
+		}
+	}
+	return false
+}
+
+// getNode returns node of the given id, or nil
+func (t *topology) getNode(id string) *Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodes[id]
+}
+
+// setSlot assigns the slot to node, and finishes its migration
+func (t *topology) setSlot(slot uint32, node *Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.slots[slot] = node
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+	t.dirty = true
+}
+
+// setMigrating marks the slot is moving from this node to target
+func (t *topology) setMigrating(slot uint32, target *Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.migrating[slot] = target
+	t.dirty = true
+}
+
+// setImporting marks the slot is moving from source to this node
+func (t *topology) setImporting(slot uint32, source *Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.importing[slot] = source
+	t.dirty = true
+}
+
+// setStable cancels migration of the slot
+func (t *topology) setStable(slot uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+	t.dirty = true
+}
+
+// slotsOf returns slots served by the node in order
+func (t *topology) slotsOf(node *Node) []uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	slots := make([]uint32, 0)
+	for slot, owner := range t.slots {
+		if owner == node {
+			slots = append(slots, uint32(slot))
+		}
+	}
+	return slots
+}
+
+// migrations returns copies of migrating and importing slots
+func (t *topology) migrations() (map[uint32]*Node, map[uint32]*Node) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	migrating := make(map[uint32]*Node, len(t.migrating))
+	for slot, node := range t.migrating {
+		migrating[slot] = node
+	}
+	importing := make(map[uint32]*Node, len(t.importing))
+	for slot, node := range t.importing {
+		importing[slot] = node
+	}
+	return migrating, importing
+}
+
+// slotCounts returns the number of slots served by each node, nodes serving no slot are included
+func (t *topology) slotCounts() map[*Node]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[*Node]int, len(t.nodes))
+	for _, node := range t.nodes {
+		counts[node] = 0
+	}
+	for _, node := range t.slots {
+		if node != nil {
+			counts[node]++
+		}
+	}
+	return counts
+}
+
+// parseSlot parses a slot number from argument
+func parseSlot(arg []byte) (uint32, bool) {
+	slot, err := strconv.ParseUint(string(arg), 10, 32)
+	if err != nil || slot >= slotCount {
+		return 0, false
+	}
+	return uint32(slot), true
+}
+
+// pickNode returns address of the node holding the key, or empty string if the slot is not served
+func (cluster *ClusterDatabase) pickNode(key string) string {
+	node := cluster.topology.pickNode(getSlot(key))
+	if node == nil {
+		return ""
+	}
+	return node.Addr
+}
@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	HashSet "go_redis_write/datastruct/set"
+	"sync"
+)
+
+//记录每个db里每个槽有哪些key，迁移槽时不用遍历整个db
+//本地数据库插入或删除key时(持有key的锁)通过回调更新，槽按slotIndexShards分段加锁
+
+const (
+	// slotIndexShards is the number of locks of slotIndex, slots are spread on them
+	slotIndexShards = 256
+)
+
+// slotIndex records keys of every slot in every db
+type slotIndex struct {
+	locks [slotIndexShards]sync.RWMutex
+	// dbIndex -> slot -> keys, nil set means no key
+	keys []*[slotCount]*HashSet.Set
+}
+
+// makeSlotIndex creates an empty index of dbCount dbs
+func makeSlotIndex(dbCount int) *slotIndex {
+	index := &slotIndex{
+		keys: make([]*[slotCount]*HashSet.Set, dbCount),
+	}
+	for i := range index.keys {
+		index.keys[i] = new([slotCount]*HashSet.Set)
+	}
+	return index
+}
+
+func (index *slotIndex) lock(slot uint32) *sync.RWMutex {
+	return &index.locks[slot%slotIndexShards]
+}
+
+// add is the insert callback of local database
+func (index *slotIndex) add(dbIndex int, key string) {
+	if dbIndex < 0 || dbIndex >= len(index.keys) {
+		return
+	}
+	slot := getSlot(key)
+	mu := index.lock(slot)
+	mu.Lock()
+	defer mu.Unlock()
+	set := index.keys[dbIndex][slot]
+	if set == nil {
+		set = HashSet.Make()
+		index.keys[dbIndex][slot] = set
+	}
+	set.Add(key)
+}
+
+// remove is the delete callback of local database
+func (index *slotIndex) remove(dbIndex int, key string) {
+	if dbIndex < 0 || dbIndex >= len(index.keys) {
+		return
+	}
+	slot := getSlot(key)
+	mu := index.lock(slot)
+	mu.Lock()
+	defer mu.Unlock()
+	set := index.keys[dbIndex][slot]
+	if set == nil {
+		return
+	}
+	set.Remove(key)
+	if set.Len() == 0 {
+		index.keys[dbIndex][slot] = nil
+	}
+}
+
+// getKeys returns at most count keys of the db in the slot
+func (index *slotIndex) getKeys(dbIndex int, slot uint32, count int) [][]byte {
+	keys := make([][]byte, 0)
+	if count <= 0 || dbIndex < 0 || dbIndex >= len(index.keys) {
+		return keys
+	}
+	mu := index.lock(slot)
+	mu.RLock()
+	defer mu.RUnlock()
+	set := index.keys[dbIndex][slot]
+	if set == nil {
+		return keys
+	}
+	set.ForEach(func(key string) bool {
+		keys = append(keys, []byte(key))
+		return len(keys) < count
+	})
+	return keys
+}
+
+// count returns the number of keys of the db in the slot
+func (index *slotIndex) count(dbIndex int, slot uint32) int {
+	if dbIndex < 0 || dbIndex >= len(index.keys) {
+		return 0
+	}
+	mu := index.lock(slot)
+	mu.RLock()
+	defer mu.RUnlock()
+	set := index.keys[dbIndex][slot]
+	if set == nil {
+		return 0
+	}
+	return set.Len()
+}
@@ -0,0 +1,26 @@
+package cluster
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/resp/reply"
+	"strconv"
+)
+
+// ZStore handles ZUNIONSTORE and ZINTERSTORE, dest and all source keys must within the same node
+// ZUNIONSTORE dest numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZStore(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 4 {
+		return reply.MakeArgNumErrReply(string(args[0]))
+	}
+	numKeys, err := strconv.Atoi(string(args[2]))
+	if err != nil || numKeys <= 0 {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if len(args) < numKeys+3 {
+		return &reply.SyntaxErrReply{}
+	}
+	keys := make([][]byte, 0, numKeys+1)
+	keys = append(keys, args[1])
+	keys = append(keys, args[3:numKeys+3]...)
+	return relayMultiKey(cluster, c, args, keys)
+}
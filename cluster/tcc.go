@@ -0,0 +1,423 @@
+package cluster
+
+import (
+	"go_redis_write/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//跨节点的分布式事务，使用两阶段提交(try-commit-catch)
+//协调者: 收到客户端命令的节点，把命令按节点拆分后向每个参与者发送prepare，全部成功后发送commit，
+//任何一个失败则向所有参与者发送rollback
+//参与者: prepare时锁住相关key，检查命令能否执行并记录undo日志；commit时在持有锁的情况下执行命令后释放锁；
+//rollback时释放锁，如果已经commit则执行undo日志恢复数据
+//PREPARE txID cmd args...
+//COMMIT txID
+//ROLLBACK txID
+
+const (
+	// maxLockTime is the max time a prepared transaction holds its locks, it is rolled back after timeout.
+	// it must be shorter than the request timeout of resp/client (3s), otherwise the coordinator may give up
+	// and roll back while the participant still holds the locks
+	maxLockTime = 2 * time.Second
+	// waitBeforeCleanTx is how long a finished transaction is kept, coordinator may roll back a committed transaction
+	waitBeforeCleanTx = 2 * maxLockTime
+)
+
+// status of transaction on participant
+const (
+	createdStatus = iota
+	preparedStatus
+	committedStatus
+	rolledBackStatus
+)
+
+// errors returned by prepare when the command should not be executed, coordinator treats them as normal result
+const (
+	errKeyExists = "ERR target key exists"
+	errNoSuchKey = "no such key" // same as rename of standalone database
+)
+
+// txCommand describes a command which could be executed in distributed transaction
+type txCommand struct {
+	arity int // same as arity of standalone database, negative means at least -arity arguments
+	// toCommit converts arguments of prepare into the command executed on commit, nil means executing the command itself
+	toCommit func(args [][]byte) CmdLine
+	// check validates the command after its keys are locked, and returns the reply of prepare.
+	// nil means always ok
+	check func(tx *Transaction) resp.Reply
+}
+
+// txCommands are the commands allowed in distributed transaction
+var txCommands = map[string]*txCommand{
+	"del": {
+		arity: -2,
+	},
+	"mset": {
+		arity: -3,
+	},
+	"msetnx": {
+		arity: -3,
+		check: checkKeysNotExist,
+	},
+	// renamefrom src: removes src on commit, prepare returns expire time and dumped value of src
+	"renamefrom": {
+		arity: 2,
+		toCommit: func(args [][]byte) CmdLine {
+			return utils.ToCmdLine2("del", args[1:]...)
+		},
+		check: dumpRenameSource,
+	},
+	// renameto dest expireAt payload: creates dest with the value of src
+	"renameto": {
+		arity:    4,
+		toCommit: restoreRenameDest,
+	},
+	// renamenxto dest expireAt payload: like renameto, but dest must not exist
+	"renamenxto": {
+		arity:    4,
+		toCommit: restoreRenameDest,
+		check:    checkKeysNotExist,
+	},
+}
+
+// Transaction stores the state of a distributed transaction on participant
+type Transaction struct {
+	id      string
+	cmdLine CmdLine // command executed on commit
+	check   func(tx *Transaction) resp.Reply
+	cluster *ClusterDatabase
+	dbIndex int
+
+	writeKeys  []string
+	readKeys   []string
+	keysLocked bool
+	undoLog    []CmdLine
+
+	status int8
+	// rolls back the transaction if coordinator doesn't commit in time
+	timer *time.Timer
+	mu    sync.Mutex
+}
+
+// NewTransaction creates a transaction on participant
+func NewTransaction(cluster *ClusterDatabase, dbIndex int, id string, cmdLine CmdLine, cmd *txCommand) *Transaction {
+	tx := &Transaction{
+		id:      id,
+		cmdLine: cmdLine,
+		check:   cmd.check,
+		cluster: cluster,
+		dbIndex: dbIndex,
+		status:  createdStatus,
+	}
+	if cmd.toCommit != nil {
+		tx.cmdLine = cmd.toCommit(cmdLine)
+	}
+	return tx
+}
+
+func (tx *Transaction) lockKeys() {
+	if !tx.keysLocked {
+		tx.cluster.db.RWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+		tx.keysLocked = true
+	}
+}
+
+func (tx *Transaction) unLockKeys() {
+	if tx.keysLocked {
+		tx.cluster.db.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+		tx.keysLocked = false
+	}
+}
+
+// prepare locks related keys, checks the command and records undo logs
+func (tx *Transaction) prepare() resp.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != createdStatus { // rolled back by coordinator before prepared
+		return reply.MakeErrReply("ERR transaction " + tx.id + " is finished")
+	}
+	writeKeys, readKeys, err := database.GetRelatedKeys(tx.cmdLine)
+	if err != nil {
+		tx.status = rolledBackStatus
+		return reply.MakeErrReply(err.Error())
+	}
+	tx.writeKeys = writeKeys
+	tx.readKeys = readKeys
+	tx.lockKeys()
+
+	var result resp.Reply = reply.MakeOkReply()
+	if tx.check != nil {
+		result = tx.check(tx)
+		if reply.IsErrorReply(result) {
+			tx.unLockKeys()
+			tx.status = rolledBackStatus
+			return result
+		}
+	}
+	tx.undoLog = tx.cluster.db.GetUndoLogs(tx.dbIndex, tx.cmdLine)
+	tx.status = preparedStatus
+	tx.timer = time.AfterFunc(maxLockTime, func() {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		if tx.status == preparedStatus {
+			logger.Warn("transaction " + tx.id + " is not committed in time, roll back")
+			tx.unLockKeys()
+			tx.status = rolledBackStatus
+			tx.cluster.transactions.Remove(tx.id)
+		}
+	})
+	return result
+}
+
+// commit executes the command and releases locks
+func (tx *Transaction) commit() resp.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return reply.MakeErrReply("ERR transaction " + tx.id + " is not prepared")
+	}
+	tx.timer.Stop()
+	result := tx.cluster.db.ExecWithLock(tx.dbIndex, tx.cmdLine)
+	if reply.IsErrorReply(result) {
+		tx.rollbackWithLock()
+	} else {
+		tx.status = committedStatus
+	}
+	tx.unLockKeys()
+	// keep the committed transaction for a while, coordinator rolls it back if other participants failed
+	time.AfterFunc(waitBeforeCleanTx, func() {
+		tx.cluster.transactions.Remove(tx.id)
+	})
+	return result
+}
+
+// rollback releases locks of a prepared transaction, or undoes a committed transaction
+func (tx *Transaction) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	switch tx.status {
+	case preparedStatus:
+		tx.timer.Stop()
+		tx.unLockKeys()
+	case committedStatus:
+		tx.lockKeys()
+		tx.rollbackWithLock()
+		tx.unLockKeys()
+	}
+	tx.status = rolledBackStatus
+}
+
+// rollbackWithLock executes undo logs, invoker should hold locks
+func (tx *Transaction) rollbackWithLock() {
+	for _, cmdLine := range tx.undoLog {
+		result := tx.cluster.db.ExecWithLock(tx.dbIndex, cmdLine)
+		if reply.IsErrorReply(result) {
+			logger.Error("rollback transaction " + tx.id + " failed: " + string(result.ToBytes()))
+		}
+	}
+	tx.status = rolledBackStatus
+}
+
+// checkKeysNotExist aborts the transaction if any of the write keys exists
+func checkKeysNotExist(tx *Transaction) resp.Reply {
+	keys := make([][]byte, len(tx.writeKeys))
+	for i, key := range tx.writeKeys {
+		keys[i] = []byte(key)
+	}
+	result := tx.cluster.db.ExecWithLock(tx.dbIndex, utils.ToCmdLine2("exists", keys...))
+	if intReply, ok := result.(*reply.IntReply); ok && intReply.Code > 0 {
+		return reply.MakeErrReply(errKeyExists)
+	}
+	if reply.IsErrorReply(result) {
+		return result
+	}
+	return reply.MakeOkReply()
+}
+
+// dumpRenameSource returns expire time(unix ms, 0 if no ttl) and dumped value of the source key
+func dumpRenameSource(tx *Transaction) resp.Reply {
+	src := tx.writeKeys[0]
+	payload, ok := tx.cluster.db.ExecWithLock(tx.dbIndex, utils.ToCmdLine("dump", src)).(*reply.BulkReply)
+	if !ok || payload.Arg == nil {
+		return reply.MakeErrReply(errNoSuchKey)
+	}
+	expireAt := int64(0)
+	pttl, ok := tx.cluster.db.ExecWithLock(tx.dbIndex, utils.ToCmdLine("pttl", src)).(*reply.IntReply)
+	if ok && pttl.Code >= 0 {
+		expireAt = time.Now().Add(time.Duration(pttl.Code)*time.Millisecond).UnixNano() / int64(time.Millisecond)
+	}
+	return reply.MakeMultiBulkReply([][]byte{
+		[]byte(strconv.FormatInt(expireAt, 10)),
+		payload.Arg,
+	})
+}
+
+// restoreRenameDest converts "renameto dest expireAt payload" into RESTORE
+func restoreRenameDest(args [][]byte) CmdLine {
+	return utils.ToCmdLine2("restore", args[1], args[2], args[3], []byte("REPLACE"), []byte("ABSTTL"))
+}
+
+func (cluster *ClusterDatabase) getTransaction(id string) (*Transaction, bool) {
+	raw, ok := cluster.transactions.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return raw.(*Transaction), true
+}
+
+// execPrepare handles PREPARE txID cmd args... on participant
+func execPrepare(cluster *ClusterDatabase, c resp.Connection, cmdLine CmdLine) resp.Reply {
+	if len(cmdLine) < 3 {
+		return reply.MakeArgNumErrReply("prepare")
+	}
+	txID := string(cmdLine[1])
+	cmdName := strings.ToLower(string(cmdLine[2]))
+	cmd, ok := txCommands[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR command '" + cmdName + "' cannot be used in transaction")
+	}
+	argNum := len(cmdLine) - 2
+	if (cmd.arity >= 0 && argNum != cmd.arity) || argNum < -cmd.arity {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	tx := NewTransaction(cluster, c.GetDBIndex(), txID, cmdLine[2:], cmd)
+	if cluster.transactions.PutIfAbsent(txID, tx) == 0 {
+		return reply.MakeErrReply("ERR transaction " + txID + " already exists")
+	}
+	result := tx.prepare()
+	if reply.IsErrorReply(result) {
+		cluster.transactions.Remove(txID)
+	}
+	return result
+}
+
+// execCommit handles COMMIT txID on participant, it returns the reply of the committed command
+func execCommit(cluster *ClusterDatabase, c resp.Connection, cmdLine CmdLine) resp.Reply {
+	if len(cmdLine) != 2 {
+		return reply.MakeArgNumErrReply("commit")
+	}
+	txID := string(cmdLine[1])
+	tx, ok := cluster.getTransaction(txID)
+	if !ok {
+		return reply.MakeErrReply("ERR transaction " + txID + " not found")
+	}
+	return tx.commit()
+}
+
+// execRollback handles ROLLBACK txID on participant
+func execRollback(cluster *ClusterDatabase, c resp.Connection, cmdLine CmdLine) resp.Reply {
+	if len(cmdLine) != 2 {
+		return reply.MakeArgNumErrReply("rollback")
+	}
+	txID := string(cmdLine[1])
+	tx, ok := cluster.getTransaction(txID)
+	if !ok { // not prepared or already finished
+		return reply.MakeIntReply(0)
+	}
+	tx.rollback()
+	cluster.transactions.Remove(txID)
+	return reply.MakeIntReply(1)
+}
+
+/* ---- coordinator ---- */
+
+// newTxID generates an id unique in cluster
+func (cluster *ClusterDatabase) newTxID() string {
+	return cluster.self + "-" + strconv.FormatUint(atomic.AddUint64(&cluster.txSeq, 1), 10)
+}
+
+// relayTx sends a command of distributed transaction to a participant, it is executed directly if the participant is self
+func (cluster *ClusterDatabase) relayTx(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer != cluster.self {
+		return cluster.relay(peer, c, args)
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "prepare":
+		return execPrepare(cluster, c, args)
+	case "commit":
+		return execCommit(cluster, c, args)
+	default:
+		return execRollback(cluster, c, args)
+	}
+}
+
+// requestPrepare sends PREPARE to a participant
+func (cluster *ClusterDatabase) requestPrepare(c resp.Connection, txID string, peer string, cmdLine CmdLine) resp.Reply {
+	args := make([][]byte, 0, len(cmdLine)+2)
+	args = append(args, []byte("prepare"), []byte(txID))
+	args = append(args, cmdLine...)
+	return cluster.relayTx(peer, c, args)
+}
+
+// requestCommit commits the transaction on all participants concurrently, if any of them fails, the transaction is rolled back
+func (cluster *ClusterDatabase) requestCommit(c resp.Connection, txID string, peers []string) (map[string]resp.Reply, reply.ErrorReply) {
+	replies := make(map[string]resp.Reply, len(peers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			result := cluster.relayTx(peer, c, utils.ToCmdLine("commit", txID))
+			mu.Lock()
+			replies[peer] = result
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	for _, peer := range peers {
+		if errReply, ok := replies[peer].(reply.ErrorReply); ok {
+			cluster.requestRollback(c, txID, peers)
+			return nil, errReply
+		}
+	}
+	return replies, nil
+}
+
+// requestRollback rolls back the transaction on all participants
+func (cluster *ClusterDatabase) requestRollback(c resp.Connection, txID string, peers []string) {
+	for _, peer := range peers {
+		result := cluster.relayTx(peer, c, utils.ToCmdLine("rollback", txID))
+		if reply.IsErrorReply(result) {
+			logger.Error("rollback transaction " + txID + " on " + peer + " failed: " + string(result.ToBytes()))
+		}
+	}
+}
+
+// execTx executes commands on the given nodes as a distributed transaction, either all of them are committed or none.
+// it returns replies of commit of every node
+func (cluster *ClusterDatabase) execTx(c resp.Connection, cmdLines map[string]CmdLine) (map[string]resp.Reply, reply.ErrorReply) {
+	txID := cluster.newTxID()
+	peers := make([]string, 0, len(cmdLines))
+	for peer := range cmdLines {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers) // prepare in the same order to reduce lock conflicts between transactions
+	for _, peer := range peers {
+		result := cluster.requestPrepare(c, txID, peer, cmdLines[peer])
+		if errReply, ok := result.(reply.ErrorReply); ok {
+			cluster.requestRollback(c, txID, peers)
+			return nil, errReply
+		}
+	}
+	return cluster.requestCommit(c, txID, peers)
+}
+
+// groupByPeer groups keys by the node holding them
+func (cluster *ClusterDatabase) groupByPeer(keys [][]byte) map[string][][]byte {
+	result := make(map[string][][]byte)
+	for _, key := range keys {
+		peer := cluster.pickNode(string(key))
+		result[peer] = append(result[peer], key)
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package main
+
+//aof-check 检查aof文件，报告第一个非法命令的位置，使用--fix时把文件截断到最后一条完整的命令
+//usage: aof-check [--fix] <file.aof | file.manifest>
+
+import (
+	"flag"
+	"fmt"
+	"go_redis_write/aof"
+	"os"
+	"strings"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last valid command")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: aof-check [--fix] <file.aof | file.manifest>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+
+	files := []string{filename}
+	if strings.HasSuffix(filename, ".manifest") {
+		var err error
+		files, err = aof.ManifestFiles(filename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	for _, file := range files {
+		if !check(file, *fix) {
+			os.Exit(1)
+		}
+	}
+}
+
+// check validates the file, and fixes it if required. it returns false if the file is still invalid
+func check(filename string, fix bool) bool {
+	err := aof.CheckFile(filename)
+	if err == nil {
+		fmt.Println(filename + ": AOF is valid")
+		return true
+	}
+	formatErr, ok := err.(*aof.FormatError)
+	if !ok {
+		fmt.Println(filename + ": " + err.Error())
+		return false
+	}
+	fmt.Println(formatErr.Error())
+	if formatErr.Preamble {
+		fmt.Println("RDB preamble is broken, it cannot be fixed by aof-check")
+		return false
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	diff := info.Size() - formatErr.Offset
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n", filename, info.Size(), formatErr.Offset, diff)
+	if !fix {
+		fmt.Println("This will shrink the AOF from " + fmt.Sprint(info.Size()) + " bytes, with " +
+			fmt.Sprint(diff) + " bytes, to " + fmt.Sprint(formatErr.Offset) + " bytes, run with --fix to truncate it")
+		return false
+	}
+	err = os.Truncate(filename, formatErr.Offset)
+	if err != nil {
+		fmt.Println("Failed to truncate AOF: " + err.Error())
+		return false
+	}
+	fmt.Println("Successfully truncated AOF " + filename)
+	return true
+}
@@ -16,28 +16,121 @@ type ServerProperties struct {
 	Port           int    `cfg:"port"`
 	AppendOnly     bool   `cfg:"appendOnly"`
 	AppendFilename string `cfg:"appendFilename"`
+	AppendFsync    string `cfg:"appendfsync"` // always, everysec or no
 	MaxClients     int    `cfg:"maxclients"`
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
+	// directory of multi part aof files and manifest, file names begin with AppendFilename
+	AppendDirname string `cfg:"appenddirname"`
+	// write rdb instead of commands as the base of rewritten aof
+	AofUseRdbPreamble bool `cfg:"aof-use-rdb-preamble"`
+	// truncate the last aof file to the last valid command if it ends in the middle of a command
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// load aof with invalid content in the middle, the file is truncated there and content after it is discarded
+	AofLoadCorrupted bool `cfg:"aof-load-corrupted"`
+	// rewrite aof when it grows by the percentage since last rewrite, 0 means disable auto rewrite
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"`
+	// don't auto rewrite aof smaller than this size, e.g. 64mb
+	AutoAofRewriteMinSize int `cfg:"auto-aof-rewrite-min-size"`
+	// rdb snapshot file
+	DBFilename string `cfg:"dbfilename"`
+	// save rules like "900 1 300 10", multiple save lines are joined
+	Save string `cfg:"save"`
+	// "host port" of master, this node starts as a replica if set
+	ReplicaOf string `cfg:"replicaof"`
+	// replica refuses writing of normal clients
+	ReplicaReadOnly bool `cfg:"replica-read-only"`
+	// seconds without data from master before replica reconnects
+	ReplTimeout int `cfg:"repl-timeout"`
 
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
+
+	// proxy: relay requests of keys on other nodes, redirect: reply MOVED/ASK to cluster-aware clients
+	ClusterMode string `cfg:"cluster-mode"`
+	// start as a cluster node without peers, other nodes can join it by CLUSTER MEET
+	ClusterEnabled bool `cfg:"cluster-enabled"`
+	// port of cluster bus, 0 means port of self + 10000
+	ClusterPort int `cfg:"cluster-port"`
+	// nodes and slots are saved to this file, node restarts with the same id and slots
+	ClusterConfigFile string `cfg:"cluster-config-file"`
+	// milliseconds without pong before a node is considered failing
+	ClusterNodeTimeout int `cfg:"cluster-node-timeout"`
+	// milliseconds to wait for every node when a command is broadcast to all nodes, e.g. FLUSHDB
+	ClusterBroadcastTimeout int `cfg:"cluster-broadcast-timeout"`
+	// connections to every peer, 0 means no limit
+	ClusterPoolMaxTotal int `cfg:"cluster-pool-max-total"`
+	// idle connections kept for every peer, 0 means no limit
+	ClusterPoolMaxIdle int `cfg:"cluster-pool-max-idle"`
+	// milliseconds to wait for a connection when all connections to a peer are in use, 0 means forever
+	ClusterPoolBorrowTimeout int `cfg:"cluster-pool-borrow-timeout"`
+	// send PING before using a connection, broken connections are closed
+	ClusterPoolTestOnBorrow bool `cfg:"cluster-pool-test-on-borrow"`
+	// milliseconds before an idle connection is closed, 0 means never
+	ClusterPoolIdleTimeout int `cfg:"cluster-pool-idle-timeout"`
+	// milliseconds between checks of idle connections, 0 disables the check
+	ClusterPoolEvictInterval int `cfg:"cluster-pool-evict-interval"`
 }
 
 // Properties holds global config properties
 var Properties *ServerProperties
 
 func init() {
-	// default config
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+	Properties = defaultProperties()
+}
+
+// defaultProperties returns the defaults, which are kept if absent in config file
+func defaultProperties() *ServerProperties {
+	return &ServerProperties{
+		Bind:                     "0.0.0.0",
+		Port:                     6379,
+		AppendOnly:               false,
+		AppendFilename:           "appendonly.aof",
+		AppendDirname:            "appendonlydir",
+		AppendFsync:              "everysec",
+		AofUseRdbPreamble:        true,
+		AofLoadTruncated:         true,
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 << 20,
+		DBFilename:               "dump.rdb",
+		ReplicaReadOnly:          true,
+		ReplTimeout:              60,
+		ClusterMode:              "proxy",
+		ClusterConfigFile:        "nodes.conf",
+		ClusterNodeTimeout:       15000,
+		ClusterBroadcastTimeout:  5000,
+		ClusterPoolMaxTotal:      8,
+		ClusterPoolMaxIdle:       8,
+		ClusterPoolBorrowTimeout: 3000,
+		ClusterPoolIdleTimeout:   300000,
+		ClusterPoolEvictInterval: 30000,
+	}
+}
+
+// parseMemorySize parses sizes like 1024, 1k, 1kb, 64mb, 1gb, units are case insensitive
+func parseMemorySize(value string) (int64, error) {
+	value = strings.ToLower(value)
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000},
 	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			num, err := strconv.ParseInt(strings.TrimSuffix(value, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return num * unit.size, nil
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := defaultProperties()
 
 	// read config file
 	rawMap := make(map[string]string)
@@ -51,7 +144,11 @@ func parse(src io.Reader) *ServerProperties {
 		if pivot > 0 && pivot < len(line)-1 { // separator found
 			key := line[0:pivot]
 			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+			key = strings.ToLower(key)
+			if prev, ok := rawMap[key]; ok && key == "save" { // save 900 1 \n save 300 10
+				value = prev + " " + value
+			}
+			rawMap[key] = value
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -76,7 +173,7 @@ func parse(src io.Reader) *ServerProperties {
 			case reflect.String:
 				fieldVal.SetString(value)
 			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
+				intValue, err := parseMemorySize(value) // also accepts plain numbers
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
@@ -6,13 +6,29 @@ var cmdTable = make(map[string]*command) //记录所有的指令的结构体，
 
 type command struct {
 	executor ExecFunc //执行方式
+	prepare  PreFunc  //返回要写和要读的key，执行前用来加锁
 	arity    int      //参数个数  比如SET K V的参数是三
 }
 
-func RegisterCommand(name string, exector ExecFunc, arity int) {
+func RegisterCommand(name string, exector ExecFunc, prepare PreFunc, arity int) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
 		executor: exector,
+		prepare:  prepare,
 		arity:    arity,
 	}
 }
+
+// isWriteCommand returns true if the command modifies data, replica refuses them from normal clients
+func isWriteCommand(cmdLine [][]byte) bool {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "flushdb" || cmdName == "flushall" { // writes without key
+		return true
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return false // let executor report the error
+	}
+	write, _ := cmd.prepare(cmdLine[1:])
+	return len(write) > 0
+}
@@ -4,16 +4,38 @@ import (
 	"go_redis_write/datastruct/dict"
 	"go_redis_write/interface/database"
 	"go_redis_write/interface/resp"
+	"go_redis_write/lib/sync/lock"
 	"go_redis_write/resp/reply"
 	"strings"
+	"time"
+)
+
+const (
+	// activeExpireInterval is how often the background sweeper scans ttlMap
+	activeExpireInterval = 100 * time.Millisecond
+	// activeExpireLimit is the max number of expired keys removed by one sweep
+	activeExpireLimit = 200
+	// lockerSize is the number of shards of key locks
+	lockerSize = 1024
 )
 
 // DB stores data and execute user's commands
 type DB struct {
 	index int //数据库ID
 	// key -> DataEntity
-	data   dict.Dict
+	data dict.Dict
+	// key -> expire time (time.Time)
+	ttlMap dict.Dict
+	// key -> version(uint32), bumped by every write, used by WATCH
+	versionMap dict.Dict
+	// key锁，保证命令和事务执行期间相关key不被其他连接修改
+	locker *lock.Locks
 	addAof func(CmdLine) //加上AOF方法
+	// closed to stop the active expire goroutine
+	stopExpire chan struct{}
+	// called with the lock of key held, cluster uses them to index keys by slot
+	insertCallback database.KeyEventCallback
+	deleteCallback database.KeyEventCallback
 }
 
 // ExecFunc is interface for command executor
@@ -26,8 +48,11 @@ type CmdLine = [][]byte
 // makeDB create DB instance
 func makeDB() *DB {
 	db := &DB{
-		data:   dict.MakeSyncDict(),
-		addAof: func(line CmdLine) {},
+		data:       dict.MakeSyncDict(),
+		ttlMap:     dict.MakeSyncDict(),
+		versionMap: dict.MakeSyncDict(),
+		locker:     lock.Make(lockerSize),
+		addAof:     func(line CmdLine) {},
 	}
 	return db
 }
@@ -35,6 +60,42 @@ func makeDB() *DB {
 // Exec executes command within one database
 func (db *DB) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
 	//PING SET SETNX
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName { //事务相关的命令需要用到连接上的状态，单独处理
+	case "multi":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return StartMulti(c)
+	case "discard":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return DiscardMulti(c)
+	case "exec":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execMulti(db, c)
+	case "watch":
+		if !validateArity(-2, cmdLine) {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return Watch(db, c, cmdLine[1:])
+	case "unwatch":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(c)
+	}
+	if c != nil && c.InMultiState() {
+		return EnqueueCmd(c, cmdLine)
+	}
+	return db.execNormalCommand(cmdLine)
+}
+
+// execNormalCommand locks the related keys and executes the command
+func (db *DB) execNormalCommand(cmdLine [][]byte) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName] //取指令给拿出来，然后执行这个函数
 	if !ok {
@@ -44,11 +105,29 @@ func (db *DB) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
 	if !validateArity(cmd.arity, cmdLine) { //要求的指令的个数,参数个数不对
 		return reply.MakeArgNumErrReply(cmdName)
 	}
+	write, read := cmd.prepare(cmdLine[1:])
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
+	db.addVersion(write...)
 	fun := cmd.executor
 	//SET K V, K V
 	return fun(db, cmdLine[1:]) //注意这个他们把其中的SET GET DEL等命令拿出来的
 }
 
+// execWithLock executes normal commands, invoker should provide locks
+func (db *DB) execWithLock(cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	fun := cmd.executor
+	return fun(db, cmdLine[1:])
+}
+
 //SET K V 如果是固定的 arity = 3
 //EXISTS k1 k2 k3 k4...  arity = -2 表示可以超过这个2
 func validateArity(arity int, cmdArgs [][]byte) bool {
@@ -70,35 +149,52 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	if !ok {
 		return nil, false
 	}
+	if db.IsExpired(key) { //惰性删除，访问的时候发现过期了就删掉
+		return nil, false
+	}
 	entity, _ := raw.(*database.DataEntity)
 	return entity, true
 }
 
 // PutEntity a DataEntity into DB
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.data.Put(key, entity)
+	ret := db.data.Put(key, entity)
+	if ret > 0 && db.insertCallback != nil {
+		db.insertCallback(db.index, key)
+	}
+	return ret
 }
 
 // PutIfExists edit an existing DataEntity
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
+	db.IsExpired(key) // an expired key must not be treated as existing
 	return db.data.PutIfExists(key, entity)
 }
 
 // PutIfAbsent insert an DataEntity only if the key not exists
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.data.PutIfAbsent(key, entity)
+	db.IsExpired(key)
+	ret := db.data.PutIfAbsent(key, entity)
+	if ret > 0 && db.insertCallback != nil {
+		db.insertCallback(db.index, key)
+	}
+	return ret
 }
 
 // Remove the given key from db
 func (db *DB) Remove(key string) {
-	db.data.Remove(key)
+	ret := db.data.Remove(key)
+	db.ttlMap.Remove(key)
+	if ret > 0 && db.deleteCallback != nil {
+		db.deleteCallback(db.index, key)
+	}
 }
 
 // Removes the given keys from db
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
+		_, exists := db.GetEntity(key)
 		if exists {
 			db.Remove(key)
 			deleted++
@@ -109,5 +205,150 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 
 // Flush clean database
 func (db *DB) Flush() {
+	// 清空前让所有已有key的版本号加一，WATCH这些key的事务会失败
+	db.data.ForEach(func(key string, val interface{}) bool {
+		db.addVersion(key)
+		if db.deleteCallback != nil {
+			db.deleteCallback(db.index, key)
+		}
+		return true
+	})
 	db.data.Clear()
+	db.ttlMap.Clear()
+}
+
+// ForEach traverses all the keys in the database, skips expired keys
+func (db *DB) ForEach(cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	now := time.Now()
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		entity, _ := raw.(*database.DataEntity)
+		var expiration *time.Time
+		expireTime, ok := db.GetExpireTime(key)
+		if ok {
+			if now.After(expireTime) {
+				return true
+			}
+			expiration = &expireTime
+		}
+		return cb(key, entity, expiration)
+	})
+}
+
+/* ---- TTL Functions ---- */
+
+// Expire sets the expire time of key
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist cancels the expire time of key, returns whether the key had one
+func (db *DB) Persist(key string) bool {
+	return db.ttlMap.Remove(key) > 0
+}
+
+// GetExpireTime returns the expire time of key and whether the key has a ttl
+func (db *DB) GetExpireTime(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	expireTime, _ := raw.(time.Time)
+	return expireTime, true
+}
+
+// IsExpired check whether a key is expired, an expired key will be removed
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.GetExpireTime(key)
+	if !ok {
+		return false
+	}
+	expired := time.Now().After(expireTime)
+	if expired {
+		db.Remove(key)
+		db.addVersion(key) // an expired key counts as modified for WATCH
+	}
+	return expired
+}
+
+// expired tells whether the key is expired without removing it,
+// commands which don't lock keys use it, removing is left to the next access or activeExpire
+func (db *DB) expired(key string) bool {
+	expireTime, ok := db.GetExpireTime(key)
+	return ok && time.Now().After(expireTime)
+}
+
+// startActiveExpire starts a goroutine which removes expired keys periodically,
+// so keys never accessed again won't stay in memory forever
+func (db *DB) startActiveExpire() {
+	stop := make(chan struct{})
+	db.stopExpire = stop
+	go func() {
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				db.activeExpire()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopActiveExpire stops the goroutine started by startActiveExpire
+func (db *DB) stopActiveExpire() {
+	if db.stopExpire != nil {
+		close(db.stopExpire)
+		db.stopExpire = nil
+	}
+}
+
+// activeExpire removes at most activeExpireLimit expired keys
+func (db *DB) activeExpire() {
+	now := time.Now()
+	expired := make([]string, 0)
+	db.ttlMap.ForEach(func(key string, val interface{}) bool {
+		expireTime, _ := val.(time.Time)
+		if now.After(expireTime) {
+			expired = append(expired, key)
+		}
+		return len(expired) < activeExpireLimit
+	})
+	for _, key := range expired {
+		db.locker.Lock(key)
+		db.IsExpired(key) // check again, the key may be updated after scan
+		db.locker.UnLock(key)
+	}
+}
+
+/* ---- Lock Functions ----- */
+
+// RWLocks lock keys for writing and reading
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks unlock keys for writing and reading
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+/* ---- Version Functions ----- */
+
+// addVersion increases version of the given keys
+func (db *DB) addVersion(keys ...string) {
+	for _, key := range keys {
+		versionCode := db.GetVersion(key)
+		db.versionMap.Put(key, versionCode+1)
+	}
+}
+
+// GetVersion returns version code for given key
+func (db *DB) GetVersion(key string) uint32 {
+	entity, ok := db.versionMap.Get(key)
+	if !ok {
+		return 0
+	}
+	return entity.(uint32)
 }
@@ -0,0 +1,603 @@
+package database
+
+import (
+	"container/heap"
+	Dict "go_redis_write/datastruct/dict"
+	"go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/lib/wildcard"
+	"go_redis_write/resp/reply"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+//HSET HSETNX HMSET
+//HGET HMGET HEXISTS HLEN HSTRLEN
+//HDEL
+//HKEYS HVALS HGETALL
+//HINCRBY HINCRBYFLOAT
+//HSCAN HRANDFIELD
+
+func (db *DB) getAsDict(key string) (Dict.Dict, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return dict, nil
+}
+
+func (db *DB) getOrInitDict(key string) (dict Dict.Dict, inited bool, errReply reply.ErrorReply) {
+	dict, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if dict == nil {
+		dict = Dict.MakeSimple()
+		db.PutEntity(key, &database.DataEntity{
+			Data: dict,
+		})
+		inited = true
+	}
+	return dict, inited, nil
+}
+
+//HSET k1 f1 v1 f2 v2  返回新增的field个数
+// execHSet sets field in hash table
+func execHSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := 0
+	for i := 1; i < len(args); i += 2 {
+		field := string(args[i])
+		value := args[i+1]
+		result += dict.Put(field, value)
+	}
+	db.addAof(utils.ToCmdLine2("hset", args...))
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHMSet sets multi fields in hash table
+func execHMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hmset")
+	}
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		dict.Put(string(args[i]), args[i+1])
+	}
+	db.addAof(utils.ToCmdLine2("hmset", args...))
+	return &reply.OkReply{}
+}
+
+// execHSetNX sets field in hash table only if field not exists
+func execHSetNX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	value := args[2]
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := dict.PutIfAbsent(field, value)
+	if result > 0 {
+		db.addAof(utils.ToCmdLine2("hsetnx", args...))
+	}
+	return reply.MakeIntReply(int64(result))
+}
+
+//HGET k1 f1
+// execHGet gets field value of hash table
+func execHGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	raw, exists := dict.Get(field)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeBulkReply(value)
+}
+
+// execHMGet gets multi fields in hash table
+func execHMGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	size := len(args) - 1
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := make([][]byte, size)
+	if dict == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i := 0; i < size; i++ {
+		raw, exists := dict.Get(string(args[i+1]))
+		if exists {
+			result[i], _ = raw.([]byte)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHExists checks if a hash field exists
+func execHExists(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	_, exists := dict.Get(field)
+	if exists {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+//HDEL k1 f1 f2
+// execHDel deletes a hash field
+func execHDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	deleted := 0
+	for _, field := range args[1:] {
+		deleted += dict.Remove(string(field))
+	}
+	if dict.Len() == 0 { //hash空了就把key删掉
+		db.Remove(key)
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("hdel", args...))
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execHLen gets number of fields in hash table
+func execHLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(dict.Len()))
+}
+
+// execHStrlen gets string length of field value in hash table
+func execHStrlen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	raw, exists := dict.Get(field)
+	if exists {
+		value, _ := raw.([]byte)
+		return reply.MakeIntReply(int64(len(value)))
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execHKeys gets all field names in hash table
+func execHKeys(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	fields := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(field string, val interface{}) bool {
+		fields = append(fields, []byte(field))
+		return true
+	})
+	return reply.MakeMultiBulkReply(fields)
+}
+
+// execHVals gets all field value in hash table
+func execHVals(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	values := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(field string, val interface{}) bool {
+		value, _ := val.([]byte)
+		values = append(values, value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(values)
+}
+
+//HGETALL k1  返回 f1 v1 f2 v2 ...
+// execHGetAll gets all key-value entries in hash table
+func execHGetAll(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	result := make([][]byte, 0, dict.Len()*2)
+	dict.ForEach(func(field string, val interface{}) bool {
+		value, _ := val.([]byte)
+		result = append(result, []byte(field), value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(result)
+}
+
+//HINCRBY k1 f1 10
+// execHIncrBy increments the integer value of a hash field by the given number
+func execHIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	value, exists := dict.Get(field)
+	if !exists {
+		dict.Put(field, []byte(strconv.FormatInt(delta, 10)))
+		db.addAof(utils.ToCmdLine2("hincrby", args...))
+		return reply.MakeIntReply(delta)
+	}
+	val, err := strconv.ParseInt(string(value.([]byte)), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR hash value is not an integer")
+	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+	dict.Put(field, []byte(strconv.FormatInt(val, 10)))
+	db.addAof(utils.ToCmdLine2("hincrby", args...))
+	return reply.MakeIntReply(val)
+}
+
+//HINCRBYFLOAT k1 f1 1.5
+// execHIncrByFloat increments the float value of a hash field by the given number
+func execHIncrByFloat(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return reply.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	val := float64(0)
+	if value, exists := dict.Get(field); exists {
+		val, err = strconv.ParseFloat(string(value.([]byte)), 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	sum := val + delta
+	if math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return reply.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+	result := []byte(strconv.FormatFloat(sum, 'f', -1, 64))
+	dict.Put(field, result)
+	// aof 里写结果而不是增量，避免重放时浮点误差累积
+	db.addAof(utils.ToCmdLine2("hset", args[0], args[1], result))
+	return reply.MakeBulkReply(result)
+}
+
+// scanOptions holds the optional arguments of *SCAN commands
+type scanOptions struct {
+	pattern *wildcard.Pattern // nil means match all
+	count   int
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] of *SCAN commands
+func parseScanOptions(args [][]byte) (*scanOptions, reply.ErrorReply) {
+	opts := &scanOptions{
+		count: 10,
+	}
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if i+1 >= len(args) {
+			return nil, &reply.SyntaxErrReply{}
+		}
+		switch arg {
+		case "MATCH":
+			opts.pattern = wildcard.CompilePattern(string(args[i+1]))
+		case "COUNT":
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			opts.count = count
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
+		i++
+	}
+	return opts, nil
+}
+
+// parseScanCursor parses cursor argument of *SCAN commands
+func parseScanCursor(arg []byte) (int, reply.ErrorReply) {
+	cursor, err := strconv.Atoi(string(arg))
+	if err != nil || cursor < 0 {
+		return 0, reply.MakeErrReply("ERR invalid cursor")
+	}
+	return cursor, nil
+}
+
+const prime32 = uint32(16777619)
+
+// scanHash returns the position of a key or field in *SCAN iteration
+func scanHash(name string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash *= prime32
+		hash ^= uint32(name[i])
+	}
+	return hash
+}
+
+// hashHeap is a max heap of hash values
+type hashHeap []uint32
+
+func (h hashHeap) Len() int            { return len(h) }
+func (h hashHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h hashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x interface{}) { *h = append(*h, x.(uint32)) }
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// scanDict returns the names of a *SCAN page and the next cursor, 0 means the iteration is finished.
+// names are visited in the order of their hash and the cursor is the next hash to visit,
+// so adding or removing names doesn't move others, a full iteration returns every name which exists
+// from the start to the end of it. names with the same hash are returned together, a page may exceed count.
+// dict is traversed twice, a page costs O(n log count) without copying or sorting all names
+func scanDict(dict Dict.Dict, cursor int, count int) ([]string, int) {
+	if int64(cursor) > math.MaxUint32 {
+		return nil, 0
+	}
+	start := uint32(cursor)
+	//第一遍用大小为count的最大堆找出不小于游标的最小的count个哈希值，堆顶就是本页的上界
+	hashes := make(hashHeap, 0, count)
+	dict.ForEach(func(name string, val interface{}) bool {
+		hash := scanHash(name)
+		if hash < start {
+			return true
+		}
+		if len(hashes) < count {
+			heap.Push(&hashes, hash)
+		} else if hash < hashes[0] {
+			hashes[0] = hash
+			heap.Fix(&hashes, 0)
+		}
+		return true
+	})
+	if len(hashes) == 0 {
+		return nil, 0
+	}
+	end := hashes[0]
+	names := make([]string, 0, count)
+	dict.ForEach(func(name string, val interface{}) bool {
+		hash := scanHash(name)
+		if hash >= start && hash <= end {
+			names = append(names, name)
+		}
+		return true
+	})
+	if len(hashes) < count || end == math.MaxUint32 { // no name after end
+		return names, 0
+	}
+	return names, int(end) + 1
+}
+
+//HSCAN k1 0 MATCH f* COUNT 10
+// execHScan iterates fields of hash table, see scanDict for the cursor
+func execHScan(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	cursor, errReply := parseScanCursor(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseScanOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return makeScanReply(0, nil)
+	}
+
+	fields, nextCursor := scanDict(dict, cursor, opts.count)
+	result := make([][]byte, 0, 2*len(fields))
+	for _, field := range fields {
+		if opts.pattern != nil && !opts.pattern.IsMatch(field) {
+			continue
+		}
+		raw, _ := dict.Get(field)
+		value, _ := raw.([]byte)
+		result = append(result, []byte(field), value)
+	}
+	return makeScanReply(nextCursor, result)
+}
+
+// makeScanReply returns [cursor, [elements...]] which is the reply of *SCAN commands
+func makeScanReply(cursor int, elements [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.Itoa(cursor))),
+		reply.MakeMultiBulkReply(elements),
+	})
+}
+
+//HRANDFIELD k1 [count [WITHVALUES]]
+// count > 0 时返回不重复的field，count < 0 时允许重复
+// execHRandField returns random fields of hash table
+func execHRandField(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	count := 1
+	withCount := len(args) >= 2
+	withValues := false
+	if withCount {
+		c, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = c
+	}
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+			return &reply.SyntaxErrReply{}
+		}
+		withValues = true
+	} else if len(args) > 3 {
+		return &reply.SyntaxErrReply{}
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		if withCount {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	var fields []string
+	if count >= 0 {
+		fields = dict.RandomDistinctKeys(count)
+	} else {
+		fields = dict.RandomKeys(-count)
+	}
+	if !withCount {
+		return reply.MakeBulkReply([]byte(fields[0]))
+	}
+	rand.Shuffle(len(fields), func(i, j int) {
+		fields[i], fields[j] = fields[j], fields[i]
+	})
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			raw, _ := dict.Get(field)
+			value, _ := raw.([]byte)
+			result = append(result, value)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func init() {
+	RegisterCommand("HSet", execHSet, writeFirstKey, -4)
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, -4)
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, 4)
+	RegisterCommand("HGet", execHGet, readFirstKey, 3)
+	RegisterCommand("HMGet", execHMGet, readFirstKey, -3)
+	RegisterCommand("HExists", execHExists, readFirstKey, 3)
+	RegisterCommand("HDel", execHDel, writeFirstKey, -3)
+	RegisterCommand("HLen", execHLen, readFirstKey, 2)
+	RegisterCommand("HStrlen", execHStrlen, readFirstKey, 3)
+	RegisterCommand("HKeys", execHKeys, readFirstKey, 2)
+	RegisterCommand("HVals", execHVals, readFirstKey, 2)
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, 2)
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, 4)
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, 4)
+	RegisterCommand("HScan", execHScan, readFirstKey, -3)
+	RegisterCommand("HRandField", execHRandField, readFirstKey, -2)
+}
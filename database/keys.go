@@ -1,19 +1,35 @@
 package database
 
 import (
+	"go_redis_write/aof"
+	"go_redis_write/datastruct/dict"
+	List "go_redis_write/datastruct/list"
+	HashSet "go_redis_write/datastruct/set"
+	SortedSet "go_redis_write/datastruct/sortedset"
 	"go_redis_write/interface/resp"
 	"go_redis_write/lib/utils"
 	"go_redis_write/lib/wildcard"
+	"go_redis_write/rdb"
 	"go_redis_write/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //DEL
 //EXISTS
 //KEYS
+//SCAN
+//DBSIZE
+//RANDOMKEY
 //FLUSDB
 //TYPE
 //RENAME
 //RENAMENX
+//EXPIRE PEXPIRE EXPIREAT PEXPIREAT
+//TTL PTTL PERSIST
+//DUMP RESTORE
 
 //DEL K1 K2 K3
 // execDel removes a key from db
@@ -62,18 +78,25 @@ func execType(db *DB, args [][]byte) resp.Reply {
 	switch entity.Data.(type) {
 	case []byte:
 		return reply.MakeStatusReply("string")
-		//case *list.LinkedList:
-		//    return reply.MakeStatusReply("list")
-		//case dict.Dict:
-		//    return reply.MakeStatusReply("hash")
-		//case *set.Set:
-		//    return reply.MakeStatusReply("set")
-		//case *sortedset.SortedSet:
-		//    return reply.MakeStatusReply("zset")
+	case List.List:
+		return reply.MakeStatusReply("list")
+	case dict.Dict:
+		return reply.MakeStatusReply("hash")
+	case *HashSet.Set:
+		return reply.MakeStatusReply("set")
+	case *SortedSet.SortedSet:
+		return reply.MakeStatusReply("zset")
 	}
 	return &reply.UnKnownErrReply{}
 }
 
+// prepareRename locks both the source key and the destination key for writing
+func prepareRename(args [][]byte) ([]string, []string) {
+	src := string(args[0])
+	dest := string(args[1])
+	return []string{src, dest}, nil
+}
+
 //RENAME key1 key2  改一下key1的名称为key2
 // execRename a key
 func execRename(db *DB, args [][]byte) resp.Reply {
@@ -87,8 +110,12 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	expireTime, hasTTL := db.GetExpireTime(src)
+	db.Removes(src, dest)      //删除src和dest，连同它们的ttl
 	db.PutEntity(dest, entity) //添加dest
-	db.Remove(src)             //删除src
+	if hasTTL {                //ttl跟着key走
+		db.Expire(dest, expireTime)
+	}
 	db.addAof(utils.ToCmdLine2("rename", args...))
 	return &reply.OkReply{}
 }
@@ -109,9 +136,13 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 		return reply.MakeErrReply("no such key")
 	}
 
+	expireTime, hasTTL := db.GetExpireTime(src)
 	db.Removes(src, dest) // clean src and dest with their ttl
 	db.PutEntity(dest, entity)
-	//db.addAof(utils.ToCmdLine2("renamenx", args...))
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
+	db.addAof(utils.ToCmdLine2("renamenx", args...))
 	return reply.MakeIntReply(1)
 }
 
@@ -127,7 +158,7 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
 	db.data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
+		if pattern.IsMatch(key) && !db.expired(key) {
 			result = append(result, []byte(key))
 		}
 		return true
@@ -135,12 +166,294 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+//SCAN 0 MATCH user:* COUNT 10
+// execScan iterates keys of db, see scanDict for the cursor
+func execScan(db *DB, args [][]byte) resp.Reply {
+	cursor, errReply := parseScanCursor(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseScanOptions(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	keys, nextCursor := scanDict(db.data, cursor, opts.count)
+	result := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if opts.pattern != nil && !opts.pattern.IsMatch(key) {
+			continue
+		}
+		if db.expired(key) {
+			continue
+		}
+		result = append(result, []byte(key))
+	}
+	return makeScanReply(nextCursor, result)
+}
+
+//DBSIZE 返回db中key的数量
+// execDBSize returns the number of keys in db
+func execDBSize(db *DB, args [][]byte) resp.Reply {
+	count := 0
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if !db.expired(key) {
+			count++
+		}
+		return true
+	})
+	return reply.MakeIntReply(int64(count))
+}
+
+//RANDOMKEY 随机返回一个key，db为空时返回nil
+// execRandomKey returns a random key of db
+func execRandomKey(db *DB, args [][]byte) resp.Reply {
+	for i := 0; i < 16; i++ { // retry when an expired key is picked
+		keys := db.data.RandomDistinctKeys(1)
+		if len(keys) == 0 || keys[0] == "" {
+			break
+		}
+		if !db.expired(keys[0]) {
+			return reply.MakeBulkReply([]byte(keys[0]))
+		}
+	}
+	return reply.MakeNullBulkReply()
+}
+
+// maxExpireMs is the max absolute expire time in unix milliseconds, time.Time can't be converted to unix nanoseconds after it
+const maxExpireMs = math.MaxInt64 / int64(time.Millisecond)
+
+// toExpireTime converts a ttl, or a unix timestamp if absolute is set, in seconds or milliseconds to expire time.
+// it returns false if the expire time overflows
+func toExpireTime(raw int64, unit time.Duration, absolute bool) (time.Time, bool) {
+	ms := raw
+	if unit == time.Second {
+		if raw > maxExpireMs/1000 || raw < -maxExpireMs/1000 {
+			return time.Time{}, false
+		}
+		ms = raw * 1000
+	} else if raw > maxExpireMs || raw < -maxExpireMs {
+		return time.Time{}, false
+	}
+	if !absolute {
+		ms += time.Now().UnixNano() / int64(time.Millisecond) // both are in range, so the sum doesn't overflow int64
+		if ms > maxExpireMs || ms < -maxExpireMs {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// makeExpireTimeErrReply returns the error of an overflowed expire time
+func makeExpireTimeErrReply(cmdName string) resp.Reply {
+	return reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+}
+
+// expireAtGeneric sets the absolute expire time of key, returns 1 if key exists
+func expireAtGeneric(db *DB, key string, expireAt time.Time) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	db.Expire(key, expireAt)
+	db.addAof(aof.MakeExpireCmd(key, expireAt))
+	return reply.MakeIntReply(1)
+}
+
+//EXPIRE k1 10  k1在10秒后过期
+// execExpire sets a key's time to live in seconds
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	expireAt, ok := toExpireTime(ttl, time.Second, false)
+	if !ok {
+		return makeExpireTimeErrReply("expire")
+	}
+	return expireAtGeneric(db, key, expireAt)
+}
+
+// execPExpire sets a key's time to live in milliseconds
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	expireAt, ok := toExpireTime(ttl, time.Millisecond, false)
+	if !ok {
+		return makeExpireTimeErrReply("pexpire")
+	}
+	return expireAtGeneric(db, key, expireAt)
+}
+
+//EXPIREAT k1 1660000000  k1在这个unix时间戳（秒）过期
+// execExpireAt sets a key's expiration in unix timestamp
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	expireAt, ok := toExpireTime(raw, time.Second, true)
+	if !ok {
+		return makeExpireTimeErrReply("expireat")
+	}
+	return expireAtGeneric(db, key, expireAt)
+}
+
+// execPExpireAt sets a key's expiration in unix timestamp specified in milliseconds
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	expireAt, ok := toExpireTime(raw, time.Millisecond, true)
+	if !ok {
+		return makeExpireTimeErrReply("pexpireat")
+	}
+	return expireAtGeneric(db, key, expireAt)
+}
+
+//TTL k1 返回剩余的秒数，-2表示key不存在，-1表示没有设置过期时间
+// execTTL returns a key's time to live in seconds
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, hasTTL := db.GetExpireTime(key)
+	if !hasTTL {
+		return reply.MakeIntReply(-1)
+	}
+	ttl := time.Until(expireTime)
+	return reply.MakeIntReply(int64((ttl + 500*time.Millisecond) / time.Second))
+}
+
+// execPTTL returns a key's time to live in milliseconds
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, hasTTL := db.GetExpireTime(key)
+	if !hasTTL {
+		return reply.MakeIntReply(-1)
+	}
+	ttl := time.Until(expireTime)
+	return reply.MakeIntReply(int64(ttl / time.Millisecond))
+}
+
+//PERSIST k1 去掉k1的过期时间
+// execPersist removes expiration from a key
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !db.Persist(key) {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine2("persist", args...))
+	return reply.MakeIntReply(1)
+}
+
+//DUMP k1 返回k1的value序列化后的结果，格式和redis一致
+// execDump serializes the value of key
+func execDump(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	payload, err := rdb.DumpPayload(entity)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+//RESTORE k1 ttl payload [REPLACE] [ABSTTL]  用DUMP的结果创建k1，ttl为0表示不过期
+// execRestore creates a key from the value serialized by DUMP
+func execRestore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace := false
+	absTTL := false
+	for _, arg := range args[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if _, exists := db.GetEntity(key); exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	entity, err := rdb.LoadPayload(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR DUMP payload version or checksum are wrong")
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		var ok bool
+		expireAt, ok = toExpireTime(ttl, time.Millisecond, absTTL)
+		if !ok {
+			return makeExpireTimeErrReply("restore")
+		}
+		if time.Now().After(expireAt) { // restoring an expired key just removes the old one
+			if db.Removes(key) > 0 {
+				db.addAof(utils.ToCmdLine("del", key))
+			}
+			return reply.MakeOkReply()
+		}
+	}
+	db.Remove(key)
+	db.PutEntity(key, entity)
+	if ttl > 0 {
+		db.Expire(key, expireAt)
+	}
+	// ttl is written as absolute time, so replaying aof won't extend it
+	aofTTL := "0"
+	if ttl > 0 {
+		aofTTL = strconv.FormatInt(expireAt.UnixNano()/int64(time.Millisecond), 10)
+	}
+	db.addAof(utils.ToCmdLine("restore", key, aofTTL, string(args[2]), "REPLACE", "ABSTTL"))
+	return reply.MakeOkReply()
+}
+
 func init() {
-	RegisterCommand("Del", execDel, -2) //最少两个，但是个数则需要-2 表示大于2
-	RegisterCommand("Exists", execExists, -2)
-	RegisterCommand("Keys", execKeys, 2)
-	RegisterCommand("FlushDB", execFlushDB, -1) //FLUSHDB a, b, c
-	RegisterCommand("Type", execType, 2)
-	RegisterCommand("Rename", execRename, 3) //入参要三个
-	RegisterCommand("RenameNx", execRenameNx, 3)
+	RegisterCommand("Del", execDel, writeAllKeys, -2) //最少两个，但是个数则需要-2 表示大于2
+	RegisterCommand("Exists", execExists, readAllKeys, -2)
+	RegisterCommand("Keys", execKeys, noPrepare, 2)
+	RegisterCommand("Scan", execScan, noPrepare, -2)
+	RegisterCommand("DBSize", execDBSize, noPrepare, 1)
+	RegisterCommand("RandomKey", execRandomKey, noPrepare, 1)
+	RegisterCommand("FlushDB", execFlushDB, noPrepare, -1) //FLUSHDB a, b, c
+	RegisterCommand("Type", execType, readFirstKey, 2)
+	RegisterCommand("Rename", execRename, prepareRename, 3) //入参要三个
+	RegisterCommand("RenameNx", execRenameNx, prepareRename, 3)
+	RegisterCommand("Expire", execExpire, writeFirstKey, 3)
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, 3)
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, 3)
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, 3)
+	RegisterCommand("TTL", execTTL, readFirstKey, 2)
+	RegisterCommand("PTTL", execPTTL, readFirstKey, 2)
+	RegisterCommand("Persist", execPersist, writeFirstKey, 2)
+	RegisterCommand("Dump", execDump, readFirstKey, 2)
+	RegisterCommand("Restore", execRestore, writeFirstKey, -4)
 }
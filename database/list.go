@@ -0,0 +1,442 @@
+package database
+
+import (
+	List "go_redis_write/datastruct/list"
+	"go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"strconv"
+	"strings"
+)
+
+//LPUSH RPUSH LPUSHX RPUSHX
+//LPOP RPOP
+//LRANGE LINDEX LLEN
+//LSET LREM LTRIM LINSERT
+
+func (db *DB) getAsList(key string) (List.List, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := entity.Data.(List.List)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return list, nil
+}
+
+func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply reply.ErrorReply) {
+	list, errReply = db.getAsList(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if list == nil {
+		list = List.NewQuickList()
+		db.PutEntity(key, &database.DataEntity{
+			Data: list,
+		})
+		isNew = true
+	}
+	return list, isNew, nil
+}
+
+// normalizeIndex converts negative index (counts from the tail) to positive index
+func normalizeIndex(index int64, size int64) int64 {
+	if index < 0 {
+		index = size + index
+	}
+	return index
+}
+
+//LPUSH k1 v1 v2 v3  list里面的顺序是v3 v2 v1
+// execLPush inserts element at head of list
+func execLPush(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	for _, value := range values {
+		list.Insert(0, value)
+	}
+
+	db.addAof(utils.ToCmdLine2("lpush", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLPushX inserts element at head of list, only if list exists
+func execLPushX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	for _, value := range values {
+		list.Insert(0, value)
+	}
+	db.addAof(utils.ToCmdLine2("lpushx", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+//RPUSH k1 v1 v2 v3  list里面的顺序是v1 v2 v3
+// execRPush inserts element at last of list
+func execRPush(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	for _, value := range values {
+		list.Add(value)
+	}
+	db.addAof(utils.ToCmdLine2("rpush", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPushX inserts element at last of list only if list exists
+func execRPushX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	for _, value := range values {
+		list.Add(value)
+	}
+	db.addAof(utils.ToCmdLine2("rpushx", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// popGeneric removes elements from head (or tail) of list, LPOP/RPOP key [count]
+func popGeneric(db *DB, cmdName string, args [][]byte, fromHead bool) resp.Reply {
+	key := string(args[0])
+	count := 1
+	withCount := len(args) == 2
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	if withCount {
+		c, err := strconv.Atoi(string(args[1]))
+		if err != nil || c < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = c
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if withCount { //带count时redis对不存在的key返回空数组
+			return reply.MakeNullMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	result := make([][]byte, 0, count)
+	for i := 0; i < count && list.Len() > 0; i++ {
+		var val interface{}
+		if fromHead {
+			val = list.Remove(0)
+		} else {
+			val = list.RemoveLast()
+		}
+		result = append(result, val.([]byte))
+	}
+	if list.Len() == 0 { //list空了就把key删掉
+		db.Remove(key)
+	}
+	if len(result) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	if !withCount {
+		return reply.MakeBulkReply(result[0])
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+//LPOP k1 [count]
+// execLPop removes the first element of list, and return it
+func execLPop(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, "lpop", args, true)
+}
+
+//RPOP k1 [count]
+// execRPop removes last element of list then return it
+func execRPop(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, "rpop", args, false)
+}
+
+//LLEN k1
+// execLLen gets length of list
+func execLLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+//LINDEX k1 -1  取最后一个值
+// execLIndex gets element of list at given list
+func execLIndex(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	size := int64(list.Len())
+	index := normalizeIndex(index64, size)
+	if index < 0 || index >= size {
+		return &reply.NullBulkReply{}
+	}
+	val, _ := list.Get(int(index)).([]byte)
+	return reply.MakeBulkReply(val)
+}
+
+//LRANGE k1 0 -1  取全部的值
+// execLRange gets elements of list in given range
+func execLRange(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop64, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	// compute index, stop is inclusive in redis
+	size := int64(list.Len())
+	start := normalizeIndex(start64, size)
+	if start < 0 {
+		start = 0
+	}
+	stop := normalizeIndex(stop64, size)
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	slice := list.Range(int(start), int(stop)+1)
+	result := make([][]byte, len(slice))
+	for i, raw := range slice {
+		result[i], _ = raw.([]byte)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+//LREM k1 count v  count>0从头删，count<0从尾删，count=0全删
+// execLRem removes element of list at specified index
+func execLRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	count := int(count64)
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	expected := func(a interface{}) bool {
+		return utils.BytesEquals(a.([]byte), value)
+	}
+	var removed int
+	if count == 0 {
+		removed = list.RemoveAllByVal(expected)
+	} else if count > 0 {
+		removed = list.RemoveByVal(expected, count)
+	} else {
+		removed = list.ReverseRemoveByVal(expected, -count)
+	}
+
+	if list.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("lrem", args...))
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+//LSET k1 0 v
+// execLSet puts element at specified index of list
+func execLSet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+
+	size := int64(list.Len())
+	index := normalizeIndex(index64, size)
+	if index < 0 || index >= size {
+		return reply.MakeErrReply("ERR index out of range")
+	}
+
+	list.Set(int(index), value)
+	db.addAof(utils.ToCmdLine2("lset", args...))
+	return &reply.OkReply{}
+}
+
+//LTRIM k1 1 -1  只保留下标[1, -1]的值
+// execLTrim trims a list so that it will contain only the specified range of elements
+func execLTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop64, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.OkReply{}
+	}
+
+	size := int64(list.Len())
+	start := normalizeIndex(start64, size)
+	if start < 0 {
+		start = 0
+	}
+	stop := normalizeIndex(stop64, size)
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		db.Remove(key) // the whole list is trimmed
+	} else {
+		for i := int64(0); i < start; i++ {
+			list.Remove(0)
+		}
+		for i := stop + 1; i < size; i++ {
+			list.RemoveLast()
+		}
+	}
+	db.addAof(utils.ToCmdLine2("ltrim", args...))
+	return &reply.OkReply{}
+}
+
+//LINSERT k1 BEFORE|AFTER pivot v
+// execLInsert inserts element before or after the reference value pivot
+func execLInsert(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	where := strings.ToUpper(string(args[1]))
+	if where != "BEFORE" && where != "AFTER" {
+		return &reply.SyntaxErrReply{}
+	}
+	pivot := args[2]
+	value := args[3]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	pivotIndex := -1
+	list.ForEach(func(i int, v interface{}) bool {
+		if utils.BytesEquals(v.([]byte), pivot) {
+			pivotIndex = i
+			return false
+		}
+		return true
+	})
+	if pivotIndex < 0 {
+		return reply.MakeIntReply(-1)
+	}
+	if where == "BEFORE" {
+		list.Insert(pivotIndex, value)
+	} else {
+		list.Insert(pivotIndex+1, value)
+	}
+	db.addAof(utils.ToCmdLine2("linsert", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+func init() {
+	RegisterCommand("LPush", execLPush, writeFirstKey, -3)
+	RegisterCommand("LPushX", execLPushX, writeFirstKey, -3)
+	RegisterCommand("RPush", execRPush, writeFirstKey, -3)
+	RegisterCommand("RPushX", execRPushX, writeFirstKey, -3)
+	RegisterCommand("LPop", execLPop, writeFirstKey, -2)
+	RegisterCommand("RPop", execRPop, writeFirstKey, -2)
+	RegisterCommand("LLen", execLLen, readFirstKey, 2)
+	RegisterCommand("LIndex", execLIndex, readFirstKey, 3)
+	RegisterCommand("LRange", execLRange, readFirstKey, 4)
+	RegisterCommand("LRem", execLRem, writeFirstKey, 4)
+	RegisterCommand("LSet", execLSet, writeFirstKey, 4)
+	RegisterCommand("LTrim", execLTrim, writeFirstKey, 4)
+	RegisterCommand("LInsert", execLInsert, writeFirstKey, 5)
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/resp/reply"
+	"strings"
+)
+
+//MULTI 之后的命令不会马上执行，而是放到连接的队列里，EXEC 时一次性在锁内执行
+
+// StartMulti starts multi-command-transaction
+func StartMulti(conn resp.Connection) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	conn.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// EnqueueCmd puts command line into `multi` pending queue
+func EnqueueCmd(conn resp.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		errReply := reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		conn.AddTxError(errReply)
+		return errReply
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		errReply := reply.MakeArgNumErrReply(cmdName)
+		conn.AddTxError(errReply)
+		return errReply
+	}
+	conn.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// DiscardMulti drops MULTI pending commands
+func DiscardMulti(conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	conn.SetMultiState(false) // clean queue and watching
+	return reply.MakeOkReply()
+}
+
+// execMulti executes queued commands of the connection
+func execMulti(db *DB, conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer conn.SetMultiState(false)
+	if len(conn.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	cmdLines := conn.GetQueuedCmdLine()
+	return db.ExecMulti(conn.GetWatching(), cmdLines)
+}
+
+// ExecMulti executes multi commands transaction Atomically
+// returns a null multi bulk reply if any watched key has been changed
+func (db *DB) ExecMulti(watching map[string]uint32, cmdLines []CmdLine) resp.Reply {
+	// prepare
+	writeKeys := make([]string, 0) // may contains duplicate
+	readKeys := make([]string, 0)
+	for _, cmdLine := range cmdLines {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		cmd := cmdTable[cmdName] // validated while queuing
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	// set watch
+	watchingKeys := make([]string, 0, len(watching))
+	for key := range watching {
+		watchingKeys = append(watchingKeys, key)
+	}
+	readKeys = append(readKeys, watchingKeys...)
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+
+	if isWatchingChanged(db, watching) { // watching keys changed, abort
+		return reply.MakeNullMultiBulkReply()
+	}
+	// execute
+	results := make([]resp.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		result := db.execWithLock(cmdLine)
+		results = append(results, result)
+	}
+	db.addVersion(writeKeys...)
+	return reply.MakeMultiRawReply(results)
+}
+
+// Watch set watching keys
+func Watch(db *DB, conn resp.Connection, args [][]byte) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	watching := conn.GetWatching()
+	keys := make([]string, 0, len(args))
+	for _, bkey := range args {
+		keys = append(keys, string(bkey))
+	}
+	// versions are changed by writers holding the key lock, so read them under the lock too
+	db.RWLocks(nil, keys)
+	defer db.RWUnLocks(nil, keys)
+	for _, key := range keys {
+		db.IsExpired(key) // a key expired before WATCH is not a change
+		watching[key] = db.GetVersion(key)
+	}
+	return reply.MakeOkReply()
+}
+
+// UnWatch cancels all watching keys of the connection
+func UnWatch(conn resp.Connection) resp.Reply {
+	watching := conn.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return reply.MakeOkReply()
+}
+
+func isWatchingChanged(db *DB, watching map[string]uint32) bool {
+	for key, ver := range watching {
+		db.IsExpired(key) // expire lazily so that an expired key counts as changed
+		currentVersion := db.GetVersion(key)
+		if ver != currentVersion {
+			return true
+		}
+	}
+	return false
+}
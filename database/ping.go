@@ -11,5 +11,5 @@ func Ping(db *DB, args [][]byte) resp.Reply {
 
 //特殊关键字，在这个包开始运行的时候就会被定义
 func init() {
-	RegisterCommand("ping", Ping, 1)
+	RegisterCommand("ping", Ping, noPrepare, 1)
 }
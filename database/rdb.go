@@ -0,0 +1,322 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"go_redis_write/config"
+	Dict "go_redis_write/datastruct/dict"
+	List "go_redis_write/datastruct/list"
+	HashSet "go_redis_write/datastruct/set"
+	SortedSet "go_redis_write/datastruct/sortedset"
+	databaseface "go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/rdb"
+	"go_redis_write/resp/reply"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//RDB快照：SAVE/BGSAVE把所有db写到dbfilename，没有开启AOF时启动会加载这个文件
+
+const saveCronInterval = time.Second
+
+// errSaving is returned when another background save is in progress
+var errSaving = errors.New("ERR Background save already in progress")
+
+// saveRule means save rdb if at least `changes` writes happened in `seconds`
+type saveRule struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveRules parses rules like "900 1 300 10", empty string means no rule
+func parseSaveRules(raw string) []saveRule {
+	fields := strings.Fields(strings.Trim(raw, "\""))
+	if len(fields)%2 != 0 {
+		logger.Warn("invalid save rules: " + raw)
+		return nil
+	}
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes < 0 {
+			logger.Warn("invalid save rules: " + raw)
+			return nil
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules
+}
+
+// frozenEntity is a copy of a key taken by snapshot
+type frozenEntity struct {
+	key        string
+	entity     *databaseface.DataEntity
+	expiration *time.Time
+}
+
+// frozenDBs holds copies of all dbs, it implements rdb.Source
+type frozenDBs [][]*frozenEntity
+
+// ForEach traverses the copied keys of the given db
+func (dbs frozenDBs) ForEach(dbIndex int, cb func(key string, data *databaseface.DataEntity, expiration *time.Time) bool) {
+	for _, e := range dbs[dbIndex] {
+		if !cb(e.key, e.entity, e.expiration) {
+			return
+		}
+	}
+}
+
+// snapshot encodes all dbs into rdb, writing is blocked only while copying, not while encoding
+// returns the rdb and the number of changes included in it.
+// afterDump is called before unblocking writing if not nil, so no write happens between the snapshot and it
+func (database *StandaloneDatabase) snapshot(afterDump func()) ([]byte, int64, error) {
+	frozen, dirty := database.freeze(afterDump)
+	buf := &bytes.Buffer{}
+	err := rdb.Dump(buf, frozen, len(frozen))
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), dirty, nil
+}
+
+// freeze copies dbs while writing is blocked, like the copy-on-write of redis fork.
+// without afterCopy dbs are copied one by one and only the db being copied blocks writing,
+// with afterCopy all dbs are blocked together, so afterCopy sees exactly the copied state
+func (database *StandaloneDatabase) freeze(afterCopy func()) (frozenDBs, int64) {
+	frozen := make(frozenDBs, len(database.dbSet))
+	if afterCopy == nil {
+		//先读计数再复制，复制期间的写入可能已经包含在快照里，但仍留在计数中，最多导致多存一次
+		dirty := atomic.LoadInt64(&database.dirty)
+		for i, db := range database.dbSet {
+			db.locker.RLockAll()
+			frozen[i] = copyDB(db)
+			db.locker.RUnLockAll()
+		}
+		return frozen, dirty
+	}
+	for _, db := range database.dbSet {
+		db.locker.RLockAll()
+	}
+	defer func() {
+		for i := len(database.dbSet) - 1; i >= 0; i-- {
+			database.dbSet[i].locker.RUnLockAll()
+		}
+	}()
+	dirty := atomic.LoadInt64(&database.dirty)
+	for i, db := range database.dbSet {
+		frozen[i] = copyDB(db)
+	}
+	afterCopy()
+	return frozen, dirty
+}
+
+// copyDB copies all keys of db, the caller should block writing of db
+func copyDB(db *DB) []*frozenEntity {
+	entities := make([]*frozenEntity, 0, db.data.Len())
+	db.ForEach(func(key string, entity *databaseface.DataEntity, expiration *time.Time) bool {
+		entities = append(entities, &frozenEntity{
+			key:        key,
+			entity:     copyEntity(entity),
+			expiration: expiration,
+		})
+		return true
+	})
+	return entities
+}
+
+// copyEntity copies the container of a value, so writing after freeze won't change the copy.
+// elements of list, hash are shared because commands replace them instead of modifying them,
+// but strings are copied since SETRANGE modifies them in place
+func copyEntity(entity *databaseface.DataEntity) *databaseface.DataEntity {
+	switch val := entity.Data.(type) {
+	case []byte:
+		return &databaseface.DataEntity{Data: append(make([]byte, 0, len(val)), val...)}
+	case List.List:
+		list := List.NewQuickList()
+		val.ForEach(func(i int, v interface{}) bool {
+			list.Add(v)
+			return true
+		})
+		return &databaseface.DataEntity{Data: list}
+	case Dict.Dict:
+		hash := Dict.MakeSimple()
+		val.ForEach(func(field string, v interface{}) bool {
+			hash.Put(field, v)
+			return true
+		})
+		return &databaseface.DataEntity{Data: hash}
+	case *HashSet.Set:
+		return &databaseface.DataEntity{Data: HashSet.Make().Union(val)}
+	case *SortedSet.SortedSet:
+		zset := SortedSet.Make()
+		val.ForEach(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			zset.Add(element.Member, element.Score)
+			return true
+		})
+		return &databaseface.DataEntity{Data: zset}
+	}
+	return entity
+}
+
+// writeRDBFile writes data into a temp file and renames it to dbfilename, so the old file is intact if failed
+func writeRDBFile(data []byte) error {
+	filename := config.Properties.DBFilename
+	file, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	err = os.Rename(file.Name(), filename)
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
+
+// afterSave resets changes counter and records save time
+func (database *StandaloneDatabase) afterSave(dirty int64) {
+	atomic.AddInt64(&database.dirty, -dirty)
+	atomic.StoreInt64(&database.lastSave, time.Now().Unix())
+}
+
+// Save saves rdb synchronously
+func (database *StandaloneDatabase) Save() error {
+	if atomic.LoadInt32(&database.saving) == 1 {
+		return errSaving
+	}
+	data, dirty, err := database.snapshot(nil)
+	if err != nil {
+		return err
+	}
+	err = writeRDBFile(data)
+	if err != nil {
+		return err
+	}
+	database.afterSave(dirty)
+	return nil
+}
+
+// BGSave takes a snapshot and writes it to disk in background, the caller isn't blocked by copying dbs
+func (database *StandaloneDatabase) BGSave() error {
+	if !atomic.CompareAndSwapInt32(&database.saving, 0, 1) {
+		return errSaving
+	}
+	database.saveWait.Add(1)
+	go func() {
+		defer database.saveWait.Done()
+		defer atomic.StoreInt32(&database.saving, 0)
+		data, dirty, err := database.snapshot(nil)
+		if err == nil {
+			err = writeRDBFile(data)
+		}
+		if err != nil {
+			logger.Error("background saving failed: " + err.Error())
+			return
+		}
+		database.afterSave(dirty)
+		logger.Info("background saving finished")
+	}()
+	return nil
+}
+
+// loadRDB loads dbfilename into dbs, it does nothing if the file doesn't exist
+func (database *StandaloneDatabase) loadRDB() error {
+	file, err := os.Open(config.Properties.DBFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var loadErr error
+	decoder := rdb.NewDecoder(file)
+	err = decoder.Parse(func(dbIndex int, key string, entity *databaseface.DataEntity, expiration *time.Time) bool {
+		loadErr = database.LoadEntity(dbIndex, key, entity, expiration)
+		return loadErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return loadErr
+}
+
+// startSaveCron checks save rules periodically and starts BGSAVE when any rule is satisfied
+func (database *StandaloneDatabase) startSaveCron() {
+	stop := make(chan struct{})
+	database.stopSaveCron = stop
+	go func() {
+		ticker := time.NewTicker(saveCronInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				database.checkSaveRules()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (database *StandaloneDatabase) checkSaveRules() {
+	if atomic.LoadInt32(&database.saving) == 1 {
+		return
+	}
+	dirty := atomic.LoadInt64(&database.dirty)
+	elapsed := time.Now().Unix() - atomic.LoadInt64(&database.lastSave)
+	for _, rule := range database.saveRules {
+		if dirty >= rule.changes && elapsed >= rule.seconds {
+			logger.Info(strconv.FormatInt(rule.changes, 10) + " changes in " +
+				strconv.FormatInt(rule.seconds, 10) + " seconds. Saving...")
+			err := database.BGSave()
+			if err != nil && err != errSaving {
+				logger.Error("background saving failed: " + err.Error())
+			}
+			return
+		}
+	}
+}
+
+// execSave saves rdb synchronously
+func (database *StandaloneDatabase) execSave() resp.Reply {
+	err := database.Save()
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// execBGSave saves rdb in background
+func (database *StandaloneDatabase) execBGSave() resp.Reply {
+	err := database.BGSave()
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background saving started")
+}
+
+// execLastSave returns the unix time of last successful save
+func (database *StandaloneDatabase) execLastSave() resp.Reply {
+	return reply.MakeIntReply(atomic.LoadInt64(&database.lastSave))
+}
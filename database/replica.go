@@ -0,0 +1,348 @@
+package database
+
+import (
+	"errors"
+	"go_redis_write/aof"
+	"go_redis_write/config"
+	databaseface "go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/rdb"
+	"go_redis_write/resp/client"
+	"go_redis_write/resp/connection"
+	"go_redis_write/resp/reply"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//主从复制-从节点：REPLICAOF后连接主节点，发送PSYNC进行全量同步，加载主节点发来的rdb快照，
+//然后执行主节点转发过来的写命令。从节点默认是只读的，只有主节点发来的命令可以写入
+
+const (
+	// replRetryInterval is the interval of reconnecting to master
+	replRetryInterval = time.Second
+	// replAckInterval is how often replica reports its offset to master
+	replAckInterval = time.Second
+)
+
+// roles of this node
+const (
+	roleMaster int32 = iota
+	roleSlave
+)
+
+// states of the link to master, reported by ROLE
+const (
+	replStateConnect    = "connect"    // waiting to connect
+	replStateConnecting = "connecting" // handshaking
+	replStateSync       = "sync"       // receiving snapshot
+	replStateConnected  = "connected"  // receiving command stream
+)
+
+// masterConn executes commands sent by master, which bypass the read only check of replica
+type masterConn struct {
+	connection.FakeConn
+}
+
+// slaveStatus holds the replication link to master
+type slaveStatus struct {
+	mu         sync.Mutex
+	masterHost string
+	masterPort int
+	state      string
+	// nil if not connected
+	client *client.ReplClient
+	// closed to stop replication
+	stop    chan struct{}
+	stopped bool
+	done    sync.WaitGroup
+	replID  string
+	// processed bytes of command stream
+	offset int64
+	// unix time of last data received from master
+	lastIO int64
+}
+
+func (s *slaveStatus) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+// setClient records the connection to master so it can be closed by REPLICAOF NO ONE
+// it returns false if replication has been stopped
+func (s *slaveStatus) setClient(cli *client.ReplClient) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.client = cli
+	return true
+}
+
+func (s *slaveStatus) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+func (database *StandaloneDatabase) getSlaveStatus() *slaveStatus {
+	database.replMu.Lock()
+	defer database.replMu.Unlock()
+	return database.slave
+}
+
+// isReadOnlyReplica returns true if writing of normal clients should be refused
+func (database *StandaloneDatabase) isReadOnlyReplica(c resp.Connection) bool {
+	if atomic.LoadInt32(&database.role) != roleSlave || !config.Properties.ReplicaReadOnly {
+		return false
+	}
+	_, fromMaster := c.(*masterConn)
+	return !fromMaster
+}
+
+// execReplicaOf makes this node a replica of the given master, or a master if args are NO ONE
+func (database *StandaloneDatabase) execReplicaOf(args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply(string(args[0]))
+	}
+	host := string(args[1])
+	portStr := string(args[2])
+	if strings.EqualFold(host, "no") && strings.EqualFold(portStr, "one") {
+		if database.stopReplication() {
+			logger.Info("MASTER MODE enabled")
+		}
+		return reply.MakeOkReply()
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid master port")
+	}
+	if s := database.getSlaveStatus(); s != nil && s.masterHost == host && s.masterPort == port {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	database.stopReplication()
+	database.startReplication(host, port)
+	return reply.MakeOkReply()
+}
+
+// startReplication connects to master in background, and reconnects if the link is broken
+func (database *StandaloneDatabase) startReplication(host string, port int) {
+	s := &slaveStatus{
+		masterHost: host,
+		masterPort: port,
+		state:      replStateConnect,
+		stop:       make(chan struct{}),
+	}
+	database.replMu.Lock()
+	database.slave = s
+	atomic.StoreInt32(&database.role, roleSlave)
+	database.replMu.Unlock()
+	logger.Info("connecting to MASTER " + net.JoinHostPort(host, strconv.Itoa(port)))
+
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		for {
+			err := database.syncWithMaster(s)
+			if s.isStopped() {
+				return
+			}
+			logger.Warn("replication with master failed: " + err.Error())
+			s.setState(replStateConnect)
+			select {
+			case <-time.After(replRetryInterval):
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopReplication disconnects from master, it returns false if this node is not a replica
+func (database *StandaloneDatabase) stopReplication() bool {
+	database.replMu.Lock()
+	s := database.slave
+	database.slave = nil
+	atomic.StoreInt32(&database.role, roleMaster)
+	database.replMu.Unlock()
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	s.stopped = true
+	close(s.stop)
+	if s.client != nil {
+		_ = s.client.Close()
+	}
+	s.mu.Unlock()
+	s.done.Wait() // no command from master will be executed after returning
+	return true
+}
+
+// syncWithMaster does handshake and full resynchronization, then executes commands from master until the link is broken
+func (database *StandaloneDatabase) syncWithMaster(s *slaveStatus) error {
+	s.setState(replStateConnecting)
+	timeout := time.Duration(config.Properties.ReplTimeout) * time.Second
+	cli, err := client.MakeReplClient(net.JoinHostPort(s.masterHost, strconv.Itoa(s.masterPort)))
+	if err != nil {
+		return err
+	}
+	if !s.setClient(cli) {
+		_ = cli.Close()
+		return errors.New("replication stopped")
+	}
+	defer func() {
+		s.setClient(nil)
+		_ = cli.Close()
+	}()
+
+	_, err = cli.Request(timeout, "PING")
+	if err != nil {
+		return err
+	}
+	_, err = cli.Request(timeout, "REPLCONF", "listening-port", strconv.Itoa(config.Properties.Port))
+	if err != nil {
+		return err
+	}
+	line, err := cli.Request(timeout, "PSYNC", "?", "-1")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return errors.New("unexpected reply of PSYNC: " + line)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return errors.New("unexpected reply of PSYNC: " + line)
+	}
+
+	s.setState(replStateSync)
+	snapshot, size, err := cli.ReadSnapshot(timeout)
+	if err != nil {
+		return err
+	}
+	err = database.loadFromMaster(snapshot)
+	if err != nil {
+		return err
+	}
+	logger.Info("MASTER <-> REPLICA sync: finished with success, loaded " + strconv.FormatInt(size, 10) + " bytes")
+
+	s.mu.Lock()
+	s.replID = fields[1]
+	s.state = replStateConnected
+	s.mu.Unlock()
+	atomic.StoreInt64(&s.offset, offset)
+	atomic.StoreInt64(&s.lastIO, time.Now().Unix())
+	return database.receiveCommands(s, cli, timeout)
+}
+
+// loadFromMaster replaces all data with the snapshot sent by master
+// loaded data is written into aof as commands, so it survives restart
+func (database *StandaloneDatabase) loadFromMaster(snapshot io.Reader) error {
+	for _, db := range database.dbSet {
+		db.locker.LockAll()
+	}
+	defer func() {
+		for i := len(database.dbSet) - 1; i >= 0; i-- {
+			database.dbSet[i].locker.UnLockAll()
+		}
+	}()
+	for _, db := range database.dbSet {
+		db.Flush()
+		db.addAof(utils.ToCmdLine("flushdb"))
+	}
+	var loadErr error
+	decoder := rdb.NewDecoder(snapshot)
+	err := decoder.Parse(func(dbIndex int, key string, entity *databaseface.DataEntity, expiration *time.Time) bool {
+		if expiration != nil && time.Now().After(*expiration) {
+			return true
+		}
+		loadErr = database.LoadEntity(dbIndex, key, entity, expiration)
+		if loadErr != nil {
+			return false
+		}
+		db := database.dbSet[dbIndex]
+		if cmd := aof.EntityToCmd(key, entity); cmd != nil {
+			db.addAof(cmd)
+		}
+		if expiration != nil {
+			db.addAof(aof.MakeExpireCmd(key, *expiration))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if loadErr != nil {
+		return loadErr
+	}
+	_, err = io.Copy(ioutil.Discard, snapshot) // command stream begins after the whole snapshot
+	return err
+}
+
+// receiveCommands executes commands sent by master and reports offset to master periodically
+func (database *StandaloneDatabase) receiveCommands(s *slaveStatus, cli *client.ReplClient, timeout time.Duration) error {
+	stopAck := make(chan struct{})
+	defer close(stopAck)
+	go func() {
+		ticker := time.NewTicker(replAckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = cli.Send("REPLCONF", "ACK", strconv.FormatInt(atomic.LoadInt64(&s.offset), 10))
+			case <-stopAck:
+				return
+			}
+		}
+	}()
+
+	conn := &masterConn{}
+	_ = cli.SetReadDeadline(time.Now().Add(timeout))
+	ch := cli.CommandStream()
+	defer func() {
+		_ = cli.Close()
+		for range ch { // wait for the parser to exit
+		}
+	}()
+	for payload := range ch {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		_ = cli.SetReadDeadline(time.Now().Add(timeout))
+		atomic.StoreInt64(&s.lastIO, time.Now().Unix())
+		r, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok {
+			return errors.New("require multi bulk reply from master")
+		}
+		ret := database.Exec(conn, r.Args)
+		if reply.IsErrorReply(ret) {
+			logger.Warn("exec command from master failed: " + string(ret.ToBytes()))
+		}
+		atomic.AddInt64(&s.offset, int64(len(r.ToBytes())))
+	}
+	return errors.New("connection closed by master")
+}
+
+// parseReplicaOf parses "host port" in config
+func parseReplicaOf(raw string) (string, int, bool) {
+	fields := strings.Fields(raw)
+	if len(fields) != 2 {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[0], port, true
+}
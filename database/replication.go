@@ -0,0 +1,379 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go_redis_write/config"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/logger"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//主从复制-主节点：从节点发送PSYNC后，主节点生成rdb快照发给它，之后把每个写命令（也就是addAof收到的命令）转发给所有从节点
+//所有从节点共享同一个命令流，master_repl_offset是这个命令流的字节数
+
+const (
+	// replicaQueueSize is the max number of pending writes of a replica, slower replica is disconnected
+	replicaQueueSize = 1 << 16
+	// replPingPeriod is how often master pings replicas, so replicas can detect broken link by timeout
+	replPingPeriod = 10 * time.Second
+)
+
+// states of replica seen by master
+const (
+	replicaStateSendBulk = "send_bulk" // sending snapshot
+	replicaStateOnline   = "online"    // streaming commands
+)
+
+// replicaClient is a replica connected to this node
+type replicaClient struct {
+	conn          resp.Connection
+	listeningPort int
+	// command stream waiting to be sent
+	queue     chan []byte
+	state     string
+	ackOffset int64
+	ackTime   time.Time
+}
+
+// masterStatus holds replicas of this node
+type masterStatus struct {
+	mu     sync.Mutex
+	replID string
+	// bytes of command stream sent to replicas
+	offset int64
+	// selected db of command stream, -1 means select before next command
+	streamDB int
+	replicas map[resp.Connection]*replicaClient
+	// reported by REPLCONF listening-port before PSYNC
+	listeningPorts map[resp.Connection]int
+	// number of replicas, checked without lock before feeding
+	count    int32
+	stopPing chan struct{}
+}
+
+func makeMasterStatus() *masterStatus {
+	return &masterStatus{
+		replID:         makeReplID(),
+		streamDB:       -1,
+		replicas:       make(map[resp.Connection]*replicaClient),
+		listeningPorts: make(map[resp.Connection]int),
+	}
+}
+
+// makeReplID generates a random id of 40 hex characters
+func makeReplID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// feed sends a write command to all replicas
+func (m *masterStatus) feed(dbIndex int, cmdLine CmdLine) {
+	if atomic.LoadInt32(&m.count) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.replicas) == 0 {
+		return
+	}
+	if dbIndex != m.streamDB {
+		m.broadcast(reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes())
+		m.streamDB = dbIndex
+	}
+	m.broadcast(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+}
+
+// broadcast appends data to command stream, invoker should hold m.mu
+func (m *masterStatus) broadcast(data []byte) {
+	m.offset += int64(len(data))
+	for conn, r := range m.replicas {
+		select {
+		case r.queue <- data:
+		default:
+			logger.Warn("replica " + replicaAddr(r) + " is too slow, disconnect it")
+			m.removeReplica(conn)
+			closeConn(conn)
+		}
+	}
+}
+
+// removeReplica stops streaming to the replica, invoker should hold m.mu
+func (m *masterStatus) removeReplica(conn resp.Connection) {
+	r, ok := m.replicas[conn]
+	if !ok {
+		return
+	}
+	delete(m.replicas, conn)
+	close(r.queue)
+	atomic.AddInt32(&m.count, -1)
+}
+
+// sendToReplica writes command stream to replica until it is removed
+func (m *masterStatus) sendToReplica(r *replicaClient) {
+	for data := range r.queue {
+		err := r.conn.Write(data)
+		if err != nil {
+			logger.Warn("write to replica " + replicaAddr(r) + " failed: " + err.Error())
+			m.mu.Lock()
+			if m.replicas[r.conn] == r {
+				m.removeReplica(r.conn)
+			}
+			m.mu.Unlock()
+			closeConn(r.conn)
+			return
+		}
+	}
+}
+
+// startPing pings replicas through command stream periodically
+func (m *masterStatus) startPing() {
+	stop := make(chan struct{})
+	m.stopPing = stop
+	go func() {
+		ticker := time.NewTicker(replPingPeriod)
+		defer ticker.Stop()
+		ping := reply.MakeMultiBulkReply(utils.ToCmdLine("PING")).ToBytes()
+		for {
+			select {
+			case <-ticker.C:
+				m.mu.Lock()
+				if len(m.replicas) > 0 {
+					m.broadcast(ping)
+				}
+				m.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *masterStatus) close() {
+	if m.stopPing != nil {
+		close(m.stopPing)
+		m.stopPing = nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn := range m.replicas {
+		m.removeReplica(conn)
+	}
+}
+
+// onClientClose forgets the replica on the closed connection
+func (m *masterStatus) onClientClose(c resp.Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.listeningPorts, c)
+	m.removeReplica(c)
+}
+
+// closeConn disconnects the client, it doesn't block the invoker
+func closeConn(c resp.Connection) {
+	if closer, ok := c.(interface{ Close() error }); ok {
+		go func() {
+			_ = closer.Close()
+		}()
+	}
+}
+
+func replicaAddr(r *replicaClient) string {
+	ip := "?"
+	if conn, ok := r.conn.(interface{ RemoteAddr() net.Addr }); ok {
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			ip = addr.IP.String()
+		}
+	}
+	return ip + ":" + strconv.Itoa(r.listeningPort)
+}
+
+// execPSync sends a snapshot to replica, then streams commands executed after the snapshot
+// only full resynchronization is supported, so the replication id and offset requested by replica are ignored
+func (database *StandaloneDatabase) execPSync(c resp.Connection) resp.Reply {
+	m := database.master
+	r := &replicaClient{
+		conn:  c,
+		queue: make(chan []byte, replicaQueueSize),
+		state: replicaStateSendBulk,
+	}
+	var offset int64
+	data, _, err := database.snapshot(func() {
+		// register replica before unblocking writing, so writes after snapshot will be streamed to it
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.removeReplica(c) // PSYNC again on the same connection
+		r.listeningPort = m.listeningPorts[c]
+		m.replicas[c] = r
+		atomic.AddInt32(&m.count, 1)
+		m.streamDB = -1
+		offset = m.offset
+	})
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	header := "+FULLRESYNC " + m.replID + " " + strconv.FormatInt(offset, 10) + reply.CRLF +
+		"$" + strconv.Itoa(len(data)) + reply.CRLF // rdb is not terminated by CRLF
+	err = c.Write(append([]byte(header), data...))
+	if err != nil {
+		m.onClientClose(c)
+		return &reply.NoReply{}
+	}
+	m.mu.Lock()
+	r.state = replicaStateOnline
+	r.ackTime = time.Now()
+	m.mu.Unlock()
+	logger.Info("synchronization with replica " + replicaAddr(r) + " succeeded")
+	go m.sendToReplica(r)
+	return &reply.NoReply{}
+}
+
+// execReplConf records information reported by replica
+func (database *StandaloneDatabase) execReplConf(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	m := database.master
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		value := string(args[i+1])
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return reply.MakeErrReply("ERR invalid port")
+			}
+			m.mu.Lock()
+			m.listeningPorts[c] = port
+			m.mu.Unlock()
+		case "ack": // replica reports processed offset, no reply
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return &reply.NoReply{}
+			}
+			m.mu.Lock()
+			if r, ok := m.replicas[c]; ok {
+				r.ackOffset = offset
+				r.ackTime = time.Now()
+			}
+			m.mu.Unlock()
+			return &reply.NoReply{}
+		} // other options like capa are ignored
+	}
+	return reply.MakeOkReply()
+}
+
+// execRole returns role of this node, its replicas or its master
+func (database *StandaloneDatabase) execRole() resp.Reply {
+	if s := database.getSlaveStatus(); s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("slave")),
+			reply.MakeBulkReply([]byte(s.masterHost)),
+			reply.MakeIntReply(int64(s.masterPort)),
+			reply.MakeBulkReply([]byte(s.state)),
+			reply.MakeIntReply(atomic.LoadInt64(&s.offset)),
+		})
+	}
+	m := database.master
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replicas := make([]resp.Reply, 0, len(m.replicas))
+	for _, r := range m.replicas {
+		addr := replicaAddr(r)
+		sep := strings.LastIndex(addr, ":")
+		replicas = append(replicas, reply.MakeMultiBulkReply([][]byte{
+			[]byte(addr[:sep]),
+			[]byte(addr[sep+1:]),
+			[]byte(strconv.FormatInt(r.ackOffset, 10)),
+		}))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("master")),
+		reply.MakeIntReply(m.offset),
+		reply.MakeMultiRawReply(replicas),
+	})
+}
+
+// replicationInfo returns the replication section of INFO
+func (database *StandaloneDatabase) replicationInfo() string {
+	var lines []string
+	if s := database.getSlaveStatus(); s != nil {
+		s.mu.Lock()
+		linkStatus := "down"
+		if s.state == replStateConnected {
+			linkStatus = "up"
+		}
+		syncing := 0
+		if s.state == replStateSync {
+			syncing = 1
+		}
+		lastIO := -1
+		if last := atomic.LoadInt64(&s.lastIO); last > 0 {
+			lastIO = int(time.Now().Unix() - last)
+		}
+		lines = append(lines,
+			"role:slave",
+			"master_host:"+s.masterHost,
+			"master_port:"+strconv.Itoa(s.masterPort),
+			"master_link_status:"+linkStatus,
+			"master_last_io_seconds_ago:"+strconv.Itoa(lastIO),
+			"master_sync_in_progress:"+strconv.Itoa(syncing),
+			"slave_repl_offset:"+strconv.FormatInt(atomic.LoadInt64(&s.offset), 10),
+			"slave_read_only:"+boolToInfo(config.Properties.ReplicaReadOnly),
+		)
+		s.mu.Unlock()
+	} else {
+		lines = append(lines, "role:master")
+	}
+	m := database.master
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lines = append(lines, "connected_slaves:"+strconv.Itoa(len(m.replicas)))
+	i := 0
+	for _, r := range m.replicas {
+		addr := replicaAddr(r)
+		sep := strings.LastIndex(addr, ":")
+		lines = append(lines, "slave"+strconv.Itoa(i)+":ip="+addr[:sep]+",port="+addr[sep+1:]+
+			",state="+r.state+",offset="+strconv.FormatInt(r.ackOffset, 10)+
+			",lag="+strconv.FormatInt(int64(time.Since(r.ackTime)/time.Second), 10))
+		i++
+	}
+	lines = append(lines,
+		"master_replid:"+m.replID,
+		"master_repl_offset:"+strconv.FormatInt(m.offset, 10),
+	)
+	return "# Replication\r\n" + strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// execInfo returns information of this node, only replication section is supported now
+func (database *StandaloneDatabase) execInfo(args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	section := "default"
+	if len(args) == 2 {
+		section = strings.ToLower(string(args[1]))
+	}
+	switch section {
+	case "default", "all", "everything", "replication":
+		return reply.MakeBulkReply([]byte(database.replicationInfo()))
+	}
+	return reply.MakeBulkReply([]byte{})
+}
@@ -0,0 +1,485 @@
+package database
+
+import (
+	HashSet "go_redis_write/datastruct/set"
+	"go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"strconv"
+	"strings"
+)
+
+//SADD SREM SCARD SISMEMBER SMISMEMBER SMEMBERS
+//SPOP SRANDMEMBER SMOVE
+//SINTER SUNION SDIFF SINTERSTORE SUNIONSTORE SDIFFSTORE SINTERCARD
+
+func (db *DB) getAsSet(key string) (*HashSet.Set, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	set, ok := entity.Data.(*HashSet.Set)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return set, nil
+}
+
+func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply reply.ErrorReply) {
+	set, errReply = db.getAsSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if set == nil {
+		set = HashSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: set,
+		})
+		inited = true
+	}
+	return set, inited, nil
+}
+
+//SADD k1 m1 m2  返回新加入的成员个数
+// execSAdd adds members into set
+func execSAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, _, errReply := db.getOrInitSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	counter := 0
+	for _, member := range members {
+		counter += set.Add(string(member))
+	}
+	db.addAof(utils.ToCmdLine2("sadd", args...))
+	return reply.MakeIntReply(int64(counter))
+}
+
+// execSIsMember checks if the given value is member of set
+func execSIsMember(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	if set.Has(member) {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+//SMISMEMBER k1 m1 m2  返回 [1, 0] 这种
+// execSMIsMember checks if the given values are members of set
+func execSMIsMember(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := make([]resp.Reply, len(members))
+	for i, member := range members {
+		if set != nil && set.Has(string(member)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+//SREM k1 m1 m2
+// execSRem removes a member from set
+func execSRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	counter := 0
+	for _, member := range members {
+		counter += set.Remove(string(member))
+	}
+	if set.Len() == 0 { //set空了就把key删掉
+		db.Remove(key)
+	}
+	if counter > 0 {
+		db.addAof(utils.ToCmdLine2("srem", args...))
+	}
+	return reply.MakeIntReply(int64(counter))
+}
+
+//SPOP k1 [count]
+// execSPop removes one or more random members from set
+func execSPop(db *DB, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("spop")
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	count := 1
+	if withCount {
+		c, err := strconv.Atoi(string(args[1]))
+		if err != nil || c < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = c
+	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		if withCount {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	members := set.RandomDistinctMembers(count)
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		set.Remove(member)
+		result[i] = []byte(member)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(result) > 0 {
+		// 随机的结果重放时不一样，所以aof里记录被删掉的成员
+		db.addAof(utils.ToCmdLine2("srem", append([][]byte{args[0]}, result...)...))
+	}
+	if !withCount {
+		return reply.MakeBulkReply(result[0])
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execSCard gets the number of members in a set
+func execSCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(set.Len()))
+}
+
+// execSMembers gets all members in a set
+func execSMembers(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	arr := make([][]byte, 0, set.Len())
+	set.ForEach(func(member string) bool {
+		arr = append(arr, []byte(member))
+		return true
+	})
+	return reply.MakeMultiBulkReply(arr)
+}
+
+//SRANDMEMBER k1 [count]  count > 0 时不重复，count < 0 时允许重复
+// execSRandMember gets random members from set
+func execSRandMember(db *DB, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("srandmember")
+	}
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if set == nil {
+			return &reply.NullBulkReply{}
+		}
+		members := set.RandomMembers(1)
+		return reply.MakeBulkReply([]byte(members[0]))
+	}
+
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if set == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var members []string
+	if count >= 0 {
+		members = set.RandomDistinctMembers(count)
+	} else {
+		members = set.RandomMembers(-count)
+	}
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func prepareSMove(args [][]byte) ([]string, []string) {
+	src := string(args[0])
+	dest := string(args[1])
+	return []string{src, dest}, nil
+}
+
+//SMOVE src dest m1
+// execSMove moves a member from one set to another
+func execSMove(db *DB, args [][]byte) resp.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	if src == dest { //源和目标是同一个集合时什么都不用做，否则删掉最后一个成员时会把key删掉
+		return reply.MakeIntReply(1)
+	}
+
+	srcSet.Remove(member)
+	if srcSet.Len() == 0 {
+		db.Remove(src)
+	}
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(dest)
+	}
+	destSet.Add(member)
+	db.addAof(utils.ToCmdLine2("smove", args...))
+	return reply.MakeIntReply(1)
+}
+
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+// setOperation computes intersection, union or difference of the given sets
+func (db *DB) setOperation(keys []string, op int) (*HashSet.Set, reply.ErrorReply) {
+	var result *HashSet.Set
+	for i, key := range keys {
+		set, errReply := db.getAsSet(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if set == nil {
+			if op == setInter || (op == setDiff && i == 0) {
+				// 交集里有一个空集，或者被减的是空集，结果就是空集
+				return HashSet.Make(), nil
+			}
+			continue
+		}
+		if result == nil {
+			result = HashSet.Make().Union(set) // copy the first set
+			continue
+		}
+		switch op {
+		case setInter:
+			result = result.Intersect(set)
+		case setUnion:
+			result = result.Union(set)
+		case setDiff:
+			result = result.Diff(set)
+		}
+	}
+	if result == nil {
+		return HashSet.Make(), nil
+	}
+	return result, nil
+}
+
+func setToReply(set *HashSet.Set) resp.Reply {
+	if set.Len() == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	arr := make([][]byte, 0, set.Len())
+	set.ForEach(func(member string) bool {
+		arr = append(arr, []byte(member))
+		return true
+	})
+	return reply.MakeMultiBulkReply(arr)
+}
+
+func setOperationGeneric(db *DB, args [][]byte, op int) resp.Reply {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	result, errReply := db.setOperation(keys, op)
+	if errReply != nil {
+		return errReply
+	}
+	return setToReply(result)
+}
+
+// prepareSetCalculateStore writes dest which is args[0], and reads the rest source keys
+func prepareSetCalculateStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	return []string{dest}, keys
+}
+
+// setStoreGeneric stores the result of set operation into dest, which is args[0]
+func setStoreGeneric(db *DB, cmdName string, args [][]byte, op int) resp.Reply {
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	result, errReply := db.setOperation(keys, op)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.Remove(dest) // clean ttl too
+	if result.Len() > 0 {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+	}
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(int64(result.Len()))
+}
+
+//SINTER k1 k2 k3
+// execSInter intersect multiple sets
+func execSInter(db *DB, args [][]byte) resp.Reply {
+	return setOperationGeneric(db, args, setInter)
+}
+
+//SINTERSTORE dest k1 k2
+// execSInterStore intersects multiple sets and store the result in a key
+func execSInterStore(db *DB, args [][]byte) resp.Reply {
+	return setStoreGeneric(db, "sinterstore", args, setInter)
+}
+
+// execSUnion adds multiple sets
+func execSUnion(db *DB, args [][]byte) resp.Reply {
+	return setOperationGeneric(db, args, setUnion)
+}
+
+// execSUnionStore adds multiple sets and store the result in a key
+func execSUnionStore(db *DB, args [][]byte) resp.Reply {
+	return setStoreGeneric(db, "sunionstore", args, setUnion)
+}
+
+// execSDiff subtracts multiple sets
+func execSDiff(db *DB, args [][]byte) resp.Reply {
+	return setOperationGeneric(db, args, setDiff)
+}
+
+// execSDiffStore subtracts multiple sets and store the result in a key
+func execSDiffStore(db *DB, args [][]byte) resp.Reply {
+	return setStoreGeneric(db, "sdiffstore", args, setDiff)
+}
+
+func prepareSInterCard(args [][]byte) ([]string, []string) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || len(args) < numKeys+1 {
+		return nil, nil // the executor will report the error
+	}
+	keys := make([]string, numKeys)
+	for i, arg := range args[1 : numKeys+1] {
+		keys[i] = string(arg)
+	}
+	return nil, keys
+}
+
+//SINTERCARD 2 k1 k2 [LIMIT 10]
+// execSInterCard returns the cardinality of the intersection of multiple sets
+func execSInterCard(db *DB, args [][]byte) resp.Reply {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if len(args) < numKeys+1 {
+		return reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	limit := 0 // 0 means unlimited
+	rest := args[numKeys+1:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return &reply.SyntaxErrReply{}
+		}
+		limit, err = strconv.Atoi(string(rest[1]))
+		if err != nil || limit < 0 {
+			return reply.MakeErrReply("ERR LIMIT can't be negative")
+		}
+	}
+
+	keys := make([]string, numKeys)
+	for i, arg := range args[1 : numKeys+1] {
+		keys[i] = string(arg)
+	}
+	result, errReply := db.setOperation(keys, setInter)
+	if errReply != nil {
+		return errReply
+	}
+	card := result.Len()
+	if limit > 0 && card > limit {
+		card = limit
+	}
+	return reply.MakeIntReply(int64(card))
+}
+
+func init() {
+	RegisterCommand("SAdd", execSAdd, writeFirstKey, -3)
+	RegisterCommand("SIsMember", execSIsMember, readFirstKey, 3)
+	RegisterCommand("SMIsMember", execSMIsMember, readFirstKey, -3)
+	RegisterCommand("SRem", execSRem, writeFirstKey, -3)
+	RegisterCommand("SPop", execSPop, writeFirstKey, -2)
+	RegisterCommand("SCard", execSCard, readFirstKey, 2)
+	RegisterCommand("SMembers", execSMembers, readFirstKey, 2)
+	RegisterCommand("SRandMember", execSRandMember, readFirstKey, -2)
+	RegisterCommand("SMove", execSMove, prepareSMove, 4)
+	RegisterCommand("SInter", execSInter, readAllKeys, -2)
+	RegisterCommand("SInterStore", execSInterStore, prepareSetCalculateStore, -3)
+	RegisterCommand("SUnion", execSUnion, readAllKeys, -2)
+	RegisterCommand("SUnionStore", execSUnionStore, prepareSetCalculateStore, -3)
+	RegisterCommand("SDiff", execSDiff, readAllKeys, -2)
+	RegisterCommand("SDiffStore", execSDiffStore, prepareSetCalculateStore, -3)
+	RegisterCommand("SInterCard", execSInterCard, prepareSInterCard, -3)
+}
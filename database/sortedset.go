@@ -0,0 +1,927 @@
+package database
+
+import (
+	HashSet "go_redis_write/datastruct/set"
+	SortedSet "go_redis_write/datastruct/sortedset"
+	"go_redis_write/interface/database"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//ZADD ZSCORE ZINCRBY ZCARD ZREM
+//ZRANK ZREVRANK ZCOUNT ZLEXCOUNT
+//ZRANGE ZREVRANGE ZRANGEBYSCORE ZREVRANGEBYSCORE ZRANGEBYLEX ZREVRANGEBYLEX
+//ZPOPMIN ZPOPMAX
+//ZREMRANGEBYRANK ZREMRANGEBYSCORE ZREMRANGEBYLEX
+//ZUNIONSTORE ZINTERSTORE
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, inited bool, errReply reply.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: sortedSet,
+		})
+		inited = true
+	}
+	return sortedSet, inited, nil
+}
+
+// parseScore parses score argument, NaN is not allowed
+func parseScore(arg []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// formatScore formats score like redis, infinity is formatted as inf and -inf
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+const (
+	zaddUpsert = iota // default
+	zaddInsert        // NX
+	zaddUpdate        // XX
+)
+
+//ZADD k1 [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+// execZAdd adds member into sorted set
+func execZAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	policy := zaddUpsert
+	gt, lt, ch, incr := false, false, false, false
+	i := 1
+	// parse options
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NX" {
+			policy = zaddInsert
+		} else if option == "XX" {
+			policy = zaddUpdate
+		} else if option == "GT" {
+			gt = true
+		} else if option == "LT" {
+			lt = true
+		} else if option == "CH" {
+			ch = true
+		} else if option == "INCR" {
+			incr = true
+		} else {
+			break
+		}
+	}
+	for _, option := range args[1:i] {
+		opt := strings.ToUpper(string(option))
+		if (opt == "NX" && policy != zaddInsert) || (opt == "XX" && policy != zaddUpdate) {
+			return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+		}
+	}
+	if (gt && lt) || ((gt || lt) && policy == zaddInsert) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return &reply.SyntaxErrReply{}
+	}
+	if incr && len(pairs) != 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+
+	size := len(pairs) / 2
+	elements := make([]*SortedSet.Element, size)
+	for j := 0; j < size; j++ {
+		score, ok := parseScore(pairs[2*j])
+		if !ok {
+			return reply.MakeErrReply("ERR value is not a valid float")
+		}
+		elements[j] = &SortedSet.Element{
+			Member: string(pairs[2*j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		if policy == zaddUpdate { // XX never creates new key
+			if incr {
+				return &reply.NullBulkReply{}
+			}
+			return reply.MakeIntReply(0)
+		}
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	added, changed := 0, 0
+	var lastScore float64
+	aborted := false
+	for _, e := range elements {
+		old, exists := sortedSet.Get(e.Member)
+		if (exists && policy == zaddInsert) || (!exists && policy == zaddUpdate) {
+			aborted = true
+			continue
+		}
+		score := e.Score
+		if incr && exists {
+			score += old.Score
+			if math.IsNaN(score) {
+				return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists && ((gt && score <= old.Score) || (lt && score >= old.Score)) {
+			aborted = true
+			continue
+		}
+		lastScore = score
+		if sortedSet.Add(e.Member, score) {
+			added++
+		} else if old.Score != score {
+			changed++
+		}
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if added+changed > 0 {
+		db.addAof(utils.ToCmdLine2("zadd", args...))
+	}
+
+	if incr {
+		if aborted {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(formatScore(lastScore))
+	}
+	if ch {
+		return reply.MakeIntReply(int64(added + changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// execZScore gets score of a member in sortedset
+func execZScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+//ZINCRBY k1 2.5 m1
+// execZIncrBy increments the score of a member
+func execZIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, ok := parseScore(args[1])
+	if !ok {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	member := string(args[2])
+
+	sortedSet, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	score := delta
+	if element, exists := sortedSet.Get(member); exists {
+		score += element.Score
+	}
+	if math.IsNaN(score) {
+		if sortedSet.Len() == 0 {
+			db.Remove(key)
+		}
+		return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+	}
+	sortedSet.Add(member, score)
+	db.addAof(utils.ToCmdLine2("zincrby", args...))
+	return reply.MakeBulkReply(formatScore(score))
+}
+
+// execZCard gets number of members in sortedset
+func execZCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+//ZREM k1 m1 m2
+// execZRem removes given members
+func execZRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	var deleted int64 = 0
+	for _, field := range args[1:] {
+		if sortedSet.Remove(string(field)) {
+			deleted++
+		}
+	}
+	if sortedSet.Len() == 0 { //zset空了就把key删掉
+		db.Remove(key)
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("zrem", args...))
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+func rankGeneric(db *DB, args [][]byte, desc bool) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeIntReply(rank)
+}
+
+// execZRank gets index of a member in sortedset, ascending order, start from 0
+func execZRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// execZRevRank gets index of a member in sortedset, descending order, start from 0
+func execZRevRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, true)
+}
+
+func countGeneric(db *DB, key string, min SortedSet.Border, max SortedSet.Border) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Count(min, max))
+}
+
+//ZCOUNT k1 (1 +inf
+// execZCount gets number of members which score within given range
+func execZCount(db *DB, args [][]byte) resp.Reply {
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return countGeneric(db, string(args[0]), min, max)
+}
+
+//ZLEXCOUNT k1 [a (c
+// execZLexCount gets number of members which member within given lex range
+func execZLexCount(db *DB, args [][]byte) resp.Reply {
+	min, err := SortedSet.ParseLexBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseLexBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return countGeneric(db, string(args[0]), min, max)
+}
+
+func elementsToReply(elements []*SortedSet.Element, withScores bool) resp.Reply {
+	if len(elements) == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// rangeByRank returns members which rank within [start, stop], negative index counts from the tail
+func rangeByRank(db *DB, key string, start int64, stop int64, withScores bool, desc bool) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	// compute index
+	size := sortedSet.Len()
+	start = normalizeIndex(start, size)
+	if start < 0 {
+		start = 0
+	}
+	stop = normalizeIndex(stop, size)
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	elements := sortedSet.Range(start, stop+1, desc)
+	return elementsToReply(elements, withScores)
+}
+
+// rangeByBorder returns members within [min, max], it skips offset members and returns at most limit members
+func rangeByBorder(db *DB, key string, min SortedSet.Border, max SortedSet.Border, offset int64, limit int64, withScores bool, desc bool) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	elements := sortedSet.RangeByBorder(min, max, offset, limit, desc)
+	return elementsToReply(elements, withScores)
+}
+
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeArgs holds parsed arguments of ZRANGE and its variants
+type zrangeArgs struct {
+	key        string
+	start      []byte // rank, min score or min member
+	stop       []byte
+	by         int
+	desc       bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	limit      int64
+}
+
+// parseZRangeOptions parses [WITHSCORES] [LIMIT offset count] and [BYSCORE|BYLEX] [REV] if allowBy is true
+func parseZRangeOptions(rangeArgs *zrangeArgs, options [][]byte, allowBy bool) reply.ErrorReply {
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(string(options[i]))
+		switch {
+		case option == "WITHSCORES":
+			rangeArgs.withScores = true
+		case option == "LIMIT":
+			if i+2 >= len(options) {
+				return &reply.SyntaxErrReply{}
+			}
+			offset, err := strconv.ParseInt(string(options[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			limit, err := strconv.ParseInt(string(options[i+2]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			rangeArgs.hasLimit = true
+			rangeArgs.offset = offset
+			rangeArgs.limit = limit
+			i += 2
+		case allowBy && option == "BYSCORE":
+			rangeArgs.by = zrangeByScore
+		case allowBy && option == "BYLEX":
+			rangeArgs.by = zrangeByLex
+		case allowBy && option == "REV":
+			rangeArgs.desc = true
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	if rangeArgs.hasLimit && rangeArgs.by == zrangeByRank {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if rangeArgs.withScores && rangeArgs.by == zrangeByLex {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// parseZRangeBorders parses start and stop of ZRANGE BYSCORE or BYLEX into min and max border
+func parseZRangeBorders(rangeArgs *zrangeArgs) (min SortedSet.Border, max SortedSet.Border, errReply reply.ErrorReply) {
+	minArg, maxArg := rangeArgs.start, rangeArgs.stop
+	if rangeArgs.desc { // ZRANGE k1 max min BYSCORE REV
+		minArg, maxArg = maxArg, minArg
+	}
+	var err error
+	if rangeArgs.by == zrangeByScore {
+		min, err = SortedSet.ParseScoreBorder(string(minArg))
+		if err == nil {
+			max, err = SortedSet.ParseScoreBorder(string(maxArg))
+		}
+	} else {
+		min, err = SortedSet.ParseLexBorder(string(minArg))
+		if err == nil {
+			max, err = SortedSet.ParseLexBorder(string(maxArg))
+		}
+	}
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	return min, max, nil
+}
+
+func zrangeGeneric(db *DB, rangeArgs *zrangeArgs) resp.Reply {
+	if rangeArgs.by == zrangeByRank {
+		start, err := strconv.ParseInt(string(rangeArgs.start), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		stop, err := strconv.ParseInt(string(rangeArgs.stop), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		return rangeByRank(db, rangeArgs.key, start, stop, rangeArgs.withScores, rangeArgs.desc)
+	}
+	min, max, errReply := parseZRangeBorders(rangeArgs)
+	if errReply != nil {
+		return errReply
+	}
+	offset, limit := int64(0), int64(-1)
+	if rangeArgs.hasLimit {
+		offset, limit = rangeArgs.offset, rangeArgs.limit
+	}
+	return rangeByBorder(db, rangeArgs.key, min, max, offset, limit, rangeArgs.withScores, rangeArgs.desc)
+}
+
+//ZRANGE k1 start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// execZRange gets members in range
+func execZRange(db *DB, args [][]byte) resp.Reply {
+	rangeArgs := &zrangeArgs{
+		key:   string(args[0]),
+		start: args[1],
+		stop:  args[2],
+		by:    zrangeByRank,
+	}
+	if errReply := parseZRangeOptions(rangeArgs, args[3:], true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, rangeArgs)
+}
+
+//ZREVRANGE k1 start stop [WITHSCORES]
+// execZRevRange gets members in range, sort by descending order
+func execZRevRange(db *DB, args [][]byte) resp.Reply {
+	rangeArgs := &zrangeArgs{
+		key:   string(args[0]),
+		start: args[1],
+		stop:  args[2],
+		by:    zrangeByRank,
+		desc:  true,
+	}
+	if errReply := parseZRangeOptions(rangeArgs, args[3:], false); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, rangeArgs)
+}
+
+// rangeByGeneric handles ZRANGEBYSCORE/ZRANGEBYLEX and their reverse version,
+// reverse version accepts max before min just like ZRANGE ... REV
+func rangeByGeneric(db *DB, args [][]byte, by int, desc bool) resp.Reply {
+	rangeArgs := &zrangeArgs{
+		key:   string(args[0]),
+		start: args[1],
+		stop:  args[2],
+		by:    by,
+		desc:  desc,
+	}
+	if errReply := parseZRangeOptions(rangeArgs, args[3:], false); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, rangeArgs)
+}
+
+//ZRANGEBYSCORE k1 min max [WITHSCORES] [LIMIT offset count]
+// execZRangeByScore gets members which score within given range, in ascending order
+func execZRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return rangeByGeneric(db, args, zrangeByScore, false)
+}
+
+//ZREVRANGEBYSCORE k1 max min [WITHSCORES] [LIMIT offset count]
+// execZRevRangeByScore gets members which score within given range, in descending order
+func execZRevRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return rangeByGeneric(db, args, zrangeByScore, true)
+}
+
+// execZRangeByLex gets members which member within given lex range, in ascending order
+func execZRangeByLex(db *DB, args [][]byte) resp.Reply {
+	return rangeByGeneric(db, args, zrangeByLex, false)
+}
+
+// execZRevRangeByLex gets members which member within given lex range, in descending order
+func execZRevRangeByLex(db *DB, args [][]byte) resp.Reply {
+	return rangeByGeneric(db, args, zrangeByLex, true)
+}
+
+func popGenericZSet(db *DB, cmdName string, args [][]byte, max bool) resp.Reply {
+	key := string(args[0])
+	count := 1
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	if len(args) == 2 {
+		c, err := strconv.Atoi(string(args[1]))
+		if err != nil || c < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = c
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(count)
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(removed) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	return elementsToReply(removed, true)
+}
+
+//ZPOPMIN k1 [count]
+// execZPopMin removes and returns members with the lowest scores
+func execZPopMin(db *DB, args [][]byte) resp.Reply {
+	return popGenericZSet(db, "zpopmin", args, false)
+}
+
+// execZPopMax removes and returns members with the highest scores
+func execZPopMax(db *DB, args [][]byte) resp.Reply {
+	return popGenericZSet(db, "zpopmax", args, true)
+}
+
+func removeByBorderGeneric(db *DB, cmdName string, args [][]byte, min SortedSet.Border, max SortedSet.Border) resp.Reply {
+	key := string(args[0])
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	removed := sortedSet.RemoveByBorder(min, max)
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+//ZREMRANGEBYSCORE k1 min max
+// execZRemRangeByScore removes members which score within given range
+func execZRemRangeByScore(db *DB, args [][]byte) resp.Reply {
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return removeByBorderGeneric(db, "zremrangebyscore", args, min, max)
+}
+
+//ZREMRANGEBYLEX k1 [a (c
+// execZRemRangeByLex removes members which member within given lex range
+func execZRemRangeByLex(db *DB, args [][]byte) resp.Reply {
+	min, err := SortedSet.ParseLexBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseLexBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return removeByBorderGeneric(db, "zremrangebylex", args, min, max)
+}
+
+//ZREMRANGEBYRANK k1 0 -1
+// execZRemRangeByRank removes members within given indexes
+func execZRemRangeByRank(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	// compute index
+	size := sortedSet.Len()
+	start = normalizeIndex(start, size)
+	if start < 0 {
+		start = 0
+	}
+	stop = normalizeIndex(stop, size)
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		return reply.MakeIntReply(0)
+	}
+
+	removed := sortedSet.RemoveByRank(start, stop+1)
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("zremrangebyrank", args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+func aggregate(policy int, a float64, b float64) float64 {
+	var result float64
+	switch policy {
+	case aggregateMin:
+		result = math.Min(a, b)
+	case aggregateMax:
+		result = math.Max(a, b)
+	default:
+		result = a + b
+	}
+	if math.IsNaN(result) { // inf + -inf
+		return 0
+	}
+	return result
+}
+
+// getAsWeightedSource reads a sorted set or a set (every member scores 1) for ZUNIONSTORE and ZINTERSTORE
+func (db *DB) getAsWeightedSource(key string) (map[string]float64, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	result := make(map[string]float64)
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		if data.Len() > 0 {
+			data.ForEach(0, data.Len(), false, func(element *SortedSet.Element) bool {
+				result[element.Member] = element.Score
+				return true
+			})
+		}
+	case *HashSet.Set:
+		data.ForEach(func(member string) bool {
+			result[member] = 1
+			return true
+		})
+	default:
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return result, nil
+}
+
+// prepareZStore writes dest and reads the numkeys source keys
+func prepareZStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 || len(args) < numKeys+2 {
+		return []string{dest}, nil
+	}
+	keys := make([]string, numKeys)
+	for i, arg := range args[2 : numKeys+2] {
+		keys[i] = string(arg)
+	}
+	return []string{dest}, keys
+}
+
+//ZUNIONSTORE dest numkeys k1 k2 [WEIGHTS w1 w2] [AGGREGATE SUM|MIN|MAX]
+func zstoreGeneric(db *DB, cmdName string, args [][]byte, union bool) resp.Reply {
+	dest := string(args[0])
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if len(args) < numKeys+2 {
+		return &reply.SyntaxErrReply{}
+	}
+	keys := args[2 : numKeys+2]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	policy := aggregateSum
+	options := args[numKeys+2:]
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(string(options[i]))
+		if option == "WEIGHTS" {
+			if i+numKeys >= len(options) {
+				return &reply.SyntaxErrReply{}
+			}
+			for j := 0; j < numKeys; j++ {
+				weight, ok := parseScore(options[i+1+j])
+				if !ok {
+					return reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += numKeys
+		} else if option == "AGGREGATE" {
+			if i+1 >= len(options) {
+				return &reply.SyntaxErrReply{}
+			}
+			switch strings.ToUpper(string(options[i+1])) {
+			case "SUM":
+				policy = aggregateSum
+			case "MIN":
+				policy = aggregateMin
+			case "MAX":
+				policy = aggregateMax
+			default:
+				return &reply.SyntaxErrReply{}
+			}
+			i++
+		} else {
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	var result map[string]float64
+	for i, key := range keys {
+		source, errReply := db.getAsWeightedSource(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		weighted := make(map[string]float64, len(source))
+		for member, score := range source {
+			score *= weights[i]
+			if math.IsNaN(score) { // inf * 0
+				score = 0
+			}
+			weighted[member] = score
+		}
+		if i == 0 {
+			result = weighted
+			continue
+		}
+		if union {
+			for member, score := range weighted {
+				if old, ok := result[member]; ok {
+					result[member] = aggregate(policy, old, score)
+				} else {
+					result[member] = score
+				}
+			}
+		} else {
+			for member, old := range result {
+				if score, ok := weighted[member]; ok {
+					result[member] = aggregate(policy, old, score)
+				} else {
+					delete(result, member)
+				}
+			}
+		}
+	}
+
+	db.Remove(dest) // clean ttl too
+	if len(result) > 0 {
+		sortedSet := SortedSet.Make()
+		for member, score := range result {
+			sortedSet.Add(member, score)
+		}
+		db.PutEntity(dest, &database.DataEntity{
+			Data: sortedSet,
+		})
+	}
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+// execZUnionStore adds multiple sorted sets and store the result in dest
+func execZUnionStore(db *DB, args [][]byte) resp.Reply {
+	return zstoreGeneric(db, "zunionstore", args, true)
+}
+
+// execZInterStore intersects multiple sorted sets and store the result in dest
+func execZInterStore(db *DB, args [][]byte) resp.Reply {
+	return zstoreGeneric(db, "zinterstore", args, false)
+}
+
+func init() {
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, -4)
+	RegisterCommand("ZScore", execZScore, readFirstKey, 3)
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, 4)
+	RegisterCommand("ZCard", execZCard, readFirstKey, 2)
+	RegisterCommand("ZRem", execZRem, writeFirstKey, -3)
+	RegisterCommand("ZRank", execZRank, readFirstKey, 3)
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, 3)
+	RegisterCommand("ZCount", execZCount, readFirstKey, 4)
+	RegisterCommand("ZLexCount", execZLexCount, readFirstKey, 4)
+	RegisterCommand("ZRange", execZRange, readFirstKey, -4)
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, -4)
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, -4)
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, -4)
+	RegisterCommand("ZRangeByLex", execZRangeByLex, readFirstKey, -4)
+	RegisterCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, -4)
+	RegisterCommand("ZPopMin", execZPopMin, writeFirstKey, -2)
+	RegisterCommand("ZPopMax", execZPopMax, writeFirstKey, -2)
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, 4)
+	RegisterCommand("ZRemRangeByLex", execZRemRangeByLex, writeFirstKey, 4)
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, 4)
+	RegisterCommand("ZUnionStore", execZUnionStore, prepareZStore, -4)
+	RegisterCommand("ZInterStore", execZInterStore, prepareZStore, -4)
+}
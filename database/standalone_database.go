@@ -1,22 +1,94 @@
 package database
 
 import (
+	"errors"
 	"go_redis_write/aof"
 	"go_redis_write/config"
+	databaseface "go_redis_write/interface/database"
 	"go_redis_write/interface/resp"
 	"go_redis_write/lib/logger"
+	"go_redis_write/pubsub"
 	"go_redis_write/resp/reply"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type StandaloneDatabase struct {
 	dbSet      []*DB //默认是16个db
 	aofHandler *aof.AofHandler
+	hub        *pubsub.Hub //发布订阅
+
+	// rdb persistence
+	dirty        int64 // number of changes since last save
+	lastSave     int64 // unix time of last successful save
+	saving       int32 // 1 while background saving
+	saveWait     sync.WaitGroup
+	saveRules    []saveRule
+	stopSaveCron chan struct{}
+
+	// replication
+	master *masterStatus
+	replMu sync.Mutex
+	slave  *slaveStatus // not nil if this node is a replica
+	role   int32        // roleMaster or roleSlave
 }
 
 func NewStandaloneDatabase() *StandaloneDatabase { //初始化16个DB
-	database := &StandaloneDatabase{}
+	database := MakeBasicStandaloneDatabase()
+	database.master = makeMasterStatus()
+	for _, db := range database.dbSet { //对每个db进行aof的初始化，可以让每个db完成aof的具体初始化功能 //存在闭包情况需要解决
+		sdb := db
+		sdb.addAof = func(line CmdLine) {
+			atomic.AddInt64(&database.dirty, 1) // every write is persisted through addAof, count it for save rules
+			if database.aofHandler != nil {
+				database.aofHandler.AddAof(sdb.index, line)
+			}
+			database.master.feed(sdb.index, line) // replicas execute the same commands as aof
+		}
+	}
+	if config.Properties.AppendOnly {
+		aofHandler, err := aof.NewAOFHandler(database, func() databaseface.DBEngine {
+			return MakeBasicStandaloneDatabase()
+		})
+		if err != nil {
+			panic(err)
+		}
+		database.aofHandler = aofHandler
+	} else {
+		err := database.loadRDB()
+		if err != nil {
+			panic("load rdb failed: " + err.Error())
+		}
+	}
+	atomic.StoreInt64(&database.dirty, 0) // loading is not a change
+	database.lastSave = time.Now().Unix()
+	database.saveRules = parseSaveRules(config.Properties.Save)
+	if len(database.saveRules) > 0 {
+		database.startSaveCron()
+	}
+	for _, db := range database.dbSet {
+		db.startActiveExpire() //每个db都有自己的过期key清理协程
+	}
+	database.master.startPing()
+	if config.Properties.ReplicaOf != "" {
+		host, port, ok := parseReplicaOf(config.Properties.ReplicaOf)
+		if !ok {
+			panic("invalid replicaof: " + config.Properties.ReplicaOf)
+		}
+		database.startReplication(host, port)
+	}
+	return database
+}
+
+// MakeBasicStandaloneDatabase creates a database without aof and background goroutines,
+// aof rewrite uses it to load aof into memory
+func MakeBasicStandaloneDatabase() *StandaloneDatabase {
+	database := &StandaloneDatabase{
+		hub: pubsub.MakeHub(),
+	}
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
@@ -26,20 +98,57 @@ func NewStandaloneDatabase() *StandaloneDatabase { //初始化16个DB
 		db.index = i
 		database.dbSet[i] = db
 	}
-	if config.Properties.AppendOnly {
-		aofHandler, err := aof.NewAOFHandler(database)
-		if err != nil {
-			panic(err)
+	return database
+}
+
+// ForEach traverses all the keys in the given db
+func (database *StandaloneDatabase) ForEach(dbIndex int, cb func(key string, data *databaseface.DataEntity, expiration *time.Time) bool) {
+	if dbIndex < 0 || dbIndex >= len(database.dbSet) {
+		return
+	}
+	database.dbSet[dbIndex].ForEach(cb)
+}
+
+// SetKeyEventCallbacks sets callbacks of key insertion and deletion of all dbs, inserted is called for existing keys at once.
+// all keys are locked meanwhile, so no insertion or deletion is missed
+func (database *StandaloneDatabase) SetKeyEventCallbacks(inserted databaseface.KeyEventCallback, deleted databaseface.KeyEventCallback) {
+	for _, db := range database.dbSet {
+		db.locker.LockAll()
+	}
+	defer func() {
+		for i := len(database.dbSet) - 1; i >= 0; i-- {
+			database.dbSet[i].locker.UnLockAll()
 		}
-		database.aofHandler = aofHandler
-		for _, db := range database.dbSet { //对每个db进行aof的初始化，可以让每个db完成aof的具体初始化功能 //存在闭包情况需要解决
-			sdb := db
-			sdb.addAof = func(line CmdLine) {
-				database.aofHandler.AddAof(sdb.index, line)
-			}
+	}()
+	for _, db := range database.dbSet {
+		db.insertCallback = inserted
+		db.deleteCallback = deleted
+		if inserted == nil {
+			continue
 		}
+		db.data.ForEach(func(key string, _ interface{}) bool {
+			inserted(db.index, key)
+			return true
+		})
 	}
-	return database
+}
+
+// LoadEntity puts an entity loaded from snapshot into the given db, expired entity is ignored
+func (database *StandaloneDatabase) LoadEntity(dbIndex int, key string, data *databaseface.DataEntity, expiration *time.Time) error {
+	if dbIndex < 0 || dbIndex >= len(database.dbSet) {
+		return errors.New("db index " + strconv.Itoa(dbIndex) + " is out of range")
+	}
+	if expiration != nil && time.Now().After(*expiration) {
+		return nil
+	}
+	db := database.dbSet[dbIndex]
+	db.PutEntity(key, data)
+	if expiration != nil {
+		db.Expire(key, *expiration)
+	} else {
+		db.Persist(key)
+	}
+	return nil
 }
 
 //select 1 切换为第一个数据库
@@ -55,6 +164,101 @@ func execSelect(c resp.Connection, database *StandaloneDatabase, args [][]byte)
 	return reply.MakeOkReply()
 }
 
+// execPersistence executes commands about aof and rdb
+func (database *StandaloneDatabase) execPersistence(cmdName string) resp.Reply {
+	switch cmdName {
+	case "bgrewriteaof":
+		return database.execBGRewriteAof()
+	case "save":
+		return database.execSave()
+	case "bgsave":
+		return database.execBGSave()
+	case "lastsave":
+		return database.execLastSave()
+	}
+	return nil
+}
+
+// execBGRewriteAof starts aof rewrite in background
+func (database *StandaloneDatabase) execBGRewriteAof() resp.Reply {
+	if database.aofHandler == nil {
+		return reply.MakeErrReply("ERR Append only file is disabled")
+	}
+	err := database.aofHandler.BgRewrite()
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+//订阅模式下只允许执行这些命令
+var subscribeModeCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+}
+
+func isPubSubCommand(cmdName string) bool {
+	switch cmdName {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "publish", "pubsub":
+		return true
+	}
+	return false
+}
+
+// execPubSub executes pub/sub commands, which are not bound to any db
+func (database *StandaloneDatabase) execPubSub(c resp.Connection, cmdName string, args [][]byte) resp.Reply {
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.Subscribe(database.hub, c, args[1:])
+	case "psubscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.PSubscribe(database.hub, c, args[1:])
+	case "unsubscribe":
+		return pubsub.UnSubscribe(database.hub, c, args[1:])
+	case "punsubscribe":
+		return pubsub.PUnSubscribe(database.hub, c, args[1:])
+	case "publish":
+		return pubsub.Publish(database.hub, args[1:])
+	case "pubsub":
+		return pubsub.PubSub(database.hub, args[1:])
+	}
+	return nil
+}
+
+func isReplicationCommand(cmdName string) bool {
+	switch cmdName {
+	case "replicaof", "slaveof", "psync", "sync", "replconf", "role", "info":
+		return true
+	}
+	return false
+}
+
+// execReplication executes commands about master-replica replication
+func (database *StandaloneDatabase) execReplication(c resp.Connection, cmdName string, args [][]byte) resp.Reply {
+	switch cmdName {
+	case "replicaof", "slaveof":
+		return database.execReplicaOf(args)
+	case "psync", "sync":
+		return database.execPSync(c)
+	case "replconf":
+		return database.execReplConf(c, args[1:])
+	case "role":
+		return database.execRole()
+	case "info":
+		return database.execInfo(args)
+	}
+	return nil
+}
+
 //set k v
 //get k
 func (database *StandaloneDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
@@ -64,21 +268,110 @@ func (database *StandaloneDatabase) Exec(client resp.Connection, args [][]byte)
 		}
 	}()
 	cmdName := strings.ToLower(string(args[0]))
+	if client.SubsCount() > 0 {
+		if !subscribeModeCommands[cmdName] {
+			return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+				"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		}
+		if cmdName == "ping" {
+			return pubsub.Ping(args[1:])
+		}
+	}
 	if cmdName == "select" {
+		if client.InMultiState() { //EXEC只在当前db上执行队列，切换db会让后续命令落到别的db上
+			return notAllowedInMulti(client)
+		}
 		if len(args) != 2 {
 			return reply.MakeArgNumErrReply("select ")
 		}
 		return execSelect(client, database, args[1:])
 	}
+	switch cmdName { //持久化相关的命令
+	case "bgrewriteaof", "save", "bgsave", "lastsave":
+		if client.InMultiState() {
+			return notAllowedInMulti(client)
+		}
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return database.execPersistence(cmdName)
+	}
+	if isPubSubCommand(cmdName) {
+		if client.InMultiState() {
+			return notAllowedInMulti(client)
+		}
+		return database.execPubSub(client, cmdName, args)
+	}
+	if isReplicationCommand(cmdName) {
+		if client.InMultiState() {
+			return notAllowedInMulti(client)
+		}
+		return database.execReplication(client, cmdName, args)
+	}
+	if database.isReadOnlyReplica(client) && isWriteCommand(args) {
+		errReply := reply.MakeErrReply("READONLY You can't write against a read only replica.")
+		if client.InMultiState() {
+			client.AddTxError(errors.New(errReply.Status))
+		}
+		return errReply
+	}
+	if cmdName == "flushall" {
+		//redis允许在MULTI中使用FLUSHALL，这里不支持：EXEC只锁住当前db涉及的key，
+		//而FLUSHALL要清空所有db，放进队列里就无法和其他连接的写操作保持原子性
+		if client.InMultiState() {
+			return notAllowedInMulti(client)
+		}
+		return database.flushAll()
+	}
 	dbIndex := client.GetDBIndex()
 	db := database.dbSet[dbIndex]
 	return db.Exec(client, args)
 }
 
+// notAllowedInMulti rejects a command inside MULTI, the transaction is aborted by EXEC later
+func notAllowedInMulti(client resp.Connection) resp.Reply {
+	errReply := reply.MakeErrReply("ERR Command not allowed inside a transaction")
+	client.AddTxError(errors.New(errReply.Status))
+	return errReply
+}
+
+// flushAll removes data of all dbs, every db writes FLUSHDB to aof and replicas
+func (database *StandaloneDatabase) flushAll() resp.Reply {
+	for _, db := range database.dbSet {
+		execFlushDB(db, nil)
+	}
+	return &reply.OkReply{}
+}
+
+// AfterClientClose does some clean after client close connection
 func (database *StandaloneDatabase) AfterClientClose(c resp.Connection) {
-	panic("implement me")
+	pubsub.UnsubscribeAll(database.hub, c)
+	if database.master != nil {
+		database.master.onClientClose(c)
+	}
 }
 
+// Close stops background goroutines and aof persistence
 func (database *StandaloneDatabase) Close() {
-	panic("implement me")
+	database.stopReplication()
+	if database.master != nil {
+		database.master.close()
+	}
+	for _, db := range database.dbSet {
+		db.stopActiveExpire()
+	}
+	if database.stopSaveCron != nil {
+		close(database.stopSaveCron)
+		database.stopSaveCron = nil
+	}
+	database.saveWait.Wait()
+	if len(database.saveRules) > 0 { // save before shutdown like redis
+		err := database.Save()
+		if err != nil {
+			logger.Error("save rdb before shutdown failed: " + err.Error())
+		}
+	}
+	if database.aofHandler != nil {
+		database.aofHandler.Close()
+	}
 }
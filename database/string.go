@@ -1,12 +1,14 @@
 package database
 
 import (
+	"go_redis_write/aof"
 	"go_redis_write/interface/database"
 	"go_redis_write/interface/resp"
 	"go_redis_write/lib/utils"
 	"go_redis_write/resp/reply"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //GET
@@ -46,27 +48,66 @@ const (
 	updatePolicy        // set ex
 )
 
-//SET k1 v
+// parseExpireOption converts the ttl argument of SET EX/PX/EXAT/PXAT to absolute expire time, false means overflow
+func parseExpireOption(option string, raw int64) (time.Time, bool) {
+	switch option {
+	case "EX":
+		return toExpireTime(raw, time.Second, false)
+	case "PX":
+		return toExpireTime(raw, time.Millisecond, false)
+	case "EXAT":
+		return toExpireTime(raw, time.Second, true)
+	default: // PXAT
+		return toExpireTime(raw, time.Millisecond, true)
+	}
+}
+
+//SET k1 v [NX|XX] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
 // execSet sets string value and time to live to the given key
 func execSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	value := args[1]
 	policy := upsertPolicy
+	var expireAt time.Time // zero value means no ttl
+	keepTTL := false
 	// parse options
 	if len(args) > 2 {
 		for i := 2; i < len(args); i++ {
 			arg := strings.ToUpper(string(args[i]))
-			if arg == "NX" { // insert
+			switch arg {
+			case "NX": // insert
 				if policy == updatePolicy {
 					return &reply.SyntaxErrReply{}
 				}
 				policy = insertPolicy
-			} else if arg == "XX" { // update policy
+			case "XX": // update policy
 				if policy == insertPolicy {
 					return &reply.SyntaxErrReply{}
 				}
 				policy = updatePolicy
-			} else {
+			case "KEEPTTL":
+				if !expireAt.IsZero() {
+					return &reply.SyntaxErrReply{}
+				}
+				keepTTL = true
+			case "EX", "PX", "EXAT", "PXAT":
+				if keepTTL || !expireAt.IsZero() || i+1 >= len(args) {
+					return &reply.SyntaxErrReply{}
+				}
+				raw, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+				if err != nil {
+					return reply.MakeErrReply("ERR value is not an integer or out of range")
+				}
+				if raw <= 0 {
+					return reply.MakeErrReply("ERR invalid expire time in 'set' command")
+				}
+				var ok bool
+				expireAt, ok = parseExpireOption(arg, raw)
+				if !ok {
+					return makeExpireTimeErrReply("set")
+				}
+				i++ // skip ttl argument
+			default:
 				return &reply.SyntaxErrReply{}
 			}
 		}
@@ -86,8 +127,17 @@ func execSet(db *DB, args [][]byte) resp.Reply {
 	case updatePolicy:
 		result = db.PutIfExists(key, entity)
 	}
-	db.addAof(utils.ToCmdLine2("set", args...))
 	if result > 0 {
+		if !expireAt.IsZero() {
+			db.Expire(key, expireAt)
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+			db.addAof(aof.MakeExpireCmd(key, expireAt)) //写绝对时间，重放的时候不会延长ttl
+		} else if keepTTL {
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1], []byte("KEEPTTL")))
+		} else {
+			db.Persist(key) // override ttl
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+		}
 		return &reply.OkReply{}
 	}
 	return &reply.NullBulkReply{}
@@ -101,10 +151,20 @@ func execSetNX(db *DB, args [][]byte) resp.Reply {
 		Data: value,
 	}
 	result := db.PutIfAbsent(key, entity)
-	//db.addAof(utils.ToCmdLine2("setnx", args...))
+	db.addAof(utils.ToCmdLine2("setnx", args...))
 	return reply.MakeIntReply(int64(result))
 }
 
+// prepareMSet returns the keys of MSET/MSETNX, which are at even positions
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
+}
+
 // execMSet sets multi key-value in database
 func execMSet(db *DB, args [][]byte) resp.Reply {
 	if len(args)%2 != 0 {
@@ -122,8 +182,9 @@ func execMSet(db *DB, args [][]byte) resp.Reply {
 	for i, key := range keys {
 		value := values[i]
 		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.Persist(key)
 	}
-	//db.addAof(utils.ToCmdLine2("mset", args...))
+	db.addAof(utils.ToCmdLine2("mset", args...))
 	return &reply.OkReply{}
 }
 
@@ -177,7 +238,7 @@ func execMSetNX(db *DB, args [][]byte) resp.Reply {
 		value := values[i]
 		db.PutEntity(key, &database.DataEntity{Data: value})
 	}
-	//db.addAof(utils.ToCmdLine2("msetnx", args...))
+	db.addAof(utils.ToCmdLine2("msetnx", args...))
 	return reply.MakeIntReply(1)
 }
 
@@ -191,6 +252,7 @@ func execGetSet(db *DB, args [][]byte) resp.Reply {
 		return err
 	}
 	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.Persist(key) // GETSET discards the old ttl
 	if old == nil {
 		return new(reply.NullBulkReply)
 	}
@@ -214,13 +276,13 @@ func execIncr(db *DB, args [][]byte) resp.Reply {
 		db.PutEntity(key, &database.DataEntity{
 			Data: []byte(strconv.FormatInt(val+1, 10)),
 		})
-		//db.addAof(utils.ToCmdLine2("incr", args...))
+		db.addAof(utils.ToCmdLine2("incr", args...))
 		return reply.MakeIntReply(val + 1)
 	}
 	db.PutEntity(key, &database.DataEntity{
 		Data: []byte("1"),
 	})
-	//db.addAof(utils.ToCmdLine2("incr", args...))
+	db.addAof(utils.ToCmdLine2("incr", args...))
 	return reply.MakeIntReply(1)
 }
 
@@ -246,13 +308,13 @@ func execIncrBy(db *DB, args [][]byte) resp.Reply {
 		db.PutEntity(key, &database.DataEntity{
 			Data: []byte(strconv.FormatInt(val+delta, 10)),
 		})
-		//db.addAof(utils.ToCmdLine2("incrby", args...))
+		db.addAof(utils.ToCmdLine2("incrby", args...))
 		return reply.MakeIntReply(val + delta)
 	}
 	db.PutEntity(key, &database.DataEntity{
 		Data: args[1],
 	})
-	//db.addAof(utils.ToCmdLine2("incrby", args...))
+	db.addAof(utils.ToCmdLine2("incrby", args...))
 	return reply.MakeIntReply(delta)
 }
 
@@ -272,14 +334,14 @@ func execDecr(db *DB, args [][]byte) resp.Reply {
 		db.PutEntity(key, &database.DataEntity{
 			Data: []byte(strconv.FormatInt(val-1, 10)),
 		})
-		//db.addAof(utils.ToCmdLine2("decr", args...))
+		db.addAof(utils.ToCmdLine2("decr", args...))
 		return reply.MakeIntReply(val - 1)
 	}
 	entity := &database.DataEntity{
 		Data: []byte("-1"),
 	}
 	db.PutEntity(key, entity)
-	//db.addAof(utils.ToCmdLine2("decr", args...))
+	db.addAof(utils.ToCmdLine2("decr", args...))
 	return reply.MakeIntReply(-1)
 }
 
@@ -304,14 +366,14 @@ func execDecrBy(db *DB, args [][]byte) resp.Reply {
 		db.PutEntity(key, &database.DataEntity{
 			Data: []byte(strconv.FormatInt(val-delta, 10)),
 		})
-		//db.addAof(utils.ToCmdLine2("decrby", args...))
+		db.addAof(utils.ToCmdLine2("decrby", args...))
 		return reply.MakeIntReply(val - delta)
 	}
 	valueStr := strconv.FormatInt(-delta, 10)
 	db.PutEntity(key, &database.DataEntity{
 		Data: []byte(valueStr),
 	})
-	//db.addAof(utils.ToCmdLine2("decrby", args...))
+	db.addAof(utils.ToCmdLine2("decrby", args...))
 	return reply.MakeIntReply(-delta)
 }
 
@@ -339,7 +401,7 @@ func execAppend(db *DB, args [][]byte) resp.Reply {
 	db.PutEntity(key, &database.DataEntity{
 		Data: bytes,
 	})
-	//db.addAof(utils.ToCmdLine2("append", args...))
+	db.addAof(utils.ToCmdLine2("append", args...))
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
@@ -374,7 +436,7 @@ func execSetRange(db *DB, args [][]byte) resp.Reply {
 	db.PutEntity(key, &database.DataEntity{
 		Data: bytes,
 	})
-	//db.addAof(utils.ToCmdLine2("setRange", args...))
+	db.addAof(utils.ToCmdLine2("setRange", args...))
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
@@ -423,19 +485,19 @@ func execGetRange(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("Set", execSet, -3)
-	RegisterCommand("SetNx", execSetNX, 3)
-	RegisterCommand("MSet", execMSet, -3)
-	RegisterCommand("MGet", execMGet, -2)
-	RegisterCommand("MSetNX", execMSetNX, -3)
-	RegisterCommand("Get", execGet, 2)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("Incr", execIncr, 2)
-	RegisterCommand("IncrBy", execIncrBy, 3)
-	RegisterCommand("Decr", execDecr, 2)
-	RegisterCommand("DecrBy", execDecrBy, 3)
-	RegisterCommand("StrLen", execStrLen, 2)
-	RegisterCommand("Append", execAppend, 3)
-	RegisterCommand("SetRange", execSetRange, 4)
-	RegisterCommand("GetRange", execGetRange, 4)
+	RegisterCommand("Set", execSet, writeFirstKey, -3)
+	RegisterCommand("SetNx", execSetNX, writeFirstKey, 3)
+	RegisterCommand("MSet", execMSet, prepareMSet, -3)
+	RegisterCommand("MGet", execMGet, readAllKeys, -2)
+	RegisterCommand("MSetNX", execMSetNX, prepareMSet, -3)
+	RegisterCommand("Get", execGet, readFirstKey, 2)
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, 3)
+	RegisterCommand("Incr", execIncr, writeFirstKey, 2)
+	RegisterCommand("IncrBy", execIncrBy, writeFirstKey, 3)
+	RegisterCommand("Decr", execDecr, writeFirstKey, 2)
+	RegisterCommand("DecrBy", execDecrBy, writeFirstKey, 3)
+	RegisterCommand("StrLen", execStrLen, readFirstKey, 2)
+	RegisterCommand("Append", execAppend, writeFirstKey, 3)
+	RegisterCommand("SetRange", execSetRange, writeFirstKey, 4)
+	RegisterCommand("GetRange", execGetRange, readFirstKey, 4)
 }
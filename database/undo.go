@@ -0,0 +1,105 @@
+package database
+
+import (
+	"errors"
+	"go_redis_write/aof"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/reply"
+	"strconv"
+	"strings"
+)
+
+//集群两阶段提交需要的接口：prepare阶段锁住相关key并记录undo日志，commit阶段在持有锁的情况下执行命令，
+//rollback时执行undo日志把key恢复到prepare时的样子
+
+// GetUndoLogs returns the commands which restore the write keys of cmdLine to current state
+// invoker should hold locks of these keys
+func (db *DB) GetUndoLogs(cmdLine [][]byte) []CmdLine {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return nil
+	}
+	write, _ := cmd.prepare(cmdLine[1:])
+	undoLogs := make([]CmdLine, 0, len(write))
+	for _, key := range write {
+		undoLogs = append(undoLogs, db.undoKey(key)...)
+	}
+	return undoLogs
+}
+
+// undoKey returns commands which recreate the key with its ttl, or delete it if the key doesn't exist
+func (db *DB) undoKey(key string) []CmdLine {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return []CmdLine{utils.ToCmdLine("del", key)}
+	}
+	undoLogs := []CmdLine{utils.ToCmdLine("del", key)}
+	if cmd := aof.EntityToCmd(key, entity); cmd != nil {
+		undoLogs = append(undoLogs, cmd)
+	}
+	if expireTime, hasTTL := db.GetExpireTime(key); hasTTL {
+		undoLogs = append(undoLogs, aof.MakeExpireCmd(key, expireTime))
+	}
+	return undoLogs
+}
+
+// ExecWithLock executes a command whose keys have been locked by RWLocks
+func (db *DB) ExecWithLock(cmdLine [][]byte) resp.Reply {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if ok && validateArity(cmd.arity, cmdLine) {
+		write, _ := cmd.prepare(cmdLine[1:])
+		db.addVersion(write...)
+	}
+	return db.execWithLock(cmdLine)
+}
+
+// GetRelatedKeys returns the keys to lock before executing cmdLine
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string, error) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return nil, nil, errors.New("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return nil, nil, errors.New("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	return write, read, nil
+}
+
+// getDB returns the db of given index, or an error reply if the index is out of range
+func (database *StandaloneDatabase) getDB(dbIndex int) (*DB, *reply.StandardErrReply) {
+	if dbIndex < 0 || dbIndex >= len(database.dbSet) {
+		return nil, reply.MakeErrReply("ERR DB index " + strconv.Itoa(dbIndex) + " is out of range")
+	}
+	return database.dbSet[dbIndex], nil
+}
+
+// RWLocks locks keys of the given db for writing and reading
+func (database *StandaloneDatabase) RWLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	database.dbSet[dbIndex].RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks unlocks keys locked by RWLocks
+func (database *StandaloneDatabase) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	database.dbSet[dbIndex].RWUnLocks(writeKeys, readKeys)
+}
+
+// ExecWithLock executes a command in the given db, invoker should lock the related keys by RWLocks
+func (database *StandaloneDatabase) ExecWithLock(dbIndex int, cmdLine [][]byte) resp.Reply {
+	db, errReply := database.getDB(dbIndex)
+	if errReply != nil {
+		return errReply
+	}
+	return db.ExecWithLock(cmdLine)
+}
+
+// GetUndoLogs returns the commands which undo cmdLine in the given db, invoker should lock the related keys
+func (database *StandaloneDatabase) GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine {
+	db, errReply := database.getDB(dbIndex)
+	if errReply != nil {
+		return nil
+	}
+	return db.GetUndoLogs(cmdLine)
+}
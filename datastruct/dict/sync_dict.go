@@ -79,11 +79,10 @@ func (dict *SyncDict) Keys() []string {
 	return result
 }
 
-// ForEach traversal the dict
+// ForEach traversal the dict, it stops when consumer returns false
 func (dict *SyncDict) ForEach(consumer Consumer) {
 	dict.m.Range(func(key, value interface{}) bool {
-		consumer(key.(string), value) //不断执行这个函数
-		return true
+		return consumer(key.(string), value) //不断执行这个函数
 	})
 }
 
@@ -116,6 +115,10 @@ func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
 }
 
 // Clear removes all keys in dict
+// 逐个删除而不是整体替换，避免和其他协程的读写产生竞争
 func (dict *SyncDict) Clear() {
-	*dict = *MakeSyncDict()
+	dict.m.Range(func(key, value interface{}) bool {
+		dict.m.Delete(key)
+		return true
+	})
 }
@@ -0,0 +1,25 @@
+package list
+
+// Expected check whether given item is equals to expected value
+type Expected func(a interface{}) bool
+
+// Consumer traverses list.
+// It receives index and value as params, returns true to continue traversal, while returns false to break
+type Consumer func(i int, v interface{}) bool
+
+// List is interface of list data structure
+type List interface {
+	Add(val interface{})                                 //在尾部加入
+	Get(index int) (val interface{})                     //按下标取值
+	Set(index int, val interface{})                      //按下标改值
+	Insert(index int, val interface{})                   //插入到index的位置
+	Remove(index int) (val interface{})                  //删除index位置的值
+	RemoveLast() (val interface{})                       //删除最后一个
+	RemoveAllByVal(expected Expected) int                //删除所有符合的值
+	RemoveByVal(expected Expected, count int) int        //从头开始删除count个符合的值
+	ReverseRemoveByVal(expected Expected, count int) int //从尾开始删除count个符合的值
+	Len() int
+	ForEach(consumer Consumer)
+	Contains(expected Expected) bool
+	Range(start int, stop int) []interface{} //左闭右开
+}
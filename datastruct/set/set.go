@@ -0,0 +1,114 @@
+package set
+
+import "go_redis_write/datastruct/dict"
+
+// Set is a set of elements based on hash table
+type Set struct {
+	dict dict.Dict
+}
+
+// Make creates a new set
+func Make(members ...string) *Set {
+	set := &Set{
+		dict: dict.MakeSimple(),
+	}
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
+
+// Add adds member into set, returns 1 if the member is new
+func (set *Set) Add(val string) int {
+	return set.dict.Put(val, nil)
+}
+
+// Remove removes member from set, returns 1 if the member existed
+func (set *Set) Remove(val string) int {
+	return set.dict.Remove(val)
+}
+
+// Has returns true if the val exists in the set
+func (set *Set) Has(val string) bool {
+	_, exists := set.dict.Get(val)
+	return exists
+}
+
+// Len returns number of members in the set
+func (set *Set) Len() int {
+	return set.dict.Len()
+}
+
+// ToSlice convert set to []string
+func (set *Set) ToSlice() []string {
+	return set.dict.Keys()
+}
+
+// ForEach visits each member in the set, it stops when consumer returns false
+func (set *Set) ForEach(consumer func(member string) bool) {
+	set.dict.ForEach(func(key string, val interface{}) bool {
+		return consumer(key)
+	})
+}
+
+// Intersect intersects two sets
+func (set *Set) Intersect(another *Set) *Set {
+	if set == nil {
+		panic("set is nil")
+	}
+	result := Make()
+	// 遍历小的那个集合就行
+	small, big := set, another
+	if small.Len() > big.Len() {
+		small, big = big, small
+	}
+	small.ForEach(func(member string) bool {
+		if big.Has(member) {
+			result.Add(member)
+		}
+		return true
+	})
+	return result
+}
+
+// Union adds two sets
+func (set *Set) Union(another *Set) *Set {
+	if set == nil {
+		panic("set is nil")
+	}
+	result := Make()
+	set.ForEach(func(member string) bool {
+		result.Add(member)
+		return true
+	})
+	another.ForEach(func(member string) bool {
+		result.Add(member)
+		return true
+	})
+	return result
+}
+
+// Diff subtracts another from set
+func (set *Set) Diff(another *Set) *Set {
+	if set == nil {
+		panic("set is nil")
+	}
+	result := Make()
+	set.ForEach(func(member string) bool {
+		if !another.Has(member) {
+			result.Add(member)
+		}
+		return true
+	})
+	return result
+}
+
+// RandomMembers randomly returns keys of the given number, may contain duplicated key
+func (set *Set) RandomMembers(limit int) []string {
+	return set.dict.RandomKeys(limit)
+}
+
+// RandomDistinctMembers randomly returns keys of the given number, won't contain duplicated key
+func (set *Set) RandomDistinctMembers(limit int) []string {
+	return set.dict.RandomDistinctKeys(limit)
+}
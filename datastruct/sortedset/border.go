@@ -0,0 +1,190 @@
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+/*
+ * ScoreBorder is a struct represents `min` `max` parameter of redis command `ZRANGEBYSCORE`
+ * can accept:
+ *   int or float value, such as 2.718, 2, -2.718, -2 ...
+ *   exclusive int or float value, such as (2.718, (2, (-2.718, (-2 ...
+ *   infinity: +inf, -inf， inf(same as +inf)
+ *
+ * LexBorder represents `min` `max` parameter of redis command `ZRANGEBYLEX`
+ * can accept:
+ *   inclusive value: [a
+ *   exclusive value: (a
+ *   infinity: + -
+ */
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// Border represents one side of a range in sorted set, it is either ScoreBorder or LexBorder
+type Border interface {
+	// satisfyMin returns true if element is on the right side of the border when it is the `min` border
+	satisfyMin(element *Element) bool
+	// satisfyMax returns true if element is on the left side of the border when it is the `max` border
+	satisfyMax(element *Element) bool
+	// isEmptyRange returns true if no element can be in range [border, max]
+	isEmptyRange(max Border) bool
+}
+
+// ScoreBorder represents range of a float value, including: <, <=, >, >=, +inf, -inf
+type ScoreBorder struct {
+	Inf     int8
+	Value   float64
+	Exclude bool
+}
+
+func (border *ScoreBorder) satisfyMin(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return element.Score > border.Value
+	}
+	return element.Score >= border.Value
+}
+
+func (border *ScoreBorder) satisfyMax(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return element.Score < border.Value
+	}
+	return element.Score <= border.Value
+}
+
+func (border *ScoreBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	if border.Value > maxBorder.Value {
+		return true
+	}
+	return border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude)
+}
+
+var positiveInfBorder = &ScoreBorder{
+	Inf: positiveInf,
+}
+
+var negativeInfBorder = &ScoreBorder{
+	Inf: negativeInf,
+}
+
+// ParseScoreBorder creates ScoreBorder from redis arguments
+func ParseScoreBorder(s string) (*ScoreBorder, error) {
+	if s == "inf" || s == "+inf" {
+		return positiveInfBorder, nil
+	}
+	if s == "-inf" {
+		return negativeInfBorder, nil
+	}
+	exclude := false
+	if len(s) > 0 && s[0] == '(' {
+		exclude = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	if math.IsInf(value, 1) {
+		return &ScoreBorder{Inf: positiveInf, Exclude: exclude}, nil
+	} else if math.IsInf(value, -1) {
+		return &ScoreBorder{Inf: negativeInf, Exclude: exclude}, nil
+	}
+	return &ScoreBorder{
+		Inf:     0,
+		Value:   value,
+		Exclude: exclude,
+	}, nil
+}
+
+// LexBorder represents range of member, only valid when all members have the same score
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) satisfyMin(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return element.Member > border.Value
+	}
+	return element.Member >= border.Value
+}
+
+func (border *LexBorder) satisfyMax(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return element.Member < border.Value
+	}
+	return element.Member <= border.Value
+}
+
+func (border *LexBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	if border.Value > maxBorder.Value {
+		return true
+	}
+	return border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude)
+}
+
+// ParseLexBorder creates LexBorder from redis arguments
+func ParseLexBorder(s string) (*LexBorder, error) {
+	if s == "+" {
+		return &LexBorder{Inf: positiveInf}, nil
+	}
+	if s == "-" {
+		return &LexBorder{Inf: negativeInf}, nil
+	}
+	if len(s) == 0 {
+		return nil, errors.New("ERR min or max not valid string range item")
+	}
+	switch s[0] {
+	case '(':
+		return &LexBorder{Value: s[1:], Exclude: true}, nil
+	case '[':
+		return &LexBorder{Value: s[1:], Exclude: false}, nil
+	default:
+		return nil, errors.New("ERR min or max not valid string range item")
+	}
+}
@@ -1,6 +1,9 @@
 package database
 
-import "go_redis_write/interface/resp"
+import (
+	"go_redis_write/interface/resp"
+	"time"
+)
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
@@ -12,6 +15,27 @@ type Database interface {
 	Close()
 }
 
+// KeyEventCallback is called when a key is inserted into or removed from a db, the lock of the key is held
+type KeyEventCallback func(dbIndex int, key string)
+
+// DBEngine is the embedding storage engine exposing more methods for persistence
+type DBEngine interface {
+	Database
+	// ForEach visits all keys of the given db, expiration is nil if the key has no ttl
+	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
+	// LoadEntity puts an entity loaded from snapshot into the given db, expired entity is ignored
+	LoadEntity(dbIndex int, key string, data *DataEntity, expiration *time.Time) error
+	// RWLocks locks keys of the given db, they are held until RWUnLocks, even across commands
+	RWLocks(dbIndex int, writeKeys []string, readKeys []string)
+	RWUnLocks(dbIndex int, writeKeys []string, readKeys []string)
+	// ExecWithLock executes a command without locking, invoker should hold the locks of related keys
+	ExecWithLock(dbIndex int, cmdLine CmdLine) resp.Reply
+	// GetUndoLogs returns commands which restore the keys written by cmdLine to current state
+	GetUndoLogs(dbIndex int, cmdLine CmdLine) []CmdLine
+	// SetKeyEventCallbacks sets callbacks of key insertion and deletion, inserted is called for existing keys at once
+	SetKeyEventCallbacks(inserted KeyEventCallback, deleted KeyEventCallback)
+}
+
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 //这个主要是对值的整体包装
 type DataEntity struct {
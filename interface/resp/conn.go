@@ -4,4 +4,27 @@ type Connection interface {
 	Write([]byte) error
 	GetDBIndex() int
 	SelectDB(int) //切DB情况
+
+	// used for `Publish`, `Subscribe` commands
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	SubsCount() int // channels and patterns, > 0 means in subscribe mode
+	GetChannels() []string
+	GetPatterns() []string
+
+	// used for `Multi` command
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	GetWatching() map[string]uint32
+	AddTxError(err error)
+	GetTxErrors() []error
+
+	// used for cluster redirection, ASKING allows the next command to access a slot being imported
+	SetAsking(bool)
+	IsAsking() bool
 }
@@ -2,54 +2,157 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"hash/fnv"
 	"sort"
+	"strconv"
+	"sync"
+)
+
+//一致性哈希: 每个节点在哈希环上放置 replicas*weight 个虚拟节点，key落在顺时针方向第一个虚拟节点所属的节点上
+//虚拟节点越多，key在节点间分布越均匀；权重越大，节点分到的key越多
+//哈希函数必须在不同进程和版本之间保持一致(不能用带随机种子的哈希)，否则各个节点会把同一个key分给不同的节点
+
+const (
+	// DefaultReplicas is the number of virtual nodes of a node with weight 1
+	DefaultReplicas = 512
 )
 
 // HashFunc defines function to generate hash code
 type HashFunc func(data []byte) uint32
 
+// CRC32 is the IEEE crc32 checksum, the hash function of early versions
+func CRC32(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// FNV32a is 32-bit FNV-1a hash with a final avalanche, so that similar keys like "node#1" and "node#2" spread over the circle
+func FNV32a(data []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	hash := h.Sum32()
+	// finalizer of murmur3
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
+
 // NodeMap stores nodes and you can pick node from NodeMap
 type NodeMap struct { //存储所有的节点
+	mu          sync.RWMutex
 	hashFunc    HashFunc
-	nodeHashs   []int // sorted 各个节点的哈希值 12343
-	nodehashMap map[int]string
+	replicas    int            // 权重为1的节点的虚拟节点数量
+	weights     map[string]int // node -> weight
+	nodeHashs   []int          // sorted 各个虚拟节点的哈希值 12343
+	nodehashMap map[int]string // 虚拟节点的哈希值 -> node
 }
 
-// NewNodeMap creates a new NodeMap
+// NewNodeMap creates a new NodeMap with DefaultReplicas virtual nodes per node, FNV32a is used if fn is nil
 func NewNodeMap(fn HashFunc) *NodeMap {
+	return NewNodeMapWithReplicas(DefaultReplicas, fn)
+}
+
+// NewNodeMapWithReplicas creates a new NodeMap, replicas is the number of virtual nodes of a node with weight 1,
+// DefaultReplicas is used if replicas is not positive, FNV32a is used if fn is nil
+func NewNodeMapWithReplicas(replicas int, fn HashFunc) *NodeMap {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
 	m := &NodeMap{
 		hashFunc:    fn,
+		replicas:    replicas,
+		weights:     make(map[string]int),
 		nodehashMap: make(map[int]string),
 	}
 	if m.hashFunc == nil {
-		m.hashFunc = crc32.ChecksumIEEE
+		m.hashFunc = FNV32a
 	}
 	return m
 }
 
 // IsEmpty returns if there is no node in NodeMap
 func (m *NodeMap) IsEmpty() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return len(m.nodeHashs) == 0
 }
 
 //初始化redis节点
 
-// AddNode add the given nodes into consistent hash circle
+// AddNode add the given nodes into consistent hash circle with weight 1
 func (m *NodeMap) AddNode(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, key := range keys {
 		if key == "" {
 			continue
 		}
-		hash := int(m.hashFunc([]byte(key)))
-		m.nodeHashs = append(m.nodeHashs, hash)
-		m.nodehashMap[hash] = key
+		m.weights[key] = 1
+	}
+	m.rebuild()
+}
+
+// AddWeightedNode adds a node whose share of keys is proportional to weight, the weight of existing node is updated
+func (m *NodeMap) AddWeightedNode(key string, weight int) {
+	if key == "" || weight <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.weights[key] = weight
+	m.rebuild()
+}
+
+// RemoveNode removes the given nodes, only keys of removed nodes are moved to other nodes
+func (m *NodeMap) RemoveNode(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.weights, key)
+	}
+	m.rebuild()
+}
+
+// Nodes returns all nodes in NodeMap
+func (m *NodeMap) Nodes() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// rebuild places virtual nodes of all nodes on the circle,
+// when two virtual nodes have the same hash the smaller node wins, so the result is independent of the order of adding
+func (m *NodeMap) rebuild() {
+	m.nodeHashs = m.nodeHashs[:0]
+	m.nodehashMap = make(map[int]string)
+	for node, weight := range m.weights {
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := int(m.hashFunc([]byte(node + "#" + strconv.Itoa(i))))
+			if owner, ok := m.nodehashMap[hash]; ok {
+				if owner > node {
+					m.nodehashMap[hash] = node
+				}
+				continue
+			}
+			m.nodeHashs = append(m.nodeHashs, hash)
+			m.nodehashMap[hash] = node
+		}
 	}
 	sort.Ints(m.nodeHashs)
 }
 
 // PickNode gets the closest item in the hash to the provided key.
 func (m *NodeMap) PickNode(key string) string {
-	if m.IsEmpty() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.nodeHashs) == 0 {
 		return ""
 	}
 
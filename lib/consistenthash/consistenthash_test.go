@@ -0,0 +1,87 @@
+package consistenthash
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+const testKeyCount = 100000
+
+func countKeys(m *NodeMap) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < testKeyCount; i++ {
+		counts[m.PickNode("key:"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+func TestDistribution(t *testing.T) {
+	nodes := []string{"127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381"}
+	m := NewNodeMap(nil)
+	m.AddNode(nodes...)
+	counts := countKeys(m)
+	expected := float64(testKeyCount) / float64(len(nodes))
+	for _, node := range nodes {
+		deviation := math.Abs(float64(counts[node])-expected) / expected
+		t.Logf("%s: %d keys, deviation %.2f%%", node, counts[node], deviation*100)
+		if deviation > 0.05 {
+			t.Errorf("%s holds %d keys, expected about %.0f", node, counts[node], expected)
+		}
+	}
+}
+
+func TestWeightedNode(t *testing.T) {
+	m := NewNodeMap(nil)
+	m.AddWeightedNode("a", 1)
+	m.AddWeightedNode("b", 3)
+	counts := countKeys(m)
+	ratio := float64(counts["b"]) / float64(counts["a"])
+	if ratio < 2.7 || ratio > 3.3 {
+		t.Errorf("expected b holds about 3 times keys of a, got %d and %d", counts["b"], counts["a"])
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	m := NewNodeMap(nil)
+	m.AddNode("a", "b", "c")
+	before := make([]string, testKeyCount)
+	for i := range before {
+		before[i] = m.PickNode("key:" + strconv.Itoa(i))
+	}
+	m.RemoveNode("b")
+	for i, node := range before {
+		after := m.PickNode("key:" + strconv.Itoa(i))
+		if after == "b" {
+			t.Fatal("removed node is picked")
+		}
+		if node != "b" && after != node {
+			t.Fatalf("key:%d moved from %s to %s", i, node, after)
+		}
+	}
+	m.RemoveNode("a", "c")
+	if !m.IsEmpty() || m.PickNode("key") != "" {
+		t.Error("expected empty node map")
+	}
+}
+
+func TestStable(t *testing.T) {
+	m1 := NewNodeMap(nil)
+	m1.AddNode("a", "b", "c")
+	m2 := NewNodeMap(nil)
+	m2.AddNode("c")
+	m2.AddNode("b", "a")
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if m1.PickNode(key) != m2.PickNode(key) {
+			t.Fatalf("%s is picked differently", key)
+		}
+	}
+	// hash values must not change between versions, otherwise nodes disagree with each other
+	if h := FNV32a([]byte("foo")); h != 0x6d5a5ca4 {
+		t.Errorf("unexpected FNV32a of foo: %#x", h)
+	}
+	if h := CRC32([]byte("foo")); h != 0x8c736521 {
+		t.Errorf("unexpected CRC32 of foo: %#x", h)
+	}
+}
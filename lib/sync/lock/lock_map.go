@@ -0,0 +1,180 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	prime32 = uint32(16777619)
+)
+
+// Locks provides rw locks for key
+// 按key的哈希值分片加锁，而不是每个key一把锁，节省内存
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+// Make creates a new lock map
+func Make(tableSize int) *Locks {
+	table := make([]*sync.RWMutex, tableSize)
+	for i := 0; i < tableSize; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	if locks == nil {
+		panic("locks is nil")
+	}
+	tableSize := uint32(len(locks.table))
+	return (tableSize - 1) & hashCode
+}
+
+// Lock obtains exclusive lock for writing
+func (locks *Locks) Lock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.Lock()
+}
+
+// RLock obtains shared lock for reading
+func (locks *Locks) RLock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.RLock()
+}
+
+// UnLock release exclusive lock
+func (locks *Locks) UnLock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.Unlock()
+}
+
+// RUnLock release shared lock
+func (locks *Locks) RUnLock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.RUnlock()
+}
+
+// toLockIndices returns sorted and distinct indices of the given keys,
+// locking in the same order prevents dead lock
+func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
+	indexMap := make(map[uint32]struct{})
+	for _, key := range keys {
+		index := locks.spread(fnv32(key))
+		indexMap[index] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if !reverse {
+			return indices[i] < indices[j]
+		}
+		return indices[i] > indices[j]
+	})
+	return indices
+}
+
+// Locks obtains multiple exclusive locks for writing
+// invoking Lock in loop may cause dead lock, please use Locks
+func (locks *Locks) Locks(keys ...string) {
+	indices := locks.toLockIndices(keys, false)
+	for _, index := range indices {
+		mu := locks.table[index]
+		mu.Lock()
+	}
+}
+
+// UnLocks releases multiple exclusive locks
+func (locks *Locks) UnLocks(keys ...string) {
+	indices := locks.toLockIndices(keys, true)
+	for _, index := range indices {
+		mu := locks.table[index]
+		mu.Unlock()
+	}
+}
+
+// RWLocks locks write keys and read keys together. allow duplicate keys
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	keys := append(writeKeys, readKeys...)
+	indices := locks.toLockIndices(keys, false)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		idx := locks.spread(fnv32(wKey))
+		writeIndexSet[idx] = struct{}{}
+	}
+	for _, index := range indices {
+		_, w := writeIndexSet[index]
+		mu := locks.table[index]
+		if w {
+			mu.Lock()
+		} else {
+			mu.RLock()
+		}
+	}
+}
+
+// RWUnLocks unlocks write keys and read keys together. allow duplicate keys
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	keys := append(writeKeys, readKeys...)
+	indices := locks.toLockIndices(keys, true)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		idx := locks.spread(fnv32(wKey))
+		writeIndexSet[idx] = struct{}{}
+	}
+	for _, index := range indices {
+		_, w := writeIndexSet[index]
+		mu := locks.table[index]
+		if w {
+			mu.Unlock()
+		} else {
+			mu.RUnlock()
+		}
+	}
+}
+
+// RLockAll obtains shared locks of all keys, used to take a consistent snapshot
+func (locks *Locks) RLockAll() {
+	for _, mu := range locks.table {
+		mu.RLock()
+	}
+}
+
+// RUnLockAll releases shared locks obtained by RLockAll
+func (locks *Locks) RUnLockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].RUnlock()
+	}
+}
+
+// LockAll obtains exclusive locks of all keys, used to replace the whole db
+func (locks *Locks) LockAll() {
+	for _, mu := range locks.table {
+		mu.Lock()
+	}
+}
+
+// UnLockAll releases exclusive locks obtained by LockAll
+func (locks *Locks) UnLockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].Unlock()
+	}
+}
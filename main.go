@@ -11,11 +11,6 @@ import (
 
 const configFile string = "redis.conf"
 
-var defaultProperties = &config.ServerProperties{
-	Bind: "0.0.0.0",
-	Port: 6379,
-}
-
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	return err == nil && !info.IsDir()
@@ -29,10 +24,8 @@ func main() {
 		TimeFormat: "2006-01-02",
 	})
 
-	if fileExists(configFile) {
+	if fileExists(configFile) { //没有配置文件时使用config包里的默认配置
 		config.SetupConfig(configFile)
-	} else {
-		config.Properties = defaultProperties
 	}
 
 	err := tcp.ListenAndServeWithSignal(
@@ -0,0 +1,80 @@
+package pubsub
+
+import (
+	"go_redis_write/datastruct/dict"
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/sync/lock"
+	"go_redis_write/lib/wildcard"
+)
+
+// subscribers is a set of connections
+type subscribers map[resp.Connection]struct{}
+
+// patternSubscribers holds the compiled pattern and the connections subscribing it
+type patternSubscribers struct {
+	pattern     *wildcard.Pattern
+	subscribers subscribers
+}
+
+// Hub stores all subscribe relations
+type Hub struct {
+	// channel -> subscribers
+	subs dict.Dict
+	// pattern -> *patternSubscribers
+	psubs dict.Dict
+	// lock channel or pattern while modifying its subscribers
+	subsLocker *lock.Locks
+}
+
+// MakeHub creates new hub
+func MakeHub() *Hub {
+	return &Hub{
+		subs:       dict.MakeSyncDict(),
+		psubs:      dict.MakeSyncDict(),
+		subsLocker: lock.Make(16),
+	}
+}
+
+// channelSubscribers returns a copy of connections subscribing the channel
+func (hub *Hub) channelSubscribers(channel string) []resp.Connection {
+	hub.subsLocker.RLock(channel)
+	defer hub.subsLocker.RUnLock(channel)
+
+	raw, ok := hub.subs.Get(channel)
+	if !ok {
+		return nil
+	}
+	subs, _ := raw.(subscribers)
+	result := make([]resp.Connection, 0, len(subs))
+	for c := range subs {
+		result = append(result, c)
+	}
+	return result
+}
+
+// patternSubscribers returns connections subscribing patterns matching the channel, grouped by pattern
+func (hub *Hub) patternSubscribers(channel string) map[string][]resp.Connection {
+	matched := make([]string, 0)
+	hub.psubs.ForEach(func(pattern string, val interface{}) bool {
+		psubs, _ := val.(*patternSubscribers)
+		if psubs.pattern.IsMatch(channel) {
+			matched = append(matched, pattern)
+		}
+		return true
+	})
+	result := make(map[string][]resp.Connection, len(matched))
+	for _, pattern := range matched {
+		hub.subsLocker.RLock(pattern)
+		raw, ok := hub.psubs.Get(pattern)
+		if ok {
+			psubs, _ := raw.(*patternSubscribers)
+			conns := make([]resp.Connection, 0, len(psubs.subscribers))
+			for c := range psubs.subscribers {
+				conns = append(conns, c)
+			}
+			result[pattern] = conns
+		}
+		hub.subsLocker.RUnLock(pattern)
+	}
+	return result
+}
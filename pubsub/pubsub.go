@@ -0,0 +1,301 @@
+package pubsub
+
+import (
+	"go_redis_write/interface/resp"
+	"go_redis_write/lib/wildcard"
+	"go_redis_write/resp/reply"
+	"sort"
+	"strings"
+)
+
+var (
+	_subscribe    = "subscribe"
+	_unsubscribe  = "unsubscribe"
+	_psubscribe   = "psubscribe"
+	_punsubscribe = "punsubscribe"
+	messageBytes  = []byte("message")
+	pmessageBytes = []byte("pmessage")
+)
+
+//订阅和退订的回复：*3 kind channel 当前订阅数
+func makeSubscribeReply(kind string, channel []byte, count int) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(kind)),
+		reply.MakeBulkReply(channel),
+		reply.MakeIntReply(int64(count)),
+	})
+}
+
+/*
+ * invoker should lock channel
+ * return: is new subscribed
+ */
+func subscribe0(hub *Hub, channel string, client resp.Connection) bool {
+	client.Subscribe(channel)
+
+	// add into hub.subs
+	raw, ok := hub.subs.Get(channel)
+	var subs subscribers
+	if ok {
+		subs, _ = raw.(subscribers)
+	} else {
+		subs = make(subscribers)
+		hub.subs.Put(channel, subs)
+	}
+	if _, ok := subs[client]; ok {
+		return false
+	}
+	subs[client] = struct{}{}
+	return true
+}
+
+/*
+ * invoker should lock channel
+ * return: is actually un-subscribe
+ */
+func unsubscribe0(hub *Hub, channel string, client resp.Connection) bool {
+	client.UnSubscribe(channel)
+
+	// remove from hub.subs
+	raw, ok := hub.subs.Get(channel)
+	if !ok {
+		return false
+	}
+	subs, _ := raw.(subscribers)
+	if _, ok := subs[client]; !ok {
+		return false
+	}
+	delete(subs, client)
+	if len(subs) == 0 {
+		// clean
+		hub.subs.Remove(channel)
+	}
+	return true
+}
+
+// invoker should lock pattern
+func psubscribe0(hub *Hub, pattern string, client resp.Connection) bool {
+	client.PSubscribe(pattern)
+
+	raw, ok := hub.psubs.Get(pattern)
+	var psubs *patternSubscribers
+	if ok {
+		psubs, _ = raw.(*patternSubscribers)
+	} else {
+		psubs = &patternSubscribers{
+			pattern:     wildcard.CompilePattern(pattern),
+			subscribers: make(subscribers),
+		}
+		hub.psubs.Put(pattern, psubs)
+	}
+	if _, ok := psubs.subscribers[client]; ok {
+		return false
+	}
+	psubs.subscribers[client] = struct{}{}
+	return true
+}
+
+// invoker should lock pattern
+func punsubscribe0(hub *Hub, pattern string, client resp.Connection) bool {
+	client.PUnSubscribe(pattern)
+
+	raw, ok := hub.psubs.Get(pattern)
+	if !ok {
+		return false
+	}
+	psubs, _ := raw.(*patternSubscribers)
+	if _, ok := psubs.subscribers[client]; !ok {
+		return false
+	}
+	delete(psubs.subscribers, client)
+	if len(psubs.subscribers) == 0 {
+		hub.psubs.Remove(pattern)
+	}
+	return true
+}
+
+// Subscribe puts the given connection into the given channel
+func Subscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	channels := make([]string, len(args))
+	for i, b := range args {
+		channels[i] = string(b)
+	}
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	for _, channel := range channels {
+		subscribe0(hub, channel, c)
+		_ = c.Write(makeSubscribeReply(_subscribe, []byte(channel), c.SubsCount()).ToBytes())
+	}
+	return &reply.NoReply{}
+}
+
+// PSubscribe puts the given connection into the given pattern
+func PSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	patterns := make([]string, len(args))
+	for i, b := range args {
+		patterns[i] = string(b)
+	}
+
+	hub.subsLocker.Locks(patterns...)
+	defer hub.subsLocker.UnLocks(patterns...)
+
+	for _, pattern := range patterns {
+		psubscribe0(hub, pattern, c)
+		_ = c.Write(makeSubscribeReply(_psubscribe, []byte(pattern), c.SubsCount()).ToBytes())
+	}
+	return &reply.NoReply{}
+}
+
+// UnsubscribeAll removes the given connection from all channels and patterns
+func UnsubscribeAll(hub *Hub, c resp.Connection) {
+	channels := c.GetChannels()
+	hub.subsLocker.Locks(channels...)
+	for _, channel := range channels {
+		unsubscribe0(hub, channel, c)
+	}
+	hub.subsLocker.UnLocks(channels...)
+
+	patterns := c.GetPatterns()
+	hub.subsLocker.Locks(patterns...)
+	for _, pattern := range patterns {
+		punsubscribe0(hub, pattern, c)
+	}
+	hub.subsLocker.UnLocks(patterns...)
+}
+
+// UnSubscribe removes the given connection from the given channel
+// unsubscribes all channels if no channel is given
+func UnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, b := range args {
+			channels[i] = string(b)
+		}
+	} else {
+		channels = c.GetChannels()
+	}
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	if len(channels) == 0 {
+		_ = c.Write(makeSubscribeReply(_unsubscribe, nil, c.SubsCount()).ToBytes())
+		return &reply.NoReply{}
+	}
+	for _, channel := range channels {
+		unsubscribe0(hub, channel, c)
+		_ = c.Write(makeSubscribeReply(_unsubscribe, []byte(channel), c.SubsCount()).ToBytes())
+	}
+	return &reply.NoReply{}
+}
+
+// PUnSubscribe removes the given connection from the given pattern
+// unsubscribes all patterns if no pattern is given
+func PUnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, b := range args {
+			patterns[i] = string(b)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+
+	hub.subsLocker.Locks(patterns...)
+	defer hub.subsLocker.UnLocks(patterns...)
+
+	if len(patterns) == 0 {
+		_ = c.Write(makeSubscribeReply(_punsubscribe, nil, c.SubsCount()).ToBytes())
+		return &reply.NoReply{}
+	}
+	for _, pattern := range patterns {
+		punsubscribe0(hub, pattern, c)
+		_ = c.Write(makeSubscribeReply(_punsubscribe, []byte(pattern), c.SubsCount()).ToBytes())
+	}
+	return &reply.NoReply{}
+}
+
+// Publish send msg to all subscribing client, returns the number of clients received the message
+func Publish(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	channel := string(args[0])
+	message := args[1]
+
+	count := 0
+	for _, c := range hub.channelSubscribers(channel) {
+		replyArgs := [][]byte{messageBytes, args[0], message}
+		_ = c.Write(reply.MakeMultiBulkReply(replyArgs).ToBytes())
+		count++
+	}
+	for pattern, conns := range hub.patternSubscribers(channel) {
+		for _, c := range conns {
+			replyArgs := [][]byte{pmessageBytes, []byte(pattern), args[0], message}
+			_ = c.Write(reply.MakeMultiBulkReply(replyArgs).ToBytes())
+			count++
+		}
+	}
+	return reply.MakeIntReply(int64(count))
+}
+
+//PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+// PubSub returns the state of the pub/sub system
+func PubSub(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels":
+		if len(args) > 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'pubsub|channels' command")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			pattern = wildcard.CompilePattern(string(args[1]))
+		}
+		channels := make([]string, 0)
+		hub.subs.ForEach(func(channel string, val interface{}) bool {
+			if pattern == nil || pattern.IsMatch(channel) {
+				channels = append(channels, channel)
+			}
+			return true
+		})
+		sort.Strings(channels)
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "numsub":
+		result := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			count := len(hub.channelSubscribers(string(channel)))
+			result = append(result, reply.MakeBulkReply(channel), reply.MakeIntReply(int64(count)))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'pubsub|numpat' command")
+		}
+		return reply.MakeIntReply(int64(hub.psubs.Len()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB HELP.")
+}
+
+// Ping replies PING in subscribe mode, which is *2 pong [message]
+func Ping(args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("ping")
+	}
+	message := []byte{}
+	if len(args) == 1 {
+		message = args[0]
+	}
+	return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+}
@@ -0,0 +1,32 @@
+package rdb
+
+// redis 使用 crc64 jones 算法计算rdb文件的校验和，初始值和结果都不取反，和标准库的实现不同
+
+// crc64JonesPoly is the reflected form of polynomial 0xad93d23594c935a9
+const crc64JonesPoly = 0x95ac9329ac4bc9b5
+
+var crc64Table = makeCrc64Table()
+
+func makeCrc64Table() *[256]uint64 {
+	table := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ crc64JonesPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// crc64Update returns the result of adding the bytes in p to the crc
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
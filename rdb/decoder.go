@@ -0,0 +1,484 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	Dict "go_redis_write/datastruct/dict"
+	List "go_redis_write/datastruct/list"
+	HashSet "go_redis_write/datastruct/set"
+	SortedSet "go_redis_write/datastruct/sortedset"
+	"go_redis_write/interface/database"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Decoder reads data entities from rdb
+type Decoder struct {
+	reader *bufio.Reader
+	crc    uint64
+	buf    []byte
+}
+
+// NewDecoder creates a decoder reading from r
+// if r is a *bufio.Reader, data after rdb EOF is left in it, so that invoker can continue reading
+func NewDecoder(r io.Reader) *Decoder {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(r)
+	}
+	return &Decoder{
+		reader: reader,
+		buf:    make([]byte, 8),
+	}
+}
+
+// Consumer receives entities decoded from rdb, expiration is nil if the key has no ttl
+// returns false to stop parsing
+type Consumer func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) bool
+
+// IsRDB checks whether the data starts with rdb magic "REDIS"
+func IsRDB(reader *bufio.Reader) bool {
+	header, err := reader.Peek(len(magic))
+	return err == nil && string(header) == magic
+}
+
+// readFull reads exactly len(p) bytes and updates checksum
+func (dec *Decoder) readFull(p []byte) error {
+	_, err := io.ReadFull(dec.reader, p)
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dec.crc = crc64Update(dec.crc, p)
+	return nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	err := dec.readFull(dec.buf[:1])
+	if err != nil {
+		return 0, err
+	}
+	return dec.buf[0], nil
+}
+
+// Parse reads the whole rdb and passes every key to consumer
+func (dec *Decoder) Parse(consumer Consumer) error {
+	header := make([]byte, 9)
+	err := dec.readFull(header)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != magic {
+		return errors.New("rdb: wrong signature")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > Version {
+		return errors.New("rdb: can't handle rdb version " + string(header[5:]))
+	}
+
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opCode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			if version < 5 { // no checksum
+				return nil
+			}
+			expected := dec.crc
+			err = dec.readFull(dec.buf[:8])
+			if err != nil {
+				return err
+			}
+			checksum := binary.LittleEndian.Uint64(dec.buf)
+			if checksum != 0 && checksum != expected { // 0 means checksum is disabled
+				return errors.New("rdb: wrong checksum")
+			}
+			return nil
+		case opCodeSelectDB:
+			length, _, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(length)
+		case opCodeResizeDB:
+			_, _, err = dec.readLength()
+			if err != nil {
+				return err
+			}
+			_, _, err = dec.readLength()
+			if err != nil {
+				return err
+			}
+		case opCodeAux:
+			_, err = dec.readString()
+			if err != nil {
+				return err
+			}
+			_, err = dec.readString()
+			if err != nil {
+				return err
+			}
+		case opCodeExpireTimeMs:
+			err = dec.readFull(dec.buf[:8])
+			if err != nil {
+				return err
+			}
+			ms := int64(binary.LittleEndian.Uint64(dec.buf))
+			expireAt := time.Unix(0, ms*int64(time.Millisecond))
+			expiration = &expireAt
+		case opCodeExpireTime:
+			err = dec.readFull(dec.buf[:4])
+			if err != nil {
+				return err
+			}
+			expireAt := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf)), 0)
+			expiration = &expireAt
+		case opCodeIdle:
+			_, _, err = dec.readLength()
+			if err != nil {
+				return err
+			}
+		case opCodeFreq:
+			_, err = dec.readByte()
+			if err != nil {
+				return err
+			}
+		case opCodeModuleAux:
+			return errors.New("rdb: module data is not supported")
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			entity, err := dec.readObject(opCode)
+			if err != nil {
+				return fmt.Errorf("rdb: read key %s failed: %v", string(key), err)
+			}
+			if !consumer(dbIndex, string(key), entity, expiration) {
+				return nil
+			}
+			expiration = nil
+		}
+	}
+}
+
+// readLength returns length, or encoding type of special encoded string when encoded is true
+func (dec *Decoder) readLength() (length uint64, encoded bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case lenSpecial:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		err = dec.readFull(dec.buf[:4])
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(dec.buf)), false, nil
+	case len64Bit:
+		err = dec.readFull(dec.buf[:8])
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(dec.buf), false, nil
+	}
+	return 0, false, fmt.Errorf("unknown length encoding %d", first)
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, encoded, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if length > math.MaxInt32 {
+			return nil, errors.New("string is too long")
+		}
+		s := make([]byte, length)
+		err = dec.readFull(s)
+		return s, err
+	}
+	switch length {
+	case encodeInt8:
+		b, err := dec.readByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case encodeInt16:
+		err = dec.readFull(dec.buf[:2])
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(dec.buf))))), err
+	case encodeInt32:
+		err = dec.readFull(dec.buf[:4])
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(dec.buf))))), err
+	case encodeLZF:
+		compressedLen, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if compressedLen > math.MaxInt32 || rawLen > math.MaxInt32 {
+			return nil, errors.New("lzf string is too long")
+		}
+		compressed := make([]byte, compressedLen)
+		err = dec.readFull(compressed)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("unknown string encoding %d", length)
+}
+
+// readDouble reads score of zset written by old version, which is a string with 1 byte length
+func (dec *Decoder) readDouble() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	err = dec.readFull(buf)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (dec *Decoder) readBinaryDouble() (float64, error) {
+	err := dec.readFull(dec.buf[:8])
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(dec.buf)), nil
+}
+
+func (dec *Decoder) readObject(objType byte) (*database.DataEntity, error) {
+	var data interface{}
+	var err error
+	switch objType {
+	case typeString:
+		data, err = dec.readString()
+	case typeList:
+		data, err = dec.readList()
+	case typeSet:
+		data, err = dec.readSet()
+	case typeZSet, typeZSet2:
+		data, err = dec.readZSet(objType == typeZSet2)
+	case typeHash:
+		data, err = dec.readHash()
+	case typeListZipList:
+		data, err = dec.readZipListObject(func(entries [][]byte) (interface{}, error) {
+			return makeList(entries), nil
+		})
+	case typeSetIntSet:
+		data, err = dec.readIntSet()
+	case typeZSetZipList:
+		data, err = dec.readZipListObject(makeZSet)
+	case typeHashZipList:
+		data, err = dec.readZipListObject(makeHash)
+	case typeListQuickList:
+		data, err = dec.readQuickList()
+	default:
+		return nil, fmt.Errorf("unsupported object type %d", objType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &database.DataEntity{
+		Data: data,
+	}, nil
+}
+
+func (dec *Decoder) readList() (List.List, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for i := uint64(0); i < size; i++ {
+		val, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		list.Add(val)
+	}
+	return list, nil
+}
+
+func (dec *Decoder) readSet() (*HashSet.Set, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	set := HashSet.Make()
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		set.Add(string(member))
+	}
+	return set, nil
+}
+
+func (dec *Decoder) readZSet(binaryScore bool) (*SortedSet.SortedSet, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	zset := SortedSet.Make()
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			score, err = dec.readBinaryDouble()
+		} else {
+			score, err = dec.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		zset.Add(string(member), score)
+	}
+	return zset, nil
+}
+
+func (dec *Decoder) readHash() (Dict.Dict, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := Dict.MakeSimple()
+	for i := uint64(0); i < size; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Put(string(field), value)
+	}
+	return hash, nil
+}
+
+func (dec *Decoder) readZipListObject(build func(entries [][]byte) (interface{}, error)) (interface{}, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseZipList(buf)
+	if err != nil {
+		return nil, err
+	}
+	return build(entries)
+}
+
+func (dec *Decoder) readIntSet() (*HashSet.Set, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	members, err := parseIntSet(buf)
+	if err != nil {
+		return nil, err
+	}
+	set := HashSet.Make()
+	for _, member := range members {
+		set.Add(string(member))
+	}
+	return set, nil
+}
+
+// readQuickList reads list which is stored as a list of ziplist
+func (dec *Decoder) readQuickList() (List.List, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for i := uint64(0); i < size; i++ {
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseZipList(buf)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			list.Add(entry)
+		}
+	}
+	return list, nil
+}
+
+func makeList(entries [][]byte) List.List {
+	list := List.NewQuickList()
+	for _, entry := range entries {
+		list.Add(entry)
+	}
+	return list
+}
+
+// makeZSet builds zset from ziplist entries: member1 score1 member2 score2 ...
+func makeZSet(entries [][]byte) (interface{}, error) {
+	if len(entries)%2 != 0 {
+		return nil, errors.New("zset ziplist has odd number of entries")
+	}
+	zset := SortedSet.Make()
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		zset.Add(string(entries[i]), score)
+	}
+	return zset, nil
+}
+
+// makeHash builds hash from ziplist entries: field1 value1 field2 value2 ...
+func makeHash(entries [][]byte) (interface{}, error) {
+	if len(entries)%2 != 0 {
+		return nil, errors.New("hash ziplist has odd number of entries")
+	}
+	hash := Dict.MakeSimple()
+	for i := 0; i < len(entries); i += 2 {
+		hash.Put(string(entries[i]), entries[i+1])
+	}
+	return hash, nil
+}
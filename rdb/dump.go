@@ -0,0 +1,69 @@
+package rdb
+
+import (
+	"go_redis_write/interface/database"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Source provides the keys to dump, database.DBEngine implements it
+type Source interface {
+	ForEach(dbIndex int, cb func(key string, data *database.DataEntity, expiration *time.Time) bool)
+}
+
+// Dump writes all keys of the engine into w in rdb format, invoker should prevent the engine from being modified
+func Dump(w io.Writer, engine Source, dbCount int) error {
+	return dump(w, engine, dbCount, nil)
+}
+
+// DumpAofPreamble writes rdb as the preamble of aof file, aof commands can be appended after it
+func DumpAofPreamble(w io.Writer, engine Source, dbCount int) error {
+	return dump(w, engine, dbCount, [][2]string{{"aof-preamble", "1"}})
+}
+
+func dump(w io.Writer, engine Source, dbCount int, extraAux [][2]string) error {
+	enc := NewEncoder(w)
+	err := enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+	aux := [][2]string{
+		{"redis-ver", "6.0.0"},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	aux = append(aux, extraAux...)
+	for _, kv := range aux {
+		err = enc.WriteAux(kv[0], kv[1])
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < dbCount; i++ {
+		// count keys for RESIZEDB
+		var keyCount, ttlCount uint64
+		engine.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			keyCount++
+			if expiration != nil {
+				ttlCount++
+			}
+			return true
+		})
+		if keyCount == 0 {
+			continue
+		}
+		err = enc.WriteDBHeader(i, keyCount, ttlCount)
+		if err != nil {
+			return err
+		}
+		engine.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			err = enc.WriteEntity(key, entity, expiration)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return enc.WriteEnd()
+}
@@ -0,0 +1,248 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	Dict "go_redis_write/datastruct/dict"
+	List "go_redis_write/datastruct/list"
+	HashSet "go_redis_write/datastruct/set"
+	SortedSet "go_redis_write/datastruct/sortedset"
+	"go_redis_write/interface/database"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Encoder writes data entities into rdb format
+type Encoder struct {
+	writer *bufio.Writer
+	crc    uint64
+	buf    []byte
+}
+
+// NewEncoder creates an encoder writing to w, invoker should call WriteEnd to flush data
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		writer: bufio.NewWriter(w),
+		buf:    make([]byte, 9),
+	}
+}
+
+// write writes p and updates checksum
+func (enc *Encoder) write(p []byte) error {
+	_, err := enc.writer.Write(p)
+	if err != nil {
+		return err
+	}
+	enc.crc = crc64Update(enc.crc, p)
+	return nil
+}
+
+func (enc *Encoder) writeByte(b byte) error {
+	enc.buf[0] = b
+	return enc.write(enc.buf[:1])
+}
+
+// WriteHeader writes magic and version
+func (enc *Encoder) WriteHeader() error {
+	return enc.write([]byte(magic + "000" + strconv.Itoa(Version)))
+}
+
+// WriteAux writes an auxiliary field like redis-ver
+func (enc *Encoder) WriteAux(key string, value string) error {
+	err := enc.writeByte(opCodeAux)
+	if err != nil {
+		return err
+	}
+	err = enc.writeString([]byte(key))
+	if err != nil {
+		return err
+	}
+	return enc.writeString([]byte(value))
+}
+
+// WriteDBHeader writes SELECTDB and RESIZEDB before keys of a db
+func (enc *Encoder) WriteDBHeader(dbIndex int, keyCount, ttlCount uint64) error {
+	err := enc.writeByte(opCodeSelectDB)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(dbIndex))
+	if err != nil {
+		return err
+	}
+	err = enc.writeByte(opCodeResizeDB)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(keyCount)
+	if err != nil {
+		return err
+	}
+	return enc.writeLength(ttlCount)
+}
+
+// WriteEntity writes a key with its value and expiration, expiration is nil if the key has no ttl
+func (enc *Encoder) WriteEntity(key string, entity *database.DataEntity, expiration *time.Time) error {
+	if expiration != nil {
+		err := enc.writeByte(opCodeExpireTimeMs)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf, uint64(expiration.UnixNano()/int64(time.Millisecond)))
+		err = enc.write(enc.buf[:8])
+		if err != nil {
+			return err
+		}
+	}
+	objType, ok := objectType(entity)
+	if !ok {
+		return errors.New("unknown data type of key " + key)
+	}
+	err := enc.writeByte(objType)
+	if err != nil {
+		return err
+	}
+	err = enc.writeString([]byte(key))
+	if err != nil {
+		return err
+	}
+	return enc.writeValue(entity)
+}
+
+// objectType returns the rdb type of the entity
+func objectType(entity *database.DataEntity) (byte, bool) {
+	switch entity.Data.(type) {
+	case []byte:
+		return typeString, true
+	case List.List:
+		return typeList, true
+	case Dict.Dict:
+		return typeHash, true
+	case *HashSet.Set:
+		return typeSet, true
+	case *SortedSet.SortedSet:
+		return typeZSet2, true
+	}
+	return 0, false
+}
+
+// writeValue writes value of the entity without type and key
+func (enc *Encoder) writeValue(entity *database.DataEntity) error {
+	switch val := entity.Data.(type) {
+	case []byte:
+		return enc.writeString(val)
+	case List.List:
+		return enc.writeList(val)
+	case Dict.Dict:
+		return enc.writeHash(val)
+	case *HashSet.Set:
+		return enc.writeSet(val)
+	case *SortedSet.SortedSet:
+		return enc.writeZSet(val)
+	}
+	return errors.New("unknown data type")
+}
+
+// WriteEnd writes EOF and checksum, then flushes buffered data
+func (enc *Encoder) WriteEnd() error {
+	err := enc.writeByte(opCodeEOF)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buf, enc.crc)
+	_, err = enc.writer.Write(enc.buf[:8]) // checksum is not included in itself
+	if err != nil {
+		return err
+	}
+	return enc.writer.Flush()
+}
+
+func (enc *Encoder) writeLength(length uint64) error {
+	switch {
+	case length < 1<<6:
+		return enc.writeByte(byte(length))
+	case length < 1<<14:
+		enc.buf[0] = byte(len14Bit<<6) | byte(length>>8)
+		enc.buf[1] = byte(length)
+		return enc.write(enc.buf[:2])
+	case length <= math.MaxUint32:
+		enc.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(enc.buf[1:], uint32(length))
+		return enc.write(enc.buf[:5])
+	default:
+		enc.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(enc.buf[1:], length)
+		return enc.write(enc.buf[:9])
+	}
+}
+
+func (enc *Encoder) writeString(s []byte) error {
+	err := enc.writeLength(uint64(len(s)))
+	if err != nil {
+		return err
+	}
+	return enc.write(s)
+}
+
+func (enc *Encoder) writeList(list List.List) error {
+	err := enc.writeLength(uint64(list.Len()))
+	if err != nil {
+		return err
+	}
+	list.ForEach(func(i int, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		err = enc.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeHash(hash Dict.Dict) error {
+	err := enc.writeLength(uint64(hash.Len()))
+	if err != nil {
+		return err
+	}
+	hash.ForEach(func(field string, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		err = enc.writeString([]byte(field))
+		if err != nil {
+			return false
+		}
+		err = enc.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeSet(set *HashSet.Set) error {
+	err := enc.writeLength(uint64(set.Len()))
+	if err != nil {
+		return err
+	}
+	set.ForEach(func(member string) bool {
+		err = enc.writeString([]byte(member))
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeZSet(zset *SortedSet.SortedSet) error {
+	size := zset.Len()
+	err := enc.writeLength(uint64(size))
+	if err != nil || size == 0 {
+		return err
+	}
+	zset.ForEach(0, size, false, func(element *SortedSet.Element) bool {
+		err = enc.writeString([]byte(element.Member))
+		if err != nil {
+			return false
+		}
+		binary.LittleEndian.PutUint64(enc.buf, math.Float64bits(element.Score))
+		err = enc.write(enc.buf[:8])
+		return err == nil
+	})
+	return err
+}
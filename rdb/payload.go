@@ -0,0 +1,67 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"go_redis_write/interface/database"
+)
+
+//序列化单个value，格式和redis的DUMP命令一致: 类型 value rdb版本(2字节) crc64(8字节)
+
+// DumpPayload serializes the value of an entity in the format of DUMP command
+func DumpPayload(entity *database.DataEntity) ([]byte, error) {
+	objType, ok := objectType(entity)
+	if !ok {
+		return nil, errors.New("unknown data type")
+	}
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	err := enc.writeByte(objType)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writeValue(entity)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writer.Flush()
+	if err != nil {
+		return nil, err
+	}
+	footer := make([]byte, 10)
+	binary.LittleEndian.PutUint16(footer, Version)
+	buf.Write(footer[:2])
+	binary.LittleEndian.PutUint64(footer[2:], crc64Update(0, buf.Bytes()))
+	buf.Write(footer[2:])
+	return buf.Bytes(), nil
+}
+
+// LoadPayload deserializes a value made by DumpPayload, it checks rdb version and checksum
+func LoadPayload(payload []byte) (*database.DataEntity, error) {
+	if len(payload) < 11 {
+		return nil, errors.New("payload is too short")
+	}
+	body := payload[:len(payload)-8]
+	checksum := binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if crc64Update(0, body) != checksum {
+		return nil, errors.New("payload checksum mismatch")
+	}
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version > Version {
+		return nil, errors.New("payload version is not supported")
+	}
+	dec := NewDecoder(bytes.NewReader(body[:len(body)-2]))
+	objType, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+	entity, err := dec.readObject(objType)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dec.reader.ReadByte(); err == nil {
+		return nil, errors.New("unexpected data after value")
+	}
+	return entity, nil
+}
@@ -0,0 +1,54 @@
+// Package rdb reads and writes redis compatible rdb snapshot
+package rdb
+
+// rdb文件结构: "REDIS0009" [辅助字段] {SELECTDB n RESIZEDB size expires {[过期时间] 类型 key value}} EOF 校验和
+
+const (
+	// Version is the rdb version written by Encoder
+	Version = 9
+
+	magic = "REDIS"
+)
+
+// opcodes
+const (
+	opCodeModuleAux    = 247 // module auxiliary data
+	opCodeIdle         = 248 // LRU idle time
+	opCodeFreq         = 249 // LFU frequency
+	opCodeAux          = 250 // auxiliary field
+	opCodeResizeDB     = 251 // hash table resize hint
+	opCodeExpireTimeMs = 252 // expire time in milliseconds
+	opCodeExpireTime   = 253 // expire time in seconds
+	opCodeSelectDB     = 254 // db number of the following keys
+	opCodeEOF          = 255 // end of the rdb file
+)
+
+// object types
+const (
+	typeString        = 0
+	typeList          = 1
+	typeSet           = 2
+	typeZSet          = 3
+	typeHash          = 4
+	typeZSet2         = 5 // zset with binary double score
+	typeListZipList   = 10
+	typeSetIntSet     = 11
+	typeZSetZipList   = 12
+	typeHashZipList   = 13
+	typeListQuickList = 14
+)
+
+// length encoding
+const (
+	len6Bit      = 0
+	len14Bit     = 1
+	len32or64Bit = 2
+	lenSpecial   = 3
+	len32Bit     = 0x80
+	len64Bit     = 0x81
+
+	encodeInt8  = 0
+	encodeInt16 = 1
+	encodeInt32 = 2
+	encodeLZF   = 3
+)
@@ -0,0 +1,182 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// redis 会把较小的list/hash/zset/set用紧凑格式保存，这里解析这些格式
+
+var errBadZipList = errors.New("rdb: invalid ziplist")
+
+// parseZipList returns entries in the ziplist, integers are converted to strings
+// ziplist: <zlbytes uint32> <zltail uint32> <zllen uint16> <entry>... <0xff>
+func parseZipList(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errBadZipList
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(buf[8:10]))
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, errBadZipList
+		}
+		if buf[pos] == 0xff {
+			return entries, nil
+		}
+		// skip length of previous entry
+		if buf[pos] < 254 {
+			pos++
+		} else {
+			pos += 5
+		}
+		if pos >= len(buf) {
+			return nil, errBadZipList
+		}
+		entry, n, err := parseZipListEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		pos += n
+	}
+}
+
+// parseZipListEntry parses encoding and content of an entry, returns the entry and consumed bytes
+func parseZipListEntry(buf []byte) ([]byte, int, error) {
+	encoding := buf[0]
+	var length, header int
+	switch encoding >> 6 {
+	case 0: // 00pppppp
+		length, header = int(encoding&0x3f), 1
+	case 1: // 01pppppp qqqqqqqq
+		if len(buf) < 2 {
+			return nil, 0, errBadZipList
+		}
+		length, header = int(encoding&0x3f)<<8|int(buf[1]), 2
+	case 2: // 10000000 + 4 bytes big endian
+		if len(buf) < 5 {
+			return nil, 0, errBadZipList
+		}
+		length, header = int(binary.BigEndian.Uint32(buf[1:5])), 5
+	default: // integer
+		var val int64
+		var size int
+		switch encoding {
+		case 0xc0:
+			size = 2
+		case 0xd0:
+			size = 4
+		case 0xe0:
+			size = 8
+		case 0xf0:
+			size = 3
+		case 0xfe:
+			size = 1
+		default:
+			if encoding < 0xf1 || encoding > 0xfd {
+				return nil, 0, errBadZipList
+			}
+			// 1111xxxx, xxxx between 0001 and 1101 means 0 to 12
+			return []byte(strconv.Itoa(int(encoding&0x0f) - 1)), 1, nil
+		}
+		if len(buf) < 1+size {
+			return nil, 0, errBadZipList
+		}
+		content := buf[1 : 1+size]
+		switch size {
+		case 1:
+			val = int64(int8(content[0]))
+		case 2:
+			val = int64(int16(binary.LittleEndian.Uint16(content)))
+		case 3:
+			// 24 bit signed integer
+			val = int64(int32(uint32(content[0])<<8|uint32(content[1])<<16|uint32(content[2])<<24) >> 8)
+		case 4:
+			val = int64(int32(binary.LittleEndian.Uint32(content)))
+		case 8:
+			val = int64(binary.LittleEndian.Uint64(content))
+		}
+		return []byte(strconv.FormatInt(val, 10)), 1 + size, nil
+	}
+	if length < 0 || len(buf) < header+length {
+		return nil, 0, errBadZipList
+	}
+	entry := make([]byte, length)
+	copy(entry, buf[header:header+length])
+	return entry, header + length, nil
+}
+
+// parseIntSet returns members of intset as strings
+// intset: <encoding uint32> <length uint32> <contents>
+func parseIntSet(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, errors.New("rdb: invalid intset")
+	}
+	size := int(binary.LittleEndian.Uint32(buf[0:4]))
+	length := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if (size != 2 && size != 4 && size != 8) || length < 0 || len(buf) < 8+size*length {
+		return nil, errors.New("rdb: invalid intset")
+	}
+	members := make([][]byte, length)
+	for i := 0; i < length; i++ {
+		content := buf[8+i*size : 8+(i+1)*size]
+		var val int64
+		switch size {
+		case 2:
+			val = int64(int16(binary.LittleEndian.Uint16(content)))
+		case 4:
+			val = int64(int32(binary.LittleEndian.Uint32(content)))
+		case 8:
+			val = int64(binary.LittleEndian.Uint64(content))
+		}
+		members[i] = []byte(strconv.FormatInt(val, 10))
+	}
+	return members, nil
+}
+
+// lzfDecompress decompresses lzf compressed string
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	errBadLzf := errors.New("rdb: invalid lzf string")
+	out := make([]byte, 0, outLen)
+	i := 0
+	for i < len(in) {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 { // literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errBadLzf
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errBadLzf
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errBadLzf
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errBadLzf
+		}
+		length += 2
+		for j := 0; j < length; j++ { // may overlap, copy one by one
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errBadLzf
+	}
+	return out, nil
+}
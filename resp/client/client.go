@@ -9,6 +9,7 @@ import (
 	"net"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +22,8 @@ type Client struct {
 	addr        string
 
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
+	// 1 after a request timed out or failed or the connection was closed, atomic
+	broken int32
 }
 
 // request is a message sends to redis server
@@ -128,14 +131,22 @@ func (client *Client) Send(args [][]byte) resp.Reply {
 	client.pendingReqs <- request
 	timeout := request.waiting.WaitWithTimeout(maxWait)
 	if timeout {
+		atomic.StoreInt32(&client.broken, 1)
 		return reply.MakeErrReply("server time out")
 	}
 	if request.err != nil {
+		atomic.StoreInt32(&client.broken, 1)
 		return reply.MakeErrReply("request failed")
 	}
 	return request.reply
 }
 
+// Broken returns true if a request timed out or failed, or the connection was closed by server.
+// replies of following requests may be out of order, so the client should be closed instead of reused
+func (client *Client) Broken() bool {
+	return atomic.LoadInt32(&client.broken) == 1
+}
+
 func (client *Client) doHeartbeat() {
 	request := &request{
 		args:      [][]byte{[]byte("PING")},
@@ -198,5 +209,6 @@ func (client *Client) handleRead() error {
 		}
 		client.finishRequest(payload.Data)
 	}
+	atomic.StoreInt32(&client.broken, 1) // connection is closed
 	return nil
 }
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"go_redis_write/lib/utils"
+	"go_redis_write/resp/parser"
+	"go_redis_write/resp/reply"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//复制连接：从节点用它和主节点握手，接收PSYNC返回的rdb快照，然后接收主节点持续发来的写命令
+//握手阶段是一问一答的，和Client的pipeline模式不同
+
+const replDialTimeout = 5 * time.Second
+
+// ReplClient is the connection from replica to master
+type ReplClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// MakeReplClient connects to master
+func MakeReplClient(addr string) (*ReplClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, replDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplClient{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+// Send writes a command to master without waiting for reply
+func (client *ReplClient) Send(args ...string) error {
+	_ = client.conn.SetWriteDeadline(time.Now().Add(replDialTimeout))
+	_, err := client.conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes())
+	return err
+}
+
+// Request sends a command and reads the single line reply, error reply is returned as error
+func (client *ReplClient) Request(timeout time.Duration, args ...string) (string, error) {
+	err := client.Send(args...)
+	if err != nil {
+		return "", err
+	}
+	_ = client.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer func() {
+		_ = client.conn.SetReadDeadline(time.Time{})
+	}()
+	line, err := client.readLine()
+	if err != nil {
+		return "", err
+	}
+	if line[0] == '-' {
+		return "", errors.New(line[1:])
+	}
+	if line[0] != '+' && line[0] != ':' {
+		return "", errors.New("unexpected reply: " + line)
+	}
+	return line[1:], nil
+}
+
+// readLine reads a line without CRLF, empty lines sent by master as keepalive are skipped
+func (client *ReplClient) readLine() (string, error) {
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// ReadSnapshot reads the rdb sent after FULLRESYNC, it is a bulk string without CRLF at the end
+// the returned reader must be read to the end before reading commands
+func (client *ReplClient) ReadSnapshot(timeout time.Duration) (io.Reader, int64, error) {
+	_ = client.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer func() {
+		_ = client.conn.SetReadDeadline(time.Time{})
+	}()
+	line, err := client.readLine()
+	if err != nil {
+		return nil, 0, err
+	}
+	if line[0] == '-' {
+		return nil, 0, errors.New(line[1:])
+	}
+	if line[0] != '$' {
+		return nil, 0, errors.New("unexpected snapshot header: " + line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return nil, 0, errors.New("invalid snapshot size: " + line)
+	}
+	return io.LimitReader(client.reader, size), size, nil
+}
+
+// CommandStream parses commands sent by master after snapshot
+func (client *ReplClient) CommandStream() <-chan *parser.Payload {
+	return parser.ParseStream(client.reader)
+}
+
+// SetReadDeadline sets deadline of reading commands, master pings replica periodically to keep it alive
+func (client *ReplClient) SetReadDeadline(t time.Time) error {
+	return client.conn.SetReadDeadline(t)
+}
+
+// Close closes the connection, command stream will be closed too
+func (client *ReplClient) Close() error {
+	return client.conn.Close()
+}
@@ -17,6 +17,21 @@ type Connection struct {
 	mu sync.Mutex
 	// selected db
 	selectedDB int
+
+	// subscribing channels and patterns
+	subs  map[string]bool
+	psubs map[string]bool
+
+	// multi state: queued commands are executed by EXEC
+	multiState bool
+	queue      [][][]byte
+	// key -> version when WATCH is called
+	watching map[string]uint32
+	// errors found while queuing, EXEC aborts if there is any
+	txErrors []error
+
+	// set by ASKING, only valid for the next command
+	asking bool
 }
 
 func NewConn(conn net.Conn) *Connection {
@@ -63,6 +78,140 @@ func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
 
+// Subscribe add current connection into subscribers of the given channel
+func (c *Connection) Subscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subs == nil {
+		c.subs = make(map[string]bool)
+	}
+	c.subs[channel] = true
+}
+
+// UnSubscribe removes current connection into subscribers of the given channel
+func (c *Connection) UnSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.subs) == 0 {
+		return
+	}
+	delete(c.subs, channel)
+}
+
+// PSubscribe add current connection into subscribers of the given pattern
+func (c *Connection) PSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.psubs == nil {
+		c.psubs = make(map[string]bool)
+	}
+	c.psubs[pattern] = true
+}
+
+// PUnSubscribe removes current connection into subscribers of the given pattern
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.psubs) == 0 {
+		return
+	}
+	delete(c.psubs, pattern)
+}
+
+// SubsCount returns the number of subscribing channels and patterns
+func (c *Connection) SubsCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.subs) + len(c.psubs)
+}
+
+// GetChannels returns all subscribing channels
+func (c *Connection) GetChannels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// GetPatterns returns all subscribing patterns
+func (c *Connection) GetPatterns() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	patterns := make([]string, 0, len(c.psubs))
+	for pattern := range c.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// InMultiState tells is connection in an uncommitted transaction
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState sets transaction flag, leaving multi state clears queued commands and watched keys
+func (c *Connection) SetMultiState(state bool) {
+	if !state { // reset data when cancel multi
+		c.watching = nil
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+// GetQueuedCmdLine returns queued commands of current transaction
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd enqueues command of current transaction
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// ClearQueuedCmds clears queued commands of current transaction
+func (c *Connection) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+// GetWatching returns watching keys and their version code when started watching
+func (c *Connection) GetWatching() map[string]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[string]uint32)
+	}
+	return c.watching
+}
+
+// AddTxError stores syntax error within transaction
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors returns syntax error within transaction
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+// SetAsking sets the flag of ASKING
+func (c *Connection) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+// IsAsking tells whether the client sent ASKING before current command
+func (c *Connection) IsAsking() bool {
+	return c.asking
+}
+
 // FakeConn implements redis.Connection for test
 type FakeConn struct {
 	Connection
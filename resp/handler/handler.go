@@ -31,10 +31,9 @@ type RespHandler struct {
 
 // MakeHandler creates a RespHandler instance
 func MakeHandler() *RespHandler {
-	var db databaseface.Database
-	db = database.NewStandaloneDatabase() //实现一个回复的接口
+	var db databaseface.Database //实现一个回复的接口
 	if config.Properties.Self != "" &&
-		len(config.Properties.Peers) > 0 {
+		(len(config.Properties.Peers) > 0 || config.Properties.ClusterEnabled) {
 		db = cluster.MakeClusterDatabase()
 	} else {
 		db = database.NewStandaloneDatabase()
@@ -23,6 +23,7 @@ type readState struct {
 	msgType           byte
 	args              [][]byte //有多少组数据块
 	bulkLen           int64    //预设要读的字符，就是$后面的值
+	readingBulk       bool     //下一行是$后面的字符串内容，长度为bulkLen（可以是0）
 }
 
 func (s *readState) finished() bool { //返回我们这个解析器有没有完成
@@ -152,12 +153,12 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	var msg []byte
 	var err error
-	if state.bulkLen == 0 { // read normal line
+	if !state.readingBulk { // read normal line
 		msg, err = bufReader.ReadBytes('\n')
 		if err != nil {
 			return nil, true, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
+		if len(msg) < 2 || msg[len(msg)-2] != '\r' {
 			return nil, false, errors.New("protocol error: " + string(msg)) //协议错误，协议出现问题了
 		}
 	} else { // read bulk line (binary safe) 只
@@ -207,9 +208,10 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.bulkLen == -1 { // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 {
 		state.msgType = msg[0]
 		state.readingMultiLine = true
+		state.readingBulk = true
 		state.expectedArgsCount = 1
 		state.args = make([][]byte, 0, 1)
 		return nil
@@ -249,15 +251,25 @@ func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 func readBody(msg []byte, state *readState) error {
 	line := msg[0 : len(msg)-2]
 	var err error
+	if state.readingBulk { // bulk body is binary safe, even if it starts with '$'
+		state.args = append(state.args, line)
+		state.readingBulk = false
+		return nil
+	}
+	if len(line) == 0 {
+		return errors.New("protocol error: " + string(msg))
+	}
 	if line[0] == '$' {
 		// bulk reply
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
 		if err != nil {
 			return errors.New("protocol error: " + string(msg))
 		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
-			state.args = append(state.args, []byte{})
+		if state.bulkLen < 0 { // null bulk in multi bulks, keep it nil so relayed replies like MGET are unchanged
+			state.args = append(state.args, nil)
 			state.bulkLen = 0
+		} else {
+			state.readingBulk = true // bulk body may be empty string when bulkLen is 0
 		}
 	} else {
 		state.args = append(state.args, line)
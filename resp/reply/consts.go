@@ -67,3 +67,37 @@ var noBytes = []byte("")
 func (r *NoReply) ToBytes() []byte {
 	return noBytes
 }
+
+//回复空数组(null)，比如EXEC时WATCH的key被修改了
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+// NullMultiBulkReply is a null list
+type NullMultiBulkReply struct{}
+
+// ToBytes marshal redis.Reply
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+// MakeNullMultiBulkReply creates NullMultiBulkReply
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
+//事务中命令入队后的回复
+var queuedBytes = []byte("+QUEUED\r\n")
+
+// QueuedReply is +QUEUED
+type QueuedReply struct{}
+
+// ToBytes marshal redis.Reply
+func (r *QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+var theQueuedReply = new(QueuedReply)
+
+// MakeQueuedReply returns a QUEUED reply
+func MakeQueuedReply() *QueuedReply {
+	return theQueuedReply
+}
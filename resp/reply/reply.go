@@ -7,7 +7,6 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
 	// CRLF is the line separator of redis serialization protocol 通信协议的结尾
 	CRLF = "\r\n"
 )
@@ -21,8 +20,8 @@ type BulkReply struct {
 }
 
 func (b BulkReply) ToBytes() []byte {
-	if len(b.Arg) == 0 {
-		return nullBulkReplyBytes
+	if b.Arg == nil { //nil表示空回复，[]byte{}则是空字符串
+		return nullBulkBytes
 	}
 	return []byte("$" + strconv.Itoa(len(b.Arg)) + CRLF + string(b.Arg) + CRLF)
 }
@@ -58,6 +57,32 @@ func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
 	}
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply stores a list of replies of any type
+// 数组里面的元素可以是任意的回复类型，比如SCAN的回复 [cursor, [key1, key2]]
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 // StatusReply stores a simple status string 状态回复
@@ -18,7 +18,7 @@ type Config struct {
 
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	sigChan := make(chan os.Signal) //系统监听的信号
+	sigChan := make(chan os.Signal, 1) //系统监听的信号
 	//注册信号转发给sigChan
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	//当出现这个信号的时候，需要把信号加入到closechan中完成关闭